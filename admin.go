@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/config"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+)
+
+// Admin endpoints used to move targets away from a collector before it is rolled
+
+func cordonHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := lb.Cordon(mux.Vars(r)["name"])
+	writeCollectorStatus(w, status, err)
+}
+
+func uncordonHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := lb.Uncordon(mux.Vars(r)["name"])
+	writeCollectorStatus(w, status, err)
+}
+
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := lb.Drain(mux.Vars(r)["name"])
+	writeCollectorStatus(w, status, err)
+}
+
+func writeCollectorStatus(w http.ResponseWriter, status loadbalancer.CollectorStatus, err error) {
+	switch {
+	case errors.Is(err, loadbalancer.ErrCollectorNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, loadbalancer.ErrNoSchedulableCollector):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+func pinsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.PinStatus())
+}
+
+func addPinHandler(w http.ResponseWriter, r *http.Request) {
+	var pin config.Pin
+	if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := lb.AddPin(pin); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pinsHandler(w, r)
+}
+
+func removePinHandler(w http.ResponseWriter, r *http.Request) {
+	err := lb.RemovePin(r.URL.Query().Get("target"))
+	if errors.Is(err, loadbalancer.ErrPinNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	pinsHandler(w, r)
+}
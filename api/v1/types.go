@@ -0,0 +1,225 @@
+// Package v1 holds the response schema of the /api/v1 routes
+// The types are decoupled from the mode package so its internals can change without breaking consumers;
+// fields may be added but never renamed or removed
+package v1
+
+import (
+	"sort"
+	"time"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+)
+
+// Prefix is the path every v1 route is served under
+const Prefix = "/api/v1"
+
+type JobList struct {
+	Jobs []Job `json:"jobs"`
+}
+
+type Job struct {
+	Name       string `json:"name"`
+	TargetsURL string `json:"targets_url"`
+}
+
+// TargetGroup is a Prometheus HTTP SD target group
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// JobTargets lists the targets of a job by collector, at the given assignment index
+type JobTargets struct {
+	Job        string             `json:"job"`
+	Index      uint64             `json:"index"`
+	Collectors []CollectorTargets `json:"collectors"`
+}
+
+type CollectorTargets struct {
+	Collector    string        `json:"collector"`
+	TargetsURL   string        `json:"targets_url"`
+	TargetGroups []TargetGroup `json:"target_groups"`
+}
+
+type CollectorList struct {
+	Collectors []Collector `json:"collectors"`
+}
+
+type Collector struct {
+	Name       string         `json:"name"`
+	State      string         `json:"state"`
+	Targets    int            `json:"targets"`
+	Jobs       map[string]int `json:"jobs"`
+	Pools      []string       `json:"pools"`
+	LastSeen   *time.Time     `json:"last_seen"`
+	TargetsURL string         `json:"targets_url"`
+}
+
+// Status summarizes the current allocation
+type Status struct {
+	Generation   uint64 `json:"generation"`
+	Jobs         int    `json:"jobs"`
+	Collectors   int    `json:"collectors"`
+	Targets      int    `json:"targets"`
+	Pins         int    `json:"pins"`
+	PinConflicts int    `json:"pin_conflicts"`
+	LastEventID  uint64 `json:"last_event_id"`
+}
+
+// Config is the loaded configuration without any secret
+type Config struct {
+	Mode          string            `json:"mode"`
+	LabelSelector map[string]string `json:"label_selector"`
+	ScrapeJobs    []string          `json:"scrape_jobs"`
+	// Allocation holds the allocation of every scrape job, the one of the mode unless the job overrides it
+	Allocation map[string]Allocation `json:"allocation"`
+	Pins       []Pin                 `json:"pins"`
+	Auth       *Auth                 `json:"auth"`
+}
+
+type Allocation struct {
+	Pool         string   `json:"pool"`
+	Mode         string   `json:"mode"`
+	VirtualNodes int      `json:"virtual_nodes,omitempty"`
+	HashLabels   []string `json:"hash_labels,omitempty"`
+}
+
+type Pin struct {
+	Target    string `json:"target"`
+	Collector string `json:"collector"`
+}
+
+// Auth lists who may call the API; tokens are listed by name and passwords are left out
+type Auth struct {
+	Tokens                []string `json:"tokens"`
+	BasicAuthUsers        []string `json:"basic_auth_users"`
+	KubernetesTokenReview bool     `json:"kubernetes_token_review"`
+	ClientCertificates    bool     `json:"client_certificates"`
+	Admins                []string `json:"admins"`
+}
+
+// JobTargetsURL is the v1 link to the targets of a job
+func JobTargetsURL(job string) string {
+	return Prefix + "/jobs/" + job + "/targets"
+}
+
+// CollectorTargetsURL is the v1 link to the HTTP SD document of a collector for one job
+func CollectorTargetsURL(collector, job string) string {
+	return Prefix + "/collectors/" + collector + "/targets?job=" + job
+}
+
+func NewJobList(jobs []string) JobList {
+	list := JobList{Jobs: make([]Job, 0, len(jobs))}
+	for _, name := range sorted(jobs) {
+		list.Jobs = append(list.Jobs, Job{Name: name, TargetsURL: JobTargetsURL(name)})
+	}
+	return list
+}
+
+func NewTargetGroups(tgs []lbdiscovery.TargetGroup) []TargetGroup {
+	groups := make([]TargetGroup, 0, len(tgs))
+	for _, tg := range tgs {
+		labels := make(map[string]string, len(tg.Labels))
+		for k, v := range tg.Labels {
+			labels[string(k)] = string(v)
+		}
+		targets := tg.Targets
+		if targets == nil {
+			targets = []string{}
+		}
+		groups = append(groups, TargetGroup{Targets: targets, Labels: labels})
+	}
+	return groups
+}
+
+// NewJobTargets builds the targets of the job from its target groups keyed by collector
+func NewJobTargets(job string, index uint64, byCollector map[string][]lbdiscovery.TargetGroup) JobTargets {
+	names := make([]string, 0, len(byCollector))
+	for name := range byCollector {
+		names = append(names, name)
+	}
+	targets := JobTargets{Job: job, Index: index, Collectors: make([]CollectorTargets, 0, len(names))}
+	for _, name := range sorted(names) {
+		targets.Collectors = append(targets.Collectors, CollectorTargets{
+			Collector:    name,
+			TargetsURL:   CollectorTargetsURL(name, job),
+			TargetGroups: NewTargetGroups(byCollector[name]),
+		})
+	}
+	return targets
+}
+
+func NewCollector(s loadbalancer.CollectorStatus) Collector {
+	jobs := make(map[string]int, len(s.Jobs))
+	for job, n := range s.Jobs {
+		jobs[job] = n
+	}
+	return Collector{
+		Name:       s.Name,
+		State:      s.State.String(),
+		Targets:    s.NumTargets,
+		Jobs:       jobs,
+		Pools:      append([]string{}, s.Pools...),
+		LastSeen:   s.LastSeen,
+		TargetsURL: Prefix + "/collectors/" + s.Name + "/targets",
+	}
+}
+
+func NewCollectorList(statuses []loadbalancer.CollectorStatus) CollectorList {
+	list := CollectorList{Collectors: make([]Collector, 0, len(statuses))}
+	for _, s := range statuses {
+		list.Collectors = append(list.Collectors, NewCollector(s))
+	}
+	return list
+}
+
+func NewConfig(cfg config.Config) Config {
+	c := Config{
+		Mode:          cfg.Mode,
+		LabelSelector: make(map[string]string, len(cfg.LabelSelector)),
+		ScrapeJobs:    []string{},
+		Allocation:    make(map[string]Allocation, len(cfg.Config.ScrapeConfigs)),
+		Pins:          make([]Pin, 0, len(cfg.Pins)),
+	}
+	for k, v := range cfg.LabelSelector {
+		c.LabelSelector[k] = v
+	}
+	for _, sc := range cfg.Config.ScrapeConfigs {
+		c.ScrapeJobs = append(c.ScrapeJobs, sc.JobName)
+		allocation := cfg.JobAllocation(sc.JobName)
+		c.Allocation[sc.JobName] = Allocation{
+			Pool:         config.JobPool(cfg.Pools, sc.JobName),
+			Mode:         allocation.Mode,
+			VirtualNodes: allocation.VirtualNodes,
+			HashLabels:   allocation.HashLabels,
+		}
+	}
+	for _, pin := range cfg.Pins {
+		c.Pins = append(c.Pins, Pin{Target: pin.Target, Collector: pin.Collector})
+	}
+	if cfg.Auth != nil {
+		auth := &Auth{
+			Tokens:                []string{},
+			BasicAuthUsers:        []string{},
+			KubernetesTokenReview: cfg.Auth.TokenReview != nil,
+			ClientCertificates:    cfg.Auth.ClientCertificates,
+			Admins:                append([]string{}, cfg.Auth.Admins...),
+		}
+		for _, t := range cfg.Auth.Tokens {
+			auth.Tokens = append(auth.Tokens, t.Name)
+		}
+		for _, u := range cfg.Auth.BasicAuth {
+			auth.BasicAuthUsers = append(auth.BasicAuthUsers, u.Username)
+		}
+		c.Auth = auth
+	}
+	return c
+}
+
+func sorted(names []string) []string {
+	names = append([]string{}, names...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	v1 "github.com/http-sd-loadbalancer/api/v1"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+)
+
+// Handlers of the /api/v1 routes, which serve the stable schema of the api/v1 package
+// The routes at the root are kept as aliases for existing consumers
+
+func v1JobsHandler(w http.ResponseWriter, r *http.Request) {
+	lb.RLock()
+	defer lb.RUnlock()
+	jobs := make([]string, 0, len(lb.Cache.DisplayJobMapping))
+	for job := range lb.Cache.DisplayJobMapping {
+		jobs = append(jobs, job)
+	}
+	writeJSON(w, r, "", v1.NewJobList(jobs))
+}
+
+// v1JobTargetsHandler serves the targets of a job by collector, optionally restricted to ?collector=
+func v1JobTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	job := mux.Vars(r)["job_id"]
+	collectorName := r.URL.Query().Get("collector")
+	if collectorName != "" {
+		if _, err := lb.CollectorStatus(collectorName); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		lb.MarkSeen(collectorName)
+	}
+	index := func() uint64 { return lb.Cache.Index(job, collectorName) }
+	if err := waitForIndex(r, index); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lb.RLock()
+	defer lb.RUnlock()
+	w.Header().Set(indexHeader, strconv.FormatUint(index(), 10))
+	byCollector := lb.Cache.DisplayJobs[job]
+	if collectorName != "" {
+		byCollector = map[string][]lbdiscovery.TargetGroup{collectorName: lb.Cache.DisplayTargetMapping[job+collectorName]}
+	}
+	writeJSON(w, r, "", v1.NewJobTargets(job, index(), byCollector))
+}
+
+func v1CollectorsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, "", v1.NewCollectorList(lb.CollectorStatuses()))
+}
+
+func v1CollectorHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := lb.CollectorStatus(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, "", v1.NewCollector(status))
+}
+
+// v1CollectorTargetsHandler serves the HTTP SD document of a collector, for every job or only for ?job=
+func v1CollectorTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	job := r.URL.Query().Get("job")
+	if _, err := lb.CollectorStatus(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	lb.MarkSeen(name)
+	index := func() uint64 { return lb.Cache.Index(job, name) }
+	if err := waitForIndex(r, index); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lb.RLock()
+	defer lb.RUnlock()
+	w.Header().Set(indexHeader, strconv.FormatUint(index(), 10))
+	if job == "" {
+		writeJSON(w, r, lb.Cache.ETag("/collectors/"+name+"/targets"), v1.NewTargetGroups(lb.Cache.DisplayCollectorTargets[name]))
+		return
+	}
+	writeJSON(w, r, lb.Cache.ETag("/jobs/"+job+"/targets?collector_id="+name), v1.NewTargetGroups(lb.Cache.DisplayTargetMapping[job+name]))
+}
+
+func v1StatusHandler(w http.ResponseWriter, r *http.Request) {
+	lb.RLock()
+	defer lb.RUnlock()
+	writeJSON(w, r, "", v1.Status{
+		Generation:   lb.Cache.Index("", ""),
+		Jobs:         len(lb.Cache.DisplayJobMapping),
+		Collectors:   len(lb.CollectorMap),
+		Targets:      len(lb.TargetItemMap),
+		Pins:         len(lb.Pins),
+		PinConflicts: len(lb.PinConflicts),
+		LastEventID:  lb.Events.LastID(),
+	})
+}
+
+func v1ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, "", v1.NewConfig(effectiveConfig()))
+}
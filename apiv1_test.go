@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/http-sd-loadbalancer/api/v1"
+	"github.com/http-sd-loadbalancer/client"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// The v1 contract tests pin the JSON served under /api/v1; a change to an expected document breaks consumers
+func TestV1Contract(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "jobs",
+			path:     "/api/v1/jobs",
+			expected: `{"jobs":[{"name":"job-a","targets_url":"/api/v1/jobs/job-a/targets"},{"name":"job-b","targets_url":"/api/v1/jobs/job-b/targets"}]}`,
+		},
+		{
+			name: "job targets",
+			path: "/api/v1/jobs/job-a/targets",
+			expected: `{"job":"job-a","index":2,"collectors":[
+				{"collector":"collector-1","targets_url":"/api/v1/collectors/collector-1/targets?job=job-a","target_groups":[{"targets":["targ:1000"],"labels":{"env":"prod"}}]},
+				{"collector":"collector-2","targets_url":"/api/v1/collectors/collector-2/targets?job=job-a","target_groups":[{"targets":["targ:1001"],"labels":{"env":"prod"}}]}
+			]}`,
+		},
+		{
+			name: "job targets of a collector",
+			path: "/api/v1/jobs/job-a/targets?collector=collector-2",
+			expected: `{"job":"job-a","index":2,"collectors":[
+				{"collector":"collector-2","targets_url":"/api/v1/collectors/collector-2/targets?job=job-a","target_groups":[{"targets":["targ:1001"],"labels":{"env":"prod"}}]}
+			]}`,
+		},
+		{
+			name:     "job targets of a collector without targets",
+			path:     "/api/v1/jobs/job-b/targets?collector=collector-2",
+			expected: `{"job":"job-b","index":1,"collectors":[{"collector":"collector-2","targets_url":"/api/v1/collectors/collector-2/targets?job=job-b","target_groups":[]}]}`,
+		},
+		{
+			name: "collectors",
+			path: "/api/v1/collectors",
+			expected: `{"collectors":[
+				{"name":"collector-1","state":"active","targets":2,"jobs":{"job-a":1,"job-b":1},"pools":["default"],"last_seen":null,"targets_url":"/api/v1/collectors/collector-1/targets"},
+				{"name":"collector-2","state":"active","targets":1,"jobs":{"job-a":1},"pools":["default"],"last_seen":null,"targets_url":"/api/v1/collectors/collector-2/targets"}
+			]}`,
+		},
+		{
+			name:     "collector",
+			path:     "/api/v1/collectors/collector-2",
+			expected: `{"name":"collector-2","state":"active","targets":1,"jobs":{"job-a":1},"pools":["default"],"last_seen":null,"targets_url":"/api/v1/collectors/collector-2/targets"}`,
+		},
+		{
+			name: "collector targets",
+			path: "/api/v1/collectors/collector-1/targets",
+			expected: `[
+				{"targets":["targ:1000"],"labels":{"__meta_loadbalancer_job":"job-a","env":"prod"}},
+				{"targets":["targ:2000"],"labels":{"__meta_loadbalancer_job":"job-b"}}
+			]`,
+		},
+		{
+			name:     "collector targets of a job",
+			path:     "/api/v1/collectors/collector-1/targets?job=job-b",
+			expected: `[{"targets":["targ:2000"],"labels":{}}]`,
+		},
+		{
+			name:     "collector targets without targets",
+			path:     "/api/v1/collectors/collector-2/targets?job=job-b",
+			expected: `[]`,
+		},
+		{
+			name:     "status",
+			path:     "/api/v1/status",
+			expected: `{"generation":2,"jobs":2,"collectors":2,"targets":3,"pins":0,"pin_conflicts":0,"last_event_id":3}`,
+		},
+		{
+			name: "config",
+			path: "/api/v1/config",
+			expected: `{"mode":"LeastConnection","label_selector":{"app":"collector"},"scrape_jobs":["job-a","job-b"],
+				"allocation":{"job-a":{"pool":"default","mode":"LeastConnection"},"job-b":{"pool":"infra","mode":"ConsistentHashing","virtual_nodes":50}},"pins":[{"target":"job-b/*","collector":"collector-1"}],
+				"auth":{"tokens":["collector-1"],"basic_auth_users":["admin"],"kubernetes_token_review":false,"client_certificates":true,"admins":["admin"]}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// prepare
+			initTestLoadBalancer(t,
+				lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{"env": "prod"}},
+				lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{"env": "prod"}},
+				lbdiscovery.TargetData{JobName: "job-b", Target: "targ:2000", Labels: model.LabelSet{}},
+			)
+			lbConfig = config.Config{
+				Mode:          "LeastConnection",
+				LabelSelector: map[string]string{"app": "collector"},
+				Config: config.ScrapeConfig{ScrapeConfigs: []*promconfig.ScrapeConfig{
+					{JobName: "job-a"},
+					{JobName: "job-b"},
+				}},
+				Pools:      []config.CollectorPool{{Name: "infra", LabelSelector: map[string]string{"pool": "infra"}, Jobs: []string{"job-b"}}},
+				Allocation: map[string]config.AllocationConfig{"job-b": {Mode: "ConsistentHashing", VirtualNodes: 50}},
+				Pins:       []config.Pin{{Target: "job-b/*", Collector: "collector-1"}},
+				Auth: &config.AuthConfig{
+					Tokens:             []config.TokenConfig{{Name: "collector-1", Token: "secret-token"}},
+					BasicAuth:          []config.BasicAuthConfig{{Username: "admin", PasswordHash: "$2y$10$secret"}},
+					ClientCertificates: true,
+					Admins:             []string{"admin"},
+				},
+			}
+			defer func() { lbConfig = config.Config{} }()
+			srv := httptest.NewServer(router())
+			defer srv.Close()
+
+			// test
+			resp, err := http.Get(srv.URL + tc.path)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			// verify
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.JSONEq(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestV1UnknownCollector(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	for _, path := range []string{
+		"/api/v1/collectors/collector-3",
+		"/api/v1/collectors/collector-3/targets",
+		"/api/v1/jobs/job-a/targets?collector=collector-3",
+	} {
+		// test
+		resp, err := http.Get(srv.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
+
+// Tests that the v1 methods of the client decode the v1 documents
+func TestV1Client(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{"env": "prod"}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{"env": "prod"}},
+	)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// test
+	jobs, jobsErr := c.V1Jobs(ctx)
+	targets, targetsErr := c.V1JobTargets(ctx, "job-a", "collector-2")
+	tgs, index, tgsErr := c.V1CollectorTargets(ctx, "collector-1", "job-a")
+	status, statusErr := c.V1Status(ctx)
+	_, missingErr := c.V1Collector(ctx, "collector-3")
+
+	// verify
+	assert.NoError(t, jobsErr)
+	assert.Equal(t, v1.JobList{Jobs: []v1.Job{{Name: "job-a", TargetsURL: "/api/v1/jobs/job-a/targets"}}}, jobs)
+	assert.NoError(t, targetsErr)
+	assert.Equal(t, "job-a", targets.Job)
+	assert.Len(t, targets.Collectors, 1)
+	assert.Equal(t, []v1.TargetGroup{{Targets: []string{"targ:1001"}, Labels: map[string]string{"env": "prod"}}}, targets.Collectors[0].TargetGroups)
+	assert.NoError(t, tgsErr)
+	assert.Equal(t, []v1.TargetGroup{{Targets: []string{"targ:1000"}, Labels: map[string]string{"env": "prod"}}}, tgs)
+	assert.Equal(t, targets.Index, index)
+	assert.NoError(t, statusErr)
+	assert.Equal(t, 2, status.Targets)
+	assert.ErrorIs(t, missingErr, client.ErrNotFound)
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// ErrNoCredentials represents a request that carries no credentials for an authenticator.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrUnauthenticated represents a request whose credentials were rejected.
+	ErrUnauthenticated = errors.New("invalid credentials")
+)
+
+// podNameExtra is set by the Kubernetes API server on tokens bound to a pod
+const podNameExtra = "authentication.kubernetes.io/pod-name"
+
+// Identity is the authenticated caller of a request
+// Collectors are identified by their collector name, admins may read everything and call admin endpoints
+type Identity struct {
+	Name string
+	// User is the user name of a caller that is identified by something else, e.g. the service account of a pod
+	User  string
+	Admin bool
+}
+
+// user is the name the identity is listed as an admin under
+func (i Identity) user() string {
+	if i.User != "" {
+		return i.User
+	}
+	return i.Name
+}
+
+// Authenticator resolves the identity of a request
+// It returns ErrNoCredentials when the request carries nothing it understands so the next authenticator can try
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Chain tries each authenticator in order and returns the first identity found
+// Credentials rejected by one authenticator are passed on to the next, e.g. a ServiceAccount token that is not
+// a static token, and only rejected once none accepts them
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	result := ErrNoCredentials
+	for _, a := range c {
+		identity, err := a.Authenticate(r)
+		switch {
+		case errors.Is(err, ErrNoCredentials):
+			continue
+		case errors.Is(err, ErrUnauthenticated):
+			result = err
+			continue
+		}
+		return identity, err
+	}
+	return Identity{}, result
+}
+
+// StaticTokens authenticates bearer tokens against a fixed token to name map
+type StaticTokens map[string]string
+
+func (s StaticTokens) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	for candidate, name := range s {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return Identity{Name: name}, nil
+		}
+	}
+	return Identity{}, ErrUnauthenticated
+}
+
+// BasicAuth authenticates basic auth credentials against bcrypt hashed passwords keyed by user name
+type BasicAuth map[string]string
+
+func (b BasicAuth) Authenticate(r *http.Request) (Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	hash, ok := b[user]
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return Identity{}, ErrUnauthenticated
+	}
+	return Identity{Name: user}, nil
+}
+
+// TokenReview authenticates bearer tokens with the Kubernetes TokenReview API
+// Tokens bound to a pod of one of the service accounts of the collectors are identified by the pod name, which is
+// the collector name, others by their user name
+type TokenReview struct {
+	Client    kubernetes.Interface
+	Audiences []string
+	// ServiceAccounts are the user names of the service accounts of the collectors, see ServiceAccountUser
+	ServiceAccounts []string
+}
+
+// ServiceAccountUser returns the user name Kubernetes authenticates the tokens of a service account as
+func ServiceAccountUser(namespace, name string) string {
+	return "system:serviceaccount:" + namespace + ":" + name
+}
+
+func (t TokenReview) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	review, err := t.Client.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: t.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return Identity{}, err
+	}
+	if !review.Status.Authenticated {
+		return Identity{}, ErrUnauthenticated
+	}
+	user := review.Status.User.Username
+	if pod := review.Status.User.Extra[podNameExtra]; len(pod) > 0 {
+		for _, sa := range t.ServiceAccounts {
+			if sa == user {
+				return Identity{Name: pod[0], User: user}, nil
+			}
+		}
+	}
+	return Identity{Name: user}, nil
+}
+
+// ClientCertificate authenticates requests by the common name of a verified client certificate
+type ClientCertificate struct{}
+
+func (ClientCertificate) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+	return Identity{Name: r.TLS.VerifiedChains[0][0].Subject.CommonName}, nil
+}
+
+// WithAdmins marks the identities whose name is listed as admins
+// Identities of pods are matched by the user name of their service account, never by the pod name
+func WithAdmins(a Authenticator, admins []string) Authenticator {
+	names := make(map[string]bool, len(admins))
+	for _, name := range admins {
+		names[name] = true
+	}
+	return adminAuthenticator{Authenticator: a, admins: names}
+}
+
+type adminAuthenticator struct {
+	Authenticator
+	admins map[string]bool
+}
+
+func (a adminAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	identity, err := a.Authenticator.Authenticate(r)
+	if err != nil {
+		return identity, err
+	}
+	identity.Admin = a.admins[identity.user()]
+	return identity, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the identity
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity stored in the context, if any
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
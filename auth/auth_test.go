@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"secret-1": "collector-1"}
+
+	req := httptest.NewRequest("GET", "/jobs", nil)
+	_, noCredsErr := tokens.Authenticate(req)
+	req.Header.Set("Authorization", "Bearer secret-1")
+	identity, err := tokens.Authenticate(req)
+	req.Header.Set("Authorization", "Bearer wrong")
+	_, wrongErr := tokens.Authenticate(req)
+
+	assert.ErrorIs(t, noCredsErr, ErrNoCredentials)
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{Name: "collector-1"}, identity)
+	assert.ErrorIs(t, wrongErr, ErrUnauthenticated)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	users := BasicAuth{"admin": string(hash)}
+
+	req := httptest.NewRequest("GET", "/jobs", nil)
+	req.SetBasicAuth("admin", "hunter2")
+	identity, err := users.Authenticate(req)
+	req.SetBasicAuth("admin", "wrong")
+	_, wrongErr := users.Authenticate(req)
+	req.SetBasicAuth("nobody", "hunter2")
+	_, unknownErr := users.Authenticate(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", identity.Name)
+	assert.ErrorIs(t, wrongErr, ErrUnauthenticated)
+	assert.ErrorIs(t, unknownErr, ErrUnauthenticated)
+}
+
+// newFakeTokenReview returns a TokenReview authenticator backed by a fake API server knowing the given tokens
+func newFakeTokenReview(users map[string]authenticationv1.UserInfo) TokenReview {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "broken" {
+			return true, nil, errors.New("api server unavailable")
+		}
+		user, ok := users[review.Spec.Token]
+		review.Status = authenticationv1.TokenReviewStatus{Authenticated: ok, User: user}
+		return true, review, nil
+	})
+	return TokenReview{Client: client, ServiceAccounts: []string{ServiceAccountUser("otel", "collector")}}
+}
+
+func TestTokenReview(t *testing.T) {
+	reviewer := newFakeTokenReview(map[string]authenticationv1.UserInfo{
+		"pod-token": {Username: "system:serviceaccount:otel:collector", Extra: map[string]authenticationv1.ExtraValue{podNameExtra: {"collector-1"}}},
+		"sa-token":  {Username: "system:serviceaccount:ops:admin"},
+	})
+	authenticate := func(token string) (Identity, error) {
+		req := httptest.NewRequest("GET", "/jobs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return reviewer.Authenticate(req)
+	}
+
+	pod, podErr := authenticate("pod-token")
+	sa, saErr := authenticate("sa-token")
+	_, invalidErr := authenticate("invalid")
+	_, brokenErr := authenticate("broken")
+
+	assert.NoError(t, podErr)
+	assert.Equal(t, Identity{Name: "collector-1", User: "system:serviceaccount:otel:collector"}, pod)
+	assert.NoError(t, saErr)
+	assert.Equal(t, "system:serviceaccount:ops:admin", sa.Name)
+	assert.ErrorIs(t, invalidErr, ErrUnauthenticated)
+	assert.Error(t, brokenErr)
+	assert.NotErrorIs(t, brokenErr, ErrUnauthenticated)
+}
+
+// Tests that pods of other service accounts don't take the identity of the collector of the same name
+func TestTokenReviewForeignPod(t *testing.T) {
+	reviewer := newFakeTokenReview(map[string]authenticationv1.UserInfo{
+		"pod-token":     {Username: "system:serviceaccount:otel:collector", Extra: map[string]authenticationv1.ExtraValue{podNameExtra: {"collector-1"}}},
+		"foreign-token": {Username: "system:serviceaccount:shop:default", Extra: map[string]authenticationv1.ExtraValue{podNameExtra: {"collector-1"}}},
+	})
+	authenticate := func(a Authenticator, token string) (Identity, error) {
+		req := httptest.NewRequest("GET", "/jobs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.Authenticate(req)
+	}
+
+	foreign, foreignErr := authenticate(reviewer, "foreign-token")
+	byPodName, byPodNameErr := authenticate(WithAdmins(reviewer, []string{"collector-1"}), "pod-token")
+	byUser, byUserErr := authenticate(WithAdmins(reviewer, []string{"system:serviceaccount:otel:collector"}), "pod-token")
+
+	assert.NoError(t, foreignErr)
+	assert.Equal(t, Identity{Name: "system:serviceaccount:shop:default"}, foreign)
+	assert.NoError(t, byPodNameErr)
+	assert.False(t, byPodName.Admin, "admins are not matched by pod name")
+	assert.NoError(t, byUserErr)
+	assert.True(t, byUser.Admin)
+	assert.Equal(t, "collector-1", byUser.Name)
+}
+
+func TestChainWithAdmins(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	authenticator := WithAdmins(Chain{StaticTokens{"secret-1": "collector-1"}, BasicAuth{"admin": string(hash)}}, []string{"admin"})
+
+	req := httptest.NewRequest("GET", "/jobs", nil)
+	_, noCredsErr := authenticator.Authenticate(req)
+	req.SetBasicAuth("admin", "hunter2")
+	admin, adminErr := authenticator.Authenticate(req)
+	req = httptest.NewRequest("GET", "/jobs", nil)
+	req.Header.Set("Authorization", "Bearer secret-1")
+	collector, collectorErr := authenticator.Authenticate(req)
+
+	assert.ErrorIs(t, noCredsErr, ErrNoCredentials)
+	assert.NoError(t, adminErr)
+	assert.Equal(t, Identity{Name: "admin", Admin: true}, admin)
+	assert.NoError(t, collectorErr)
+	assert.Equal(t, Identity{Name: "collector-1"}, collector)
+}
+
+// Tests that bearer tokens unknown to the static tokens are still reviewed by the Kubernetes API
+func TestChainStaticTokensAndTokenReview(t *testing.T) {
+	authenticator := Chain{
+		StaticTokens{"secret-1": "collector-1"},
+		newFakeTokenReview(map[string]authenticationv1.UserInfo{
+			"pod-token": {Username: "system:serviceaccount:otel:collector", Extra: map[string]authenticationv1.ExtraValue{podNameExtra: {"collector-2"}}},
+		}),
+	}
+	authenticate := func(token string) (Identity, error) {
+		req := httptest.NewRequest("GET", "/jobs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return authenticator.Authenticate(req)
+	}
+
+	static, staticErr := authenticate("secret-1")
+	pod, podErr := authenticate("pod-token")
+	_, invalidErr := authenticate("invalid")
+
+	assert.NoError(t, staticErr)
+	assert.Equal(t, "collector-1", static.Name)
+	assert.NoError(t, podErr)
+	assert.Equal(t, "collector-2", pod.Name)
+	assert.ErrorIs(t, invalidErr, ErrUnauthenticated)
+}
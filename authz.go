@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	v1 "github.com/http-sd-loadbalancer/api/v1"
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/http-sd-loadbalancer/config"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// authenticator is nil when no auth section is configured, in which case the API is open
+	authenticator auth.Authenticator
+	// authenticatorLoaded is set once authenticator comes from a configuration that loaded
+	authenticatorLoaded bool
+)
+
+// authLimiters limits the requests that fail authentication per client address, since checking credentials
+// costs a bcrypt comparison or a TokenReview call; requests that pass are not counted
+var authLimiters = newClientLimiters(0, 0)
+
+// errAuthUnavailable is returned for every request until the authentication of the configuration is set up
+var errAuthUnavailable = errors.New("authentication is not set up")
+
+// denyAll rejects every request, it stands in for the authenticator of a configuration that failed to load
+type denyAll struct{}
+
+func (denyAll) Authenticate(*http.Request) (auth.Identity, error) {
+	return auth.Identity{}, errAuthUnavailable
+}
+
+// setAuthenticator sets up the authenticator of the configuration, which failed to load when loadErr is set
+// A configuration that fails to load or to set up its authentication keeps the previous authenticator,
+// or denies every request before any was set up, so that it never opens the API
+func setAuthenticator(cfg *config.AuthConfig, loadErr error) {
+	if loadErr == nil {
+		a, err := newAuthenticator(cfg)
+		if err == nil {
+			authenticator, authenticatorLoaded = a, true
+			return
+		}
+		level.Error(logger).Log("msg", "failed to set up authentication", "err", err)
+	}
+	if authenticatorLoaded {
+		level.Warn(logger).Log("msg", "keeping the previous authentication")
+		return
+	}
+	level.Warn(logger).Log("msg", "denying every request until the authentication is set up")
+	authenticator = denyAll{}
+}
+
+// newAuthenticator builds the authenticator chain from the configuration
+func newAuthenticator(cfg *config.AuthConfig) (auth.Authenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	var chain auth.Chain
+	if cfg.ClientCertificates {
+		chain = append(chain, auth.ClientCertificate{})
+	}
+	if len(cfg.Tokens) > 0 {
+		tokens := auth.StaticTokens{}
+		for _, t := range cfg.Tokens {
+			tokens[t.Token] = t.Name
+		}
+		chain = append(chain, tokens)
+	}
+	if len(cfg.BasicAuth) > 0 {
+		users := auth.BasicAuth{}
+		for _, u := range cfg.BasicAuth {
+			users[u.Username] = u.PasswordHash
+		}
+		chain = append(chain, users)
+	}
+	if cfg.TokenReview != nil {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, err
+		}
+		serviceAccounts := make([]string, 0, len(cfg.TokenReview.ServiceAccounts))
+		for _, sa := range cfg.TokenReview.ServiceAccounts {
+			serviceAccounts = append(serviceAccounts, auth.ServiceAccountUser(sa.Namespace, sa.Name))
+		}
+		chain = append(chain, auth.TokenReview{Client: client, Audiences: cfg.TokenReview.Audiences, ServiceAccounts: serviceAccounts})
+	}
+	return auth.WithAdmins(chain, cfg.Admins), nil
+}
+
+// authMiddleware authenticates every request and only lets collectors read their own assignment
+// Requests of collectors that don't name a collector are scoped to the caller
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil || publicRoute(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r, ok := authenticate(w, r); ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authenticate authenticates and authorizes the request, which it returns scoped to the caller and carrying
+// its identity, or writes the error and returns false
+func authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	refund := func() {}
+	if authLimiters.limit > 0 {
+		var delay time.Duration
+		if refund, delay = authLimiters.take(clientAddress(r), time.Now()); delay > 0 {
+			tooManyRequests(w, "auth_rate_limit", delay)
+			return nil, false
+		}
+	}
+	identity, err := authenticator.Authenticate(r)
+	if err == nil {
+		refund()
+	} else {
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, errAuthUnavailable):
+			status = http.StatusServiceUnavailable
+		case !errors.Is(err, auth.ErrNoCredentials) && !errors.Is(err, auth.ErrUnauthenticated):
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer, Basic realm="http-sd-loadbalancer"`)
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+	if !identity.Admin {
+		scopeToCollector(r, identity)
+	}
+	if !authorized(r, identity) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return r.WithContext(auth.NewContext(r.Context(), identity)), true
+}
+
+// publicRoute reports whether the route is served without credentials, e.g. to kubelet probes
+func publicRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, _ := route.GetPathTemplate()
+	return template == "/readyz"
+}
+
+// collectorParams holds the query parameter naming the collector on routes that accept one
+var collectorParams = map[string]string{
+	"/jobs/{job_id}/targets":             "collector_id",
+	"/events":                            "collector",
+	v1.Prefix + "/jobs/{job_id}/targets": "collector",
+}
+
+func scopeToCollector(r *http.Request, identity auth.Identity) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return
+	}
+	template, _ := route.GetPathTemplate()
+	if param, ok := collectorParams[template]; ok {
+		q := r.URL.Query()
+		if q.Get(param) == "" {
+			q.Set(param, identity.Name)
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+}
+
+// authorized reports whether the identity may call the matched route
+func authorized(r *http.Request, identity auth.Identity) bool {
+	if identity.Admin {
+		return true
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, _ := route.GetPathTemplate()
+	switch {
+	case strings.HasPrefix(template, "/admin/"):
+		return false
+	case template == "/jobs" || template == v1.Prefix+"/jobs" || template == "/metrics" || template == "/openapi.json":
+		return true
+	case template == "/collectors/{name}" || template == "/collectors/{name}/targets",
+		template == v1.Prefix+"/collectors/{name}" || template == v1.Prefix+"/collectors/{name}/targets",
+		template == v1.Prefix+"/collectors/{name}/otel-config":
+		return mux.Vars(r)["name"] == identity.Name
+	}
+	if param, ok := collectorParams[template]; ok {
+		return r.URL.Query().Get(param) == identity.Name
+	}
+	return false
+}
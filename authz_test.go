@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorization(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	authenticator = auth.WithAdmins(auth.StaticTokens{"col-1-token": "collector-1", "admin-token": "admin"}, []string{"admin"})
+	defer func() { authenticator = nil }()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	get := func(path, token string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	post := func(path, token string) *http.Response {
+		req, _ := http.NewRequest("POST", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		resp     func() *http.Response
+		expected int
+	}{
+		{"anonymous", func() *http.Response { return get("/jobs", "") }, http.StatusUnauthorized},
+		{"anonymous probes readiness", func() *http.Response { return get("/readyz", "") }, http.StatusOK},
+		{"invalid token", func() *http.Response { return get("/jobs", "wrong") }, http.StatusUnauthorized},
+		{"collector lists jobs", func() *http.Response { return get("/jobs", "col-1-token") }, http.StatusOK},
+		{"collector reads its targets", func() *http.Response { return get("/jobs/job-a/targets?collector_id=collector-1", "col-1-token") }, http.StatusOK},
+		{"collector reads its targets implicitly", func() *http.Response { return get("/jobs/job-a/targets", "col-1-token") }, http.StatusOK},
+		{"collector reads other targets", func() *http.Response { return get("/jobs/job-a/targets?collector_id=collector-2", "col-1-token") }, http.StatusForbidden},
+		{"collector reads its summary", func() *http.Response { return get("/collectors/collector-1/targets", "col-1-token") }, http.StatusOK},
+		{"collector reads other summary", func() *http.Response { return get("/collectors/collector-2", "col-1-token") }, http.StatusForbidden},
+		{"collector lists collectors", func() *http.Response { return get("/collectors", "col-1-token") }, http.StatusForbidden},
+		{"collector renders its OpenTelemetry configuration", func() *http.Response { return get("/api/v1/collectors/collector-1/otel-config", "col-1-token") }, http.StatusOK},
+		{"collector renders other OpenTelemetry configuration", func() *http.Response { return get("/api/v1/collectors/collector-2/otel-config", "col-1-token") }, http.StatusForbidden},
+		{"collector replicates the assignment", func() *http.Response { return get("/internal/sync", "col-1-token") }, http.StatusForbidden},
+		{"collector calls admin", func() *http.Response { return post("/admin/collectors/collector-2/cordon", "col-1-token") }, http.StatusForbidden},
+		{"admin reads every target", func() *http.Response { return get("/jobs/job-a/targets", "admin-token") }, http.StatusOK},
+		{"admin reads other targets", func() *http.Response { return get("/jobs/job-a/targets?collector_id=collector-2", "admin-token") }, http.StatusOK},
+		{"admin calls admin", func() *http.Response { return post("/admin/collectors/collector-2/uncordon", "admin-token") }, http.StatusOK},
+		{"collector lists v1 jobs", func() *http.Response { return get("/api/v1/jobs", "col-1-token") }, http.StatusOK},
+		{"collector reads its v1 targets implicitly", func() *http.Response { return get("/api/v1/jobs/job-a/targets", "col-1-token") }, http.StatusOK},
+		{"collector reads other v1 targets", func() *http.Response { return get("/api/v1/jobs/job-a/targets?collector=collector-2", "col-1-token") }, http.StatusForbidden},
+		{"collector reads its v1 sd document", func() *http.Response { return get("/api/v1/collectors/collector-1/targets", "col-1-token") }, http.StatusOK},
+		{"collector reads other v1 sd document", func() *http.Response { return get("/api/v1/collectors/collector-2/targets", "col-1-token") }, http.StatusForbidden},
+		{"collector reads v1 status", func() *http.Response { return get("/api/v1/status", "col-1-token") }, http.StatusForbidden},
+		{"collector reads v1 config", func() *http.Response { return get("/api/v1/config", "col-1-token") }, http.StatusForbidden},
+		{"admin reads v1 config", func() *http.Response { return get("/api/v1/config", "admin-token") }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.resp().StatusCode)
+		})
+	}
+
+	t.Run("implicit collector scope returns the caller's targets", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", srv.URL+"/jobs/job-a/targets", nil)
+		req.Header.Set("Authorization", "Bearer col-1-token")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, lb.Cache.ETag("/jobs/job-a/targets?collector_id=collector-1"), resp.Header.Get("ETag"))
+	})
+}
+
+// Tests that a configuration that fails to load never opens the API
+func TestSetAuthenticator(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	defer func() { authenticator, authenticatorLoaded = nil, false }()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	get := func(path, token string) int {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tokens := &config.AuthConfig{Tokens: []config.TokenConfig{{Name: "collector-1", Token: "col-1-token"}}}
+
+	// test that every request is denied until a configuration loads
+	setAuthenticator(nil, errors.New("unset variable"))
+
+	// verify
+	assert.Equal(t, http.StatusServiceUnavailable, get("/jobs", "col-1-token"))
+	assert.Equal(t, http.StatusOK, get("/readyz", ""))
+
+	// test
+	setAuthenticator(tokens, nil)
+
+	// verify
+	assert.Equal(t, http.StatusOK, get("/jobs", "col-1-token"))
+
+	// test that a configuration that fails to load keeps the previous authentication
+	setAuthenticator(nil, errors.New("unset variable"))
+
+	// verify
+	assert.Equal(t, http.StatusOK, get("/jobs", "col-1-token"))
+	assert.Equal(t, http.StatusUnauthorized, get("/jobs", "wrong"))
+
+	// test that an authentication that can't be set up keeps the previous one, the TokenReview API is out of reach
+	setAuthenticator(&config.AuthConfig{TokenReview: &config.TokenReviewConfig{}}, nil)
+
+	// verify
+	assert.Equal(t, http.StatusOK, get("/jobs", "col-1-token"))
+	assert.Equal(t, http.StatusUnauthorized, get("/jobs", "wrong"))
+}
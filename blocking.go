@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// indexHeader carries the assignment index of a response, to be passed back as ?index= to block for changes
+	indexHeader = "X-Loadbalancer-Index"
+
+	defaultWait = 5 * time.Minute
+	maxWait     = 10 * time.Minute
+)
+
+var errInvalidBlockingQuery = errors.New("index must be an unsigned integer and wait a duration")
+
+// waitForIndex implements blocking queries: when the request carries ?index=, it holds the request until
+// the index returned by current moves past it, the ?wait= duration passes or the client goes away
+// It is not counted against the in-flight limit while it waits
+func waitForIndex(r *http.Request, current func() uint64) error {
+	q := r.URL.Query()
+	if q.Get("index") == "" {
+		return nil
+	}
+	index, err := strconv.ParseUint(q.Get("index"), 10, 64)
+	if err != nil {
+		return errInvalidBlockingQuery
+	}
+	wait := defaultWait
+	if q.Get("wait") != "" {
+		if wait, err = time.ParseDuration(q.Get("wait")); err != nil || wait < 0 {
+			return errInvalidBlockingQuery
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	for {
+		// Grab the channel before reading the index so a change in between is not missed
+		changed := lb.Changed()
+		lb.RLock()
+		latest := current()
+		lb.RUnlock()
+		if latest > index {
+			return nil
+		}
+		unpark := parkInFlight(r)
+		select {
+		case <-changed:
+			unpark()
+		case <-ctx.Done():
+			unpark()
+			return nil
+		}
+	}
+}
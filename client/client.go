@@ -0,0 +1,348 @@
+// Package client is a typed client for the HTTP API of the load balancer, as described in openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+)
+
+// indexHeader carries the assignment index of a target response
+const indexHeader = "X-Loadbalancer-Index"
+
+// EventResync is the type of the event sent when events were missed; targets should be fetched again
+const EventResync = "resync"
+
+var (
+	// ErrUnauthorized represents a request without valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden represents a request the credentials don't allow.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound represents a request for a collector or pin that does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict represents a drain with no other collector to move targets to.
+	ErrConflict = errors.New("conflict")
+	// ErrTooManyRequests represents a request rejected by the rate or in-flight limit.
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:    ErrUnauthorized,
+	http.StatusForbidden:       ErrForbidden,
+	http.StatusNotFound:        ErrNotFound,
+	http.StatusConflict:        ErrConflict,
+	http.StatusTooManyRequests: ErrTooManyRequests,
+}
+
+// StatusError is returned for any response with an unexpected status
+// It matches the sentinel error of its status with errors.Is
+type StatusError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return statusErrors[e.StatusCode] == target
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	authorize  func(*http.Request)
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the client used to send requests, e.g. to present a client certificate
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.authorize = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.authorize = func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, err
+	}
+	c := &Client{baseURL: baseURL, httpClient: http.DefaultClient, authorize: func(*http.Request) {}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Jobs returns the discovered jobs with the link to their targets
+func (c *Client) Jobs(ctx context.Context) (map[string]loadbalancer.LinkLabel, error) {
+	var jobs map[string]loadbalancer.LinkLabel
+	_, err := c.do(ctx, http.MethodGet, "/jobs", nil, nil, &jobs)
+	return jobs, err
+}
+
+// JobTargets returns the targets of every collector for the job, keyed by collector name
+func (c *Client) JobTargets(ctx context.Context, job string) (map[string]loadbalancer.CollectorJson, error) {
+	var targets map[string]loadbalancer.CollectorJson
+	_, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(job)+"/targets", nil, nil, &targets)
+	return targets, err
+}
+
+// Targets returns the targets of the job assigned to the collector, along with their index
+func (c *Client) Targets(ctx context.Context, job, collector string) ([]lbdiscovery.TargetGroup, uint64, error) {
+	return c.WaitTargets(ctx, job, collector, 0, 0)
+}
+
+// WaitTargets blocks until the index of the targets moves past index or wait passes
+// An index of 0 returns immediately; a wait of 0 uses the server default
+func (c *Client) WaitTargets(ctx context.Context, job, collector string, index uint64, wait time.Duration) ([]lbdiscovery.TargetGroup, uint64, error) {
+	q := blockingQuery(index, wait)
+	q.Set("collector_id", collector)
+	return c.targets(ctx, "/jobs/"+url.PathEscape(job)+"/targets", q)
+}
+
+// CollectorTargets returns every target of the collector across all jobs, along with their index
+func (c *Client) CollectorTargets(ctx context.Context, collector string) ([]lbdiscovery.TargetGroup, uint64, error) {
+	return c.WaitCollectorTargets(ctx, collector, 0, 0)
+}
+
+// WaitCollectorTargets blocks like WaitTargets, for every target of the collector
+func (c *Client) WaitCollectorTargets(ctx context.Context, collector string, index uint64, wait time.Duration) ([]lbdiscovery.TargetGroup, uint64, error) {
+	return c.targets(ctx, "/collectors/"+url.PathEscape(collector)+"/targets", blockingQuery(index, wait))
+}
+
+func (c *Client) Collectors(ctx context.Context) ([]loadbalancer.CollectorStatus, error) {
+	var collectors []loadbalancer.CollectorStatus
+	_, err := c.do(ctx, http.MethodGet, "/collectors", nil, nil, &collectors)
+	return collectors, err
+}
+
+func (c *Client) Collector(ctx context.Context, name string) (loadbalancer.CollectorStatus, error) {
+	var status loadbalancer.CollectorStatus
+	_, err := c.do(ctx, http.MethodGet, "/collectors/"+url.PathEscape(name), nil, nil, &status)
+	return status, err
+}
+
+func (c *Client) Cordon(ctx context.Context, name string) (loadbalancer.CollectorStatus, error) {
+	return c.collectorAction(ctx, name, "cordon")
+}
+
+func (c *Client) Uncordon(ctx context.Context, name string) (loadbalancer.CollectorStatus, error) {
+	return c.collectorAction(ctx, name, "uncordon")
+}
+
+func (c *Client) Drain(ctx context.Context, name string) (loadbalancer.CollectorStatus, error) {
+	return c.collectorAction(ctx, name, "drain")
+}
+
+func (c *Client) Pins(ctx context.Context) (loadbalancer.PinStatus, error) {
+	var status loadbalancer.PinStatus
+	_, err := c.do(ctx, http.MethodGet, "/admin/pins", nil, nil, &status)
+	return status, err
+}
+
+func (c *Client) AddPin(ctx context.Context, pin config.Pin) (loadbalancer.PinStatus, error) {
+	var status loadbalancer.PinStatus
+	_, err := c.do(ctx, http.MethodPost, "/admin/pins", nil, pin, &status)
+	return status, err
+}
+
+// RemovePin removes the pin with the given target pattern
+func (c *Client) RemovePin(ctx context.Context, target string) (loadbalancer.PinStatus, error) {
+	var status loadbalancer.PinStatus
+	_, err := c.do(ctx, http.MethodDelete, "/admin/pins", url.Values{"target": {target}}, nil, &status)
+	return status, err
+}
+
+// DebugState returns the raw snapshot of the internal state served at /debug/state
+func (c *Client) DebugState(ctx context.Context) (json.RawMessage, error) {
+	var state json.RawMessage
+	_, err := c.do(ctx, http.MethodGet, "/debug/state", nil, nil, &state)
+	return state, err
+}
+
+// EventFilter restricts the streamed events; empty fields match everything
+type EventFilter struct {
+	Collector   string
+	Job         string
+	LastEventID uint64
+}
+
+// Events streams allocation changes to fn until ctx is done, the stream ends or fn returns an error
+// A missed range of events is reported as an event of type EventResync
+func (c *Client) Events(ctx context.Context, filter EventFilter, fn func(loadbalancer.Event) error) error {
+	q := url.Values{}
+	if filter.Collector != "" {
+		q.Set("collector", filter.Collector)
+	}
+	if filter.Job != "" {
+		q.Set("job", filter.Job)
+	}
+	if filter.LastEventID != 0 {
+		q.Set("last_event_id", strconv.FormatUint(filter.LastEventID, 10))
+	}
+	return c.stream(ctx, "/events", q, func(eventType string, data []byte) error {
+		var event loadbalancer.Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		event.Type = eventType
+		return fn(event)
+	})
+}
+
+// Sync follows the assignment of a leader, calling fn with a snapshot and then with every delta until ctx is
+// done or fn returns an error
+func (c *Client) Sync(ctx context.Context, fn func(loadbalancer.SyncMessage) error) error {
+	return c.stream(ctx, "/internal/sync", nil, func(eventType string, data []byte) error {
+		var m loadbalancer.SyncMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		return fn(m)
+	})
+}
+
+// maxEventSize bounds a single event; sync snapshots carry the whole assignment on one line
+const maxEventSize = 64 << 20
+
+// stream reads the server-sent events of path, calling fn with the type and data of each
+func (c *Client) stream(ctx context.Context, path string, q url.Values, fn func(eventType string, data []byte) error) error {
+	resp, err := c.send(ctx, http.MethodGet, path, q, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var eventType string
+	var data []byte
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxEventSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		case line == "" && eventType != "":
+			if err := fn(eventType, data); err != nil {
+				return err
+			}
+			eventType, data = "", nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (c *Client) collectorAction(ctx context.Context, name, action string) (loadbalancer.CollectorStatus, error) {
+	var status loadbalancer.CollectorStatus
+	_, err := c.do(ctx, http.MethodPost, "/admin/collectors/"+url.PathEscape(name)+"/"+action, nil, nil, &status)
+	return status, err
+}
+
+func (c *Client) targets(ctx context.Context, path string, q url.Values) ([]lbdiscovery.TargetGroup, uint64, error) {
+	var tgs []lbdiscovery.TargetGroup
+	header, err := c.do(ctx, http.MethodGet, path, q, nil, &tgs)
+	if err != nil {
+		return nil, 0, err
+	}
+	index, err := strconv.ParseUint(header.Get(indexHeader), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tgs == nil {
+		tgs = []lbdiscovery.TargetGroup{}
+	}
+	return tgs, index, nil
+}
+
+func blockingQuery(index uint64, wait time.Duration) url.Values {
+	q := url.Values{}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		if wait > 0 {
+			q.Set("wait", wait.String())
+		}
+	}
+	return q
+}
+
+// do sends the request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	resp, err := c.send(ctx, method, path, q, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
+
+func (c *Client) send(ctx context.Context, method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	u := strings.TrimSuffix(c.baseURL, "/") + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, statusErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	v1 "github.com/http-sd-loadbalancer/api/v1"
+)
+
+// Methods of the /api/v1 routes, which return the stable schema of the api/v1 package
+// The other methods follow the routes at the root and return the internal types they serve
+
+// V1Jobs returns the discovered jobs with the link to their targets
+func (c *Client) V1Jobs(ctx context.Context) (v1.JobList, error) {
+	var jobs v1.JobList
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/jobs", nil, nil, &jobs)
+	return jobs, err
+}
+
+// V1JobTargets returns the targets of the job by collector, or only those of the collector unless it is empty
+func (c *Client) V1JobTargets(ctx context.Context, job, collector string) (v1.JobTargets, error) {
+	return c.WaitV1JobTargets(ctx, job, collector, 0, 0)
+}
+
+// WaitV1JobTargets blocks like WaitTargets, for the targets of V1JobTargets
+func (c *Client) WaitV1JobTargets(ctx context.Context, job, collector string, index uint64, wait time.Duration) (v1.JobTargets, error) {
+	q := blockingQuery(index, wait)
+	if collector != "" {
+		q.Set("collector", collector)
+	}
+	var targets v1.JobTargets
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/jobs/"+url.PathEscape(job)+"/targets", q, nil, &targets)
+	return targets, err
+}
+
+func (c *Client) V1Collectors(ctx context.Context) (v1.CollectorList, error) {
+	var collectors v1.CollectorList
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/collectors", nil, nil, &collectors)
+	return collectors, err
+}
+
+func (c *Client) V1Collector(ctx context.Context, name string) (v1.Collector, error) {
+	var collector v1.Collector
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/collectors/"+url.PathEscape(name), nil, nil, &collector)
+	return collector, err
+}
+
+// V1CollectorTargets returns the HTTP SD document of the collector for every job, or only for the job unless it
+// is empty, along with its index
+func (c *Client) V1CollectorTargets(ctx context.Context, name, job string) ([]v1.TargetGroup, uint64, error) {
+	return c.WaitV1CollectorTargets(ctx, name, job, 0, 0)
+}
+
+// WaitV1CollectorTargets blocks like WaitTargets, for the targets of V1CollectorTargets
+func (c *Client) WaitV1CollectorTargets(ctx context.Context, name, job string, index uint64, wait time.Duration) ([]v1.TargetGroup, uint64, error) {
+	q := blockingQuery(index, wait)
+	if job != "" {
+		q.Set("job", job)
+	}
+	var tgs []v1.TargetGroup
+	header, err := c.do(ctx, http.MethodGet, v1.Prefix+"/collectors/"+url.PathEscape(name)+"/targets", q, nil, &tgs)
+	if err != nil {
+		return nil, 0, err
+	}
+	targetsIndex, err := strconv.ParseUint(header.Get(indexHeader), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tgs == nil {
+		tgs = []v1.TargetGroup{}
+	}
+	return tgs, targetsIndex, nil
+}
+
+// V1Status returns a summary of the current allocation
+func (c *Client) V1Status(ctx context.Context) (v1.Status, error) {
+	var status v1.Status
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/status", nil, nil, &status)
+	return status, err
+}
+
+// V1Config returns the loaded configuration without any secret
+func (c *Client) V1Config(ctx context.Context) (v1.Config, error) {
+	var cfg v1.Config
+	_, err := c.do(ctx, http.MethodGet, v1.Prefix+"/config", nil, nil, &cfg)
+	return cfg, err
+}
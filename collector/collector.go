@@ -2,9 +2,47 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 )
 
-func Get(ctx context.Context, LabelSelector map[string]string) ([]string, error) {
+// SelectorsResolved reports whether the collectors of a pool are looked up by its label selector; until they are,
+// list returns the same collectors for every selector
+const SelectorsResolved = false
+
+// Get returns the collectors of every pool, each looked up by the label selector of the pool
+func Get(ctx context.Context, logger log.Logger, selectors map[string]map[string]string) (map[string][]string, error) {
+	pools := make(map[string][]string, len(selectors))
+	for pool, selector := range selectors {
+		collectors, err := list(ctx, logger, selector)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", pool, err)
+		}
+		pools[pool] = collectors
+	}
+	return pools, nil
+}
+
+// Names returns the collectors of the pools, each once and in name order
+func Names(pools map[string][]string) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, collectors := range pools {
+		for _, name := range collectors {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func list(ctx context.Context, logger log.Logger, LabelSelector map[string]string) ([]string, error) {
 	// config, err := rest.InClusterConfig()
 	// if err != nil {
 	// 	return nil, err
@@ -23,5 +61,7 @@ func Get(ctx context.Context, LabelSelector map[string]string) ([]string, error)
 
 	// return collectors, nil
 	// Returning dummy list for now
-	return []string{"collector-1", "collector-2", "collector-3"}, nil
+	collectors := []string{"collector-1", "collector-2", "collector-3"}
+	level.Debug(logger).Log("msg", "collectors found", "collectors", len(collectors), "label_selector", fmt.Sprint(LabelSelector))
+	return collectors, nil
 }
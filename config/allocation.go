@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	// ModeLeastConnection assigns a new target to the collector holding the fewest targets across all jobs
+	ModeLeastConnection = "LeastConnection"
+	// ModeConsistentHashing assigns a target to the collector its hash falls on, so it keeps its collector
+	// for as long as the collector exists
+	ModeConsistentHashing = "ConsistentHashing"
+
+	// DefaultVirtualNodes is the number of points of every collector on the hash ring of ConsistentHashing
+	DefaultVirtualNodes = 100
+)
+
+// AllocationConfig picks how the targets of a job are assigned to the collectors
+type AllocationConfig struct {
+	Mode string `yaml:"mode" json:"mode"`
+	// VirtualNodes is the number of points of every collector on the hash ring of ConsistentHashing
+	VirtualNodes int `yaml:"virtual_nodes,omitempty" json:"virtual_nodes,omitempty"`
+	// HashLabels are hashed by ConsistentHashing instead of the target address
+	HashLabels []string `yaml:"hash_labels,omitempty" json:"hash_labels,omitempty"`
+}
+
+// Validate checks the mode and its parameters
+func (a AllocationConfig) Validate() error {
+	switch a.Mode {
+	case ModeLeastConnection:
+		if a.VirtualNodes != 0 || len(a.HashLabels) > 0 {
+			return fmt.Errorf("%w: virtual_nodes and hash_labels only apply to %s", ErrInvalidAllocation, ModeConsistentHashing)
+		}
+	case ModeConsistentHashing:
+		if a.VirtualNodes < 0 {
+			return fmt.Errorf("%w: virtual_nodes must not be negative", ErrInvalidAllocation)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidAllocation, a.Mode)
+	}
+	return nil
+}
+
+// JobAllocation returns the allocation of a job, which is the one of the global mode unless the job overrides it
+func (c Config) JobAllocation(jobName string) AllocationConfig {
+	if allocation, ok := c.Allocation[jobName]; ok {
+		return allocation
+	}
+	return c.DefaultAllocation()
+}
+
+// DefaultAllocation returns the allocation of the global mode, which defaults to LeastConnection
+func (c Config) DefaultAllocation() AllocationConfig {
+	if c.Mode == "" {
+		return AllocationConfig{Mode: ModeLeastConnection}
+	}
+	return AllocationConfig{Mode: c.Mode}
+}
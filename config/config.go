@@ -1,9 +1,31 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
 
+	promconfig "github.com/prometheus/prometheus/config"
+	// the supported SD configs register themselves, so scrape jobs can be decoded
+	_ "github.com/prometheus/prometheus/discovery/azure"
+	_ "github.com/prometheus/prometheus/discovery/consul"
+	_ "github.com/prometheus/prometheus/discovery/digitalocean"
+	_ "github.com/prometheus/prometheus/discovery/dns"
+	_ "github.com/prometheus/prometheus/discovery/eureka"
+	_ "github.com/prometheus/prometheus/discovery/file"
+	_ "github.com/prometheus/prometheus/discovery/gce"
+	_ "github.com/prometheus/prometheus/discovery/hetzner"
+	_ "github.com/prometheus/prometheus/discovery/http"
+	_ "github.com/prometheus/prometheus/discovery/kubernetes"
+	_ "github.com/prometheus/prometheus/discovery/linode"
+	_ "github.com/prometheus/prometheus/discovery/marathon"
+	_ "github.com/prometheus/prometheus/discovery/openstack"
+	_ "github.com/prometheus/prometheus/discovery/scaleway"
+	_ "github.com/prometheus/prometheus/discovery/triton"
 	"gopkg.in/yaml.v2"
 )
 
@@ -12,6 +34,20 @@ var (
 	ErrInvalidLBYAML = errors.New("couldn't parse the loadbalancer configuration")
 	// ErrInvalidLBFile represents an error in reading the original YAML configuration file.
 	ErrInvalidLBFile = errors.New("couldn't read the loadbalancer configuration file")
+	// ErrInvalidPin represents a target pin with a malformed pattern or without a collector.
+	ErrInvalidPin = errors.New("invalid target pin")
+	// ErrDuplicateJob represents a job_name defined more than once across the configuration file and its fragments.
+	ErrDuplicateJob = errors.New("duplicate job_name")
+	// ErrUndefinedVariable represents a ${VAR} reference to an environment variable that is not set.
+	ErrUndefinedVariable = errors.New("undefined environment variable")
+	// ErrInvalidSecretFile represents a *_file reference to a file that can't be read.
+	ErrInvalidSecretFile = errors.New("couldn't read the secret file")
+	// ErrInvalidPrometheusConfig represents a Prometheus configuration file that Prometheus would not load.
+	ErrInvalidPrometheusConfig = errors.New("couldn't load the prometheus configuration")
+	// ErrInvalidAllocation represents an allocation with an unknown mode or parameters the mode doesn't take.
+	ErrInvalidAllocation = errors.New("invalid allocation")
+	// ErrInvalidPool represents a collector pool without a name of its own, defined twice or with a malformed job pattern.
+	ErrInvalidPool = errors.New("invalid collector pool")
 )
 
 var (
@@ -19,28 +55,114 @@ var (
 )
 
 type Config struct {
-	Mode          string            `yaml:"mode"`
-	LabelSelector map[string]string `yaml:"label_selector,omitempty"`
-	Config        ScrapeConfig      `yaml:"config"`
+	Mode          string               `yaml:"mode"`
+	LabelSelector map[string]string    `yaml:"label_selector,omitempty"`
+	Config        ScrapeConfig         `yaml:"config"`
+	Pins          []Pin                `yaml:"pins,omitempty"`
+	Auth          *AuthConfig          `yaml:"auth,omitempty"`
+	OTelCollector *OTelCollectorConfig `yaml:"otel_collector,omitempty"`
+	Monitors      *MonitorsConfig      `yaml:"monitors,omitempty"`
+	// Pools split the collectors into named sets, the jobs bound to a pool are only allocated to its collectors
+	Pools []CollectorPool `yaml:"pools,omitempty"`
+	// Allocation overrides the global mode for the targets of the jobs it names
+	Allocation map[string]AllocationConfig `yaml:"allocation,omitempty"`
+	// PrometheusConfigFile is a Prometheus configuration file whose scrape jobs are appended to the ones of this file
+	PrometheusConfigFile string `yaml:"prometheus_config_file,omitempty"`
+	// PrometheusFiles lists the Prometheus configuration file and the globs of its scrape_config_files,
+	// a change to them requires a reload
+	PrometheusFiles []string `yaml:"-"`
+	// SecretFiles lists the files the *_file references were resolved from, a change to them requires a reload
+	SecretFiles []string `yaml:"-"`
+	// digest is the hash of the files the configuration was loaded from
+	digest []byte
 }
 
-type ScrapeConfig struct {
-	ScrapeConfigs []map[string]interface{} `yaml:"scrape_configs"`
+// MonitorsConfig turns prometheus-operator ServiceMonitor and PodMonitor objects into scrape jobs
+type MonitorsConfig struct {
+	// Namespaces to watch monitors in, every namespace when empty
+	Namespaces             []string          `yaml:"namespaces,omitempty"`
+	ServiceMonitorSelector map[string]string `yaml:"service_monitor_selector,omitempty"`
+	PodMonitorSelector     map[string]string `yaml:"pod_monitor_selector,omitempty"`
 }
 
-func unmarshall(cfg *Config, configFile string) error {
-	yamlFile, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return ErrInvalidLBFile
-	}
+// OTelCollectorConfig holds the parts of the rendered OpenTelemetry Collector configuration that are not generated
+type OTelCollectorConfig struct {
+	// BaseURL is the address collectors reach the load balancer at; defaults to the address a request came in on
+	BaseURL string `yaml:"base_url,omitempty"`
+	// BearerTokenFile is read by the collectors to authenticate their http_sd_configs
+	BearerTokenFile string `yaml:"bearer_token_file,omitempty"`
+	// Exporters is a text/template of the exporters section, executed with the collector name and its jobs
+	Exporters string `yaml:"exporters,omitempty"`
+}
 
-	err = yaml.UnmarshalStrict(yamlFile, cfg)
-	if err != nil {
-		return ErrInvalidLBYAML
+// AuthConfig enables authentication on the HTTP API; collectors may then only read their own targets
+type AuthConfig struct {
+	Tokens             []TokenConfig      `yaml:"tokens,omitempty"`
+	BasicAuth          []BasicAuthConfig  `yaml:"basic_auth,omitempty"`
+	TokenReview        *TokenReviewConfig `yaml:"kubernetes_token_review,omitempty"`
+	ClientCertificates bool               `yaml:"client_certificates,omitempty"`
+	// Admins lists the identities allowed to read every collector's targets and to call admin endpoints
+	Admins []string `yaml:"admins,omitempty"`
+}
+
+// TokenConfig maps a static bearer token to an identity
+type TokenConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// BasicAuthConfig holds a user and its bcrypt hashed password
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// TokenReviewConfig validates bearer tokens with the Kubernetes TokenReview API
+type TokenReviewConfig struct {
+	Audiences []string `yaml:"audiences,omitempty"`
+	// ServiceAccounts of the collectors: a token bound to a pod of one of them identifies the collector by the
+	// pod name, other tokens are identified by their user name, e.g. system:serviceaccount:<namespace>:<name>
+	ServiceAccounts []ServiceAccountConfig `yaml:"service_accounts,omitempty"`
+}
+
+// ServiceAccountConfig names a Kubernetes service account
+type ServiceAccountConfig struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// Pin always assigns the targets matching a "job/target" glob pattern to the named collector
+type Pin struct {
+	Target    string `yaml:"target" json:"target"`
+	Collector string `yaml:"collector" json:"collector"`
+}
+
+// Validate checks that the pin has a well-formed pattern and a collector
+func (p Pin) Validate() error {
+	if p.Target == "" || p.Collector == "" {
+		return fmt.Errorf("%w: target and collector are required", ErrInvalidPin)
+	}
+	if _, err := path.Match(p.Target, ""); err != nil {
+		return fmt.Errorf("%w: %q: %s", ErrInvalidPin, p.Target, err)
 	}
 	return nil
 }
 
+// Matches reports whether the pin applies to the given job and target
+func (p Pin) Matches(jobName, target string) bool {
+	ok, _ := path.Match(p.Target, jobName+"/"+target)
+	return ok
+}
+
+// ScrapeConfig holds the scrape jobs, decoded and validated by Prometheus
+type ScrapeConfig struct {
+	ScrapeConfigs []*promconfig.ScrapeConfig `yaml:"scrape_configs"`
+}
+
+// Load reads the configuration file, defaulting to ./conf/loadbalancer.yaml; any further arguments are globs
+// of fragment files whose scrape_configs are appended to the ones of the configuration file, followed by the
+// ones of the Prometheus configuration file it refers to
+// ${VAR} references are expanded from the environment and the secret *_file references of SD configs are resolved
 func Load(newConfigFile ...string) (Config, error) {
 	cfg := Config{}
 	configFile := defaultConfigFile
@@ -48,9 +170,143 @@ func Load(newConfigFile ...string) (Config, error) {
 		configFile = newConfigFile[0]
 	}
 
-	if err := unmarshall(&cfg, configFile); err != nil {
+	main, err := readSource(configFile, "config", "scrape_configs")
+	if err != nil {
+		return Config{}, err
+	}
+	if err := main.decode(&cfg); err != nil {
 		return Config{}, err
 	}
+	sources := []*source{main}
+
+	if len(newConfigFile) > 1 {
+		fragments, err := Fragments(configFile, newConfigFile[1:]...)
+		if err != nil {
+			return Config{}, err
+		}
+		for _, fragment := range fragments {
+			src, err := readSource(fragment, "scrape_configs")
+			if err != nil {
+				return Config{}, err
+			}
+			scrapeConfig := ScrapeConfig{}
+			if err := src.decode(&scrapeConfig); err != nil {
+				return Config{}, err
+			}
+			cfg.Config.ScrapeConfigs = append(cfg.Config.ScrapeConfigs, scrapeConfig.ScrapeConfigs...)
+			sources = append(sources, src)
+		}
+	}
+
+	if cfg.PrometheusConfigFile != "" {
+		promCfg, err := loadPrometheusConfig(cfg.PrometheusConfigFile)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Config.ScrapeConfigs = append(cfg.Config.ScrapeConfigs, promCfg.scrapeConfigs...)
+		cfg.PrometheusFiles = promCfg.patterns
+		sources = append(sources, promCfg.sources...)
+	}
+
+	defined := map[string]string{}
+	digest := sha256.New()
+	for _, src := range sources {
+		if err := src.recordJobs(defined); err != nil {
+			return Config{}, err
+		}
+		cfg.SecretFiles = append(cfg.SecretFiles, src.secretFiles...)
+		digest.Write(src.data)
+	}
+	cfg.digest = digest.Sum(nil)
+
+	for _, sc := range cfg.Config.ScrapeConfigs {
+		if err := ApplyDefaults(sc); err != nil {
+			return Config{}, fmt.Errorf("%w: %s: job %q: %s", ErrInvalidLBYAML, defined[sc.JobName], sc.JobName, err)
+		}
+	}
+
+	for _, pin := range cfg.Pins {
+		if err := pin.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	pools := map[string]bool{}
+	for _, pool := range cfg.Pools {
+		if err := pool.Validate(); err != nil {
+			return Config{}, fmt.Errorf("%s: pools: %w", configFile, err)
+		}
+		if pools[pool.Name] {
+			return Config{}, fmt.Errorf("%s: pools: %w: %s is defined twice", configFile, ErrInvalidPool, pool.Name)
+		}
+		pools[pool.Name] = true
+	}
+
+	if err := cfg.DefaultAllocation().Validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: mode: %w", configFile, err)
+	}
+	jobs := make([]string, 0, len(cfg.Allocation))
+	for job := range cfg.Allocation {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		if err := cfg.Allocation[job].Validate(); err != nil {
+			return Config{}, fmt.Errorf("%s: allocation of job %q: %w", configFile, job, err)
+		}
+	}
 
 	return cfg, nil
 }
+
+// ApplyDefaults sets the scrape interval and timeout a job leaves out to the global defaults of Prometheus
+func ApplyDefaults(sc *promconfig.ScrapeConfig) error {
+	return applyGlobal(sc, promconfig.DefaultGlobalConfig)
+}
+
+// applyGlobal sets the scrape interval and timeout a job leaves out to the ones of a global section
+func applyGlobal(sc *promconfig.ScrapeConfig, global promconfig.GlobalConfig) error {
+	if sc.ScrapeInterval == 0 {
+		sc.ScrapeInterval = global.ScrapeInterval
+	}
+	if sc.ScrapeTimeout == 0 {
+		sc.ScrapeTimeout = global.ScrapeTimeout
+		if sc.ScrapeTimeout > sc.ScrapeInterval {
+			sc.ScrapeTimeout = sc.ScrapeInterval
+		}
+	}
+	if sc.ScrapeTimeout > sc.ScrapeInterval {
+		return fmt.Errorf("scrape timeout %s greater than scrape interval %s", sc.ScrapeTimeout, sc.ScrapeInterval)
+	}
+	return nil
+}
+
+// Fragments lists the files matched by the fragment globs in a stable order, leaving out the configuration file
+func Fragments(configFile string, globs ...string) ([]string, error) {
+	seen := map[string]bool{filepath.Clean(configFile): true}
+	files := []string{}
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidLBFile, glob, err)
+		}
+		for _, match := range matches {
+			if !seen[filepath.Clean(match)] {
+				seen[filepath.Clean(match)] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Hash identifies the content of the configuration, so a reload can be told apart from the previous one
+// Secrets marshal as <secret>, so the files the configuration was loaded from are hashed as well
+func (c Config) Hash() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(append(out, c.digest...))
+	return hex.EncodeToString(sum[:])
+}
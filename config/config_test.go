@@ -1,30 +1,297 @@
 package config
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/http-sd-loadbalancer/suite"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/file"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestConfigLoad(t *testing.T) {
 	// prepare
-	cfg := Config{}
 	defaultConfigTestFile := suite.GetConfigTestFile()
-	expectedFileSDConfig := map[interface{}]interface{}{"files": []interface{}{"../suite/file_sd_test.yaml"}}
-	expectedStaticSDConfig := map[interface{}]interface{}{"targets": []interface{}{"prom.domain:9001", "prom.domain:9002", "prom.domain:9003"}}
 
 	// test
-	err := unmarshall(&cfg, defaultConfigTestFile)
-	actualFileSDConfig := cfg.Config.ScrapeConfigs[0]["file_sd_configs"].([]interface{})[0]
-	actulaStaticSDConfig := cfg.Config.ScrapeConfigs[0]["static_configs"].([]interface{})[0]
+	cfg, err := Load(defaultConfigTestFile)
 
 	//verify
 	assert.NoError(t, err)
 	assert.Equal(t, cfg.Mode, "LeastConnection")
 	assert.Equal(t, cfg.LabelSelector["app.kubernetes.io/instance"], "default.test")
 	assert.Equal(t, cfg.LabelSelector["app.kubernetes.io/managed-by"], "opentelemetry-operator")
-	assert.Equal(t, cfg.Config.ScrapeConfigs[0]["job_name"], "prometheus")
-	assert.Equal(t, expectedFileSDConfig, actualFileSDConfig)
-	assert.Equal(t, expectedStaticSDConfig, actulaStaticSDConfig)
+	assert.Equal(t, cfg.Config.ScrapeConfigs[0].JobName, "prometheus")
+	assert.Equal(t, discovery.Configs{
+		&file.SDConfig{Files: []string{"../suite/file_sd_test.json"}, RefreshInterval: file.DefaultSDConfig.RefreshInterval},
+		discovery.StaticConfig{{
+			Targets: []model.LabelSet{{"__address__": "prom.domain:9001"}, {"__address__": "prom.domain:9002"}, {"__address__": "prom.domain:9003"}},
+			Labels:  model.LabelSet{"my": "label"},
+			Source:  "0",
+		}},
+	}, cfg.Config.ScrapeConfigs[0].ServiceDiscoveryConfigs)
+	assert.Equal(t, []Pin{{Target: "prometheus/prom.domain:*", Collector: "collector-1"}}, cfg.Pins)
+	assert.Equal(t, []TokenConfig{{Name: "collector-1", Token: "collector-1-token"}}, cfg.Auth.Tokens)
+	assert.Equal(t, "admin", cfg.Auth.BasicAuth[0].Username)
+	assert.Equal(t, []string{"http-sd-loadbalancer"}, cfg.Auth.TokenReview.Audiences)
+	assert.Equal(t, []string{"admin"}, cfg.Auth.Admins)
+}
+
+func TestConfigLoadDefaults(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	main := writeFile(t, dir, "loadbalancer.yaml", `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: defaults
+  - job_name: fast
+    scrape_interval: 5s
+  - job_name: slow
+    scrape_interval: 5m
+    scrape_timeout: 1m
+    scheme: https
+`)
+
+	// test
+	cfg, err := Load(main)
+
+	// verify
+	assert.NoError(t, err)
+	defaults, fast, slow := cfg.Config.ScrapeConfigs[0], cfg.Config.ScrapeConfigs[1], cfg.Config.ScrapeConfigs[2]
+	assert.Equal(t, model.Duration(time.Minute), defaults.ScrapeInterval)
+	assert.Equal(t, model.Duration(10*time.Second), defaults.ScrapeTimeout)
+	assert.Equal(t, "http", defaults.Scheme)
+	assert.Equal(t, "/metrics", defaults.MetricsPath)
+	assert.Equal(t, model.Duration(5*time.Second), fast.ScrapeTimeout)
+	assert.Equal(t, model.Duration(time.Minute), slow.ScrapeTimeout)
+	assert.Equal(t, "https", slow.Scheme)
+}
+
+func TestConfigLoadErrors(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	os.Setenv("LB_TEST_INTERVAL", "30s")
+	defer os.Unsetenv("LB_TEST_INTERVAL")
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "should point at an unknown field",
+			content: `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: node
+    static_configs:
+    - targets: [node:9100]
+      lables: {env: prod}
+`,
+			expected: "line 7: field lables not found",
+		},
+		{
+			name: "should point at an unknown field after expansion",
+			content: `mode: LeastConnection
+
+# the interval is set per environment
+config:
+  scrape_configs:
+
+  - job_name: node
+    scrape_interval: ${LB_TEST_INTERVAL}
+    scrape_intreval: 1m
+`,
+			expected: "line 9: field scrape_intreval not found",
+		},
+		{
+			name: "should point at a job failing validation",
+			content: `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: node
+  - job_name: ""
+    static_configs:
+    - targets: [node:9100]
+`,
+			expected: "loadbalancer.yaml:5: job_name is empty",
+		},
+		{
+			name: "should point at a job with a timeout above its interval",
+			content: `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: node
+    scrape_interval: 10s
+    scrape_timeout: 1m
+`,
+			expected: `loadbalancer.yaml:4: job "node": scrape timeout 1m greater than scrape interval 10s`,
+		},
+		{
+			name: "should reject a job defined twice",
+			content: `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: node
+  - job_name: node
+`,
+			expected: `duplicate job_name: "node" at`,
+		},
+		{
+			name: "should reject an unknown mode",
+			content: `mode: RoundRobin
+`,
+			expected: `loadbalancer.yaml: mode: invalid allocation: unknown mode "RoundRobin"`,
+		},
+		{
+			name: "should reject parameters the mode of a job doesn't take",
+			content: `mode: ConsistentHashing
+allocation:
+  kafka:
+    mode: LeastConnection
+    virtual_nodes: 10
+`,
+			expected: `loadbalancer.yaml: allocation of job "kafka": invalid allocation: virtual_nodes and hash_labels only apply to ConsistentHashing`,
+		},
+		{
+			name: "should reject a pool defined twice",
+			content: `mode: LeastConnection
+pools:
+- name: infra
+  jobs: [node]
+- name: infra
+  jobs: [kafka]
+`,
+			expected: `loadbalancer.yaml: pools: invalid collector pool: infra is defined twice`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main := writeFile(t, dir, "loadbalancer.yaml", tt.content)
+
+			// test
+			_, err := Load(main)
+
+			// verify
+			assert.Error(t, err)
+			if err != nil {
+				assert.Contains(t, err.Error(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestPinValidation(t *testing.T) {
+	assert.NoError(t, Pin{Target: "job/*", Collector: "collector-1"}.Validate())
+	assert.ErrorIs(t, Pin{Target: "job/[", Collector: "collector-1"}.Validate(), ErrInvalidPin)
+	assert.ErrorIs(t, Pin{Target: "job/*"}.Validate(), ErrInvalidPin)
+}
+
+func TestPinMatches(t *testing.T) {
+	pin := Pin{Target: "kube-state-metrics/*", Collector: "collector-1"}
+
+	assert.True(t, pin.Matches("kube-state-metrics", "ksm.domain:8080"))
+	assert.False(t, pin.Matches("node-exporter", "ksm.domain:8080"))
+}
+
+func TestPoolValidation(t *testing.T) {
+	assert.NoError(t, CollectorPool{Name: "infra", Jobs: []string{"node-*"}}.Validate())
+	assert.ErrorIs(t, CollectorPool{Name: "infra", Jobs: []string{"node-["}}.Validate(), ErrInvalidPool)
+	assert.ErrorIs(t, CollectorPool{Jobs: []string{"node-*"}}.Validate(), ErrInvalidPool)
+	assert.ErrorIs(t, CollectorPool{Name: DefaultPool}.Validate(), ErrInvalidPool)
+}
+
+func TestJobPool(t *testing.T) {
+	pools := []CollectorPool{
+		{Name: "infra", Jobs: []string{"node-*", "kube-state-metrics"}},
+		{Name: "apps", Jobs: []string{"*"}},
+	}
+
+	assert.Equal(t, "infra", JobPool(pools, "node-exporter"))
+	assert.Equal(t, "apps", JobPool(pools, "checkout"))
+	assert.Equal(t, DefaultPool, JobPool(nil, "checkout"))
+}
+
+func TestConfigHash(t *testing.T) {
+	cfg, err := Load(suite.GetConfigTestFile())
+	assert.NoError(t, err)
+	changed := cfg
+	changed.Mode = "Other"
+
+	assert.Len(t, cfg.Hash(), 64)
+	assert.Equal(t, cfg.Hash(), cfg.Hash())
+	assert.NotEqual(t, cfg.Hash(), changed.Hash())
+}
+
+func writeFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func TestConfigLoadFragments(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	main := writeFile(t, dir, "loadbalancer.yaml", `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: prometheus
+    static_configs:
+    - targets: ["prom.domain:9001"]
+`)
+	writeFile(t, dir, "team-b.yaml", `scrape_configs:
+- job_name: checkout
+- job_name: cart
+`)
+	writeFile(t, dir, "team-a.yaml", `scrape_configs:
+- job_name: node
+`)
+
+	t.Run("should append the jobs of the fragments in file order", func(t *testing.T) {
+		// test
+		cfg, err := Load(main, filepath.Join(dir, "*.yaml"))
+
+		// verify
+		assert.NoError(t, err)
+		names := []string{}
+		for _, sc := range cfg.Config.ScrapeConfigs {
+			names = append(names, sc.JobName)
+		}
+		assert.Equal(t, []string{"prometheus", "node", "checkout", "cart"}, names)
+	})
+
+	t.Run("should reject a job defined twice", func(t *testing.T) {
+		// prepare
+		writeFile(t, dir, "team-c.yaml", `# owned by team c
+scrape_configs:
+- job_name: other
+- job_name: checkout
+`)
+
+		// test
+		_, err := Load(main, filepath.Join(dir, "*.yaml"))
+
+		// verify
+		assert.ErrorIs(t, err, ErrDuplicateJob)
+		assert.EqualError(t, err, fmt.Sprintf(`duplicate job_name: "checkout" at %s:4, first defined at %s:2`,
+			filepath.Join(dir, "team-c.yaml"), filepath.Join(dir, "team-b.yaml")))
+	})
+
+	t.Run("should reject a malformed fragment", func(t *testing.T) {
+		// prepare
+		writeFile(t, dir, "team-c.yaml", "jobs: []\n")
+
+		// test
+		_, err := Load(main, filepath.Join(dir, "*.yaml"))
+
+		// verify
+		assert.ErrorIs(t, err, ErrInvalidLBYAML)
+	})
 }
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// DefaultPool is the pool of the collectors matching the top-level label_selector, it holds the jobs bound to no pool
+const DefaultPool = "default"
+
+// CollectorPool is a named set of collectors chosen by a label selector; the jobs bound to it are only allocated
+// to its collectors
+type CollectorPool struct {
+	Name          string            `yaml:"name"`
+	LabelSelector map[string]string `yaml:"label_selector"`
+	// Jobs are glob patterns of the names of the jobs bound to the pool
+	Jobs []string `yaml:"jobs,omitempty"`
+}
+
+// Validate checks that the pool has a name of its own and well-formed job patterns
+func (p CollectorPool) Validate() error {
+	if p.Name == "" || p.Name == DefaultPool {
+		return fmt.Errorf("%w: a name other than %q is required", ErrInvalidPool, DefaultPool)
+	}
+	for _, job := range p.Jobs {
+		if _, err := path.Match(job, ""); err != nil {
+			return fmt.Errorf("%w: %s: %q: %s", ErrInvalidPool, p.Name, job, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the job is bound to the pool
+func (p CollectorPool) Matches(jobName string) bool {
+	for _, job := range p.Jobs {
+		if ok, _ := path.Match(job, jobName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// JobPool returns the pool a job is bound to: the first pool matching it, or the default pool
+func JobPool(pools []CollectorPool, jobName string) string {
+	for _, pool := range pools {
+		if pool.Matches(jobName) {
+			return pool.Name
+		}
+	}
+	return DefaultPool
+}
+
+// PoolSelectors returns the label selector of every pool, including the one of the default pool
+func (c Config) PoolSelectors() map[string]map[string]string {
+	selectors := map[string]map[string]string{DefaultPool: c.LabelSelector}
+	for _, pool := range c.Pools {
+		selectors[pool.Name] = pool.LabelSelector
+	}
+	return selectors
+}
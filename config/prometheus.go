@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	promconfig "github.com/prometheus/prometheus/config"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// prometheusConfig holds the scrape jobs of a Prometheus configuration file and of its scrape_config_files
+type prometheusConfig struct {
+	scrapeConfigs []*promconfig.ScrapeConfig
+	sources       []*source
+	// patterns are the file and the globs of its includes, for watching
+	patterns []string
+}
+
+// loadPrometheusConfig loads a Prometheus configuration file the way Prometheus does: relative paths are joined
+// with the directory of the file and the scrape intervals and timeouts default to its global section
+// scrape_config_files includes are resolved here, since the Prometheus loader of this version doesn't know them
+func loadPrometheusConfig(file string) (prometheusConfig, error) {
+	loaded := prometheusConfig{patterns: []string{file}}
+	dir := filepath.Dir(file)
+
+	src, err := parseSource(file, "scrape_configs")
+	if err != nil {
+		return prometheusConfig{}, err
+	}
+	includes, err := takeIncludes(src)
+	if err != nil {
+		return prometheusConfig{}, err
+	}
+	promCfg, err := promconfig.Load(string(src.data), false, nil)
+	if err != nil {
+		return prometheusConfig{}, fmt.Errorf("%w: %s: %s", ErrInvalidPrometheusConfig, file, src.remap(err.Error()))
+	}
+	promCfg.SetDirectory(dir)
+	loaded.scrapeConfigs = promCfg.ScrapeConfigs
+	loaded.sources = append(loaded.sources, src)
+
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+		loaded.patterns = append(loaded.patterns, include)
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return prometheusConfig{}, fmt.Errorf("%w: %s: %q: %s", ErrInvalidPrometheusConfig, file, include, err)
+		}
+		for _, match := range matches {
+			src, err := parseSource(match, "scrape_configs")
+			if err != nil {
+				return prometheusConfig{}, err
+			}
+			scrapeConfig := ScrapeConfig{}
+			if err := src.decode(&scrapeConfig); err != nil {
+				return prometheusConfig{}, err
+			}
+			for _, sc := range scrapeConfig.ScrapeConfigs {
+				if err := applyGlobal(sc, promCfg.GlobalConfig); err != nil {
+					return prometheusConfig{}, fmt.Errorf("%w: %s: job %q: %s", ErrInvalidPrometheusConfig, match, sc.JobName, err)
+				}
+				sc.SetDirectory(filepath.Dir(match))
+			}
+			loaded.scrapeConfigs = append(loaded.scrapeConfigs, scrapeConfig.ScrapeConfigs...)
+			loaded.sources = append(loaded.sources, src)
+		}
+	}
+	return loaded, nil
+}
+
+// takeIncludes removes the scrape_config_files globs from the file and returns them
+func takeIncludes(src *source) ([]string, error) {
+	if len(src.doc.Content) == 0 || src.doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	root := src.doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "scrape_config_files" {
+			continue
+		}
+		includes := []string{}
+		if err := root.Content[i+1].Decode(&includes); err != nil {
+			return nil, fmt.Errorf("%w: %s: scrape_config_files: %s", ErrInvalidPrometheusConfig, src.file, err)
+		}
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		return includes, src.reencode()
+	}
+	return nil, nil
+}
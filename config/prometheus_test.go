@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigLoadPrometheus(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "jobs"), 0755))
+	prometheusFile := writeFile(t, dir, "prometheus.yml", `global:
+  scrape_interval: 30s
+  scrape_timeout: 5s
+rule_files: [rules/*.yml]
+scrape_config_files: [jobs/*.yml]
+scrape_configs:
+- job_name: node
+  file_sd_configs:
+  - files: [targets/node.json]
+`)
+	writeFile(t, filepath.Join(dir, "jobs"), "cart.yml", `scrape_configs:
+- job_name: cart
+  scrape_interval: 10s
+  file_sd_configs:
+  - files: [cart.json]
+`)
+	main := writeFile(t, dir, "loadbalancer.yaml", `mode: LeastConnection
+prometheus_config_file: `+prometheusFile+`
+config:
+  scrape_configs:
+  - job_name: prometheus
+`)
+
+	t.Run("should append the jobs of the prometheus configuration and of its includes", func(t *testing.T) {
+		// test
+		cfg, err := Load(main)
+
+		// verify
+		assert.NoError(t, err)
+		assert.Len(t, cfg.Config.ScrapeConfigs, 3)
+		prometheus, node, cart := cfg.Config.ScrapeConfigs[0], cfg.Config.ScrapeConfigs[1], cfg.Config.ScrapeConfigs[2]
+		assert.Equal(t, "prometheus", prometheus.JobName)
+		assert.Equal(t, model.Duration(time.Minute), prometheus.ScrapeInterval)
+		assert.Equal(t, "node", node.JobName)
+		assert.Equal(t, model.Duration(30*time.Second), node.ScrapeInterval)
+		assert.Equal(t, model.Duration(5*time.Second), node.ScrapeTimeout)
+		assert.Equal(t, []string{filepath.Join(dir, "targets/node.json")}, node.ServiceDiscoveryConfigs[0].(*file.SDConfig).Files)
+		assert.Equal(t, "cart", cart.JobName)
+		assert.Equal(t, model.Duration(10*time.Second), cart.ScrapeInterval)
+		assert.Equal(t, model.Duration(5*time.Second), cart.ScrapeTimeout)
+		assert.Equal(t, []string{filepath.Join(dir, "jobs", "cart.json")}, cart.ServiceDiscoveryConfigs[0].(*file.SDConfig).Files)
+		assert.Equal(t, []string{prometheusFile, filepath.Join(dir, "jobs", "*.yml")}, cfg.PrometheusFiles)
+	})
+
+	t.Run("should change the hash with the prometheus configuration", func(t *testing.T) {
+		// prepare
+		before, err := Load(main)
+		assert.NoError(t, err)
+		writeFile(t, filepath.Join(dir, "jobs"), "cart.yml", `scrape_configs:
+- job_name: cart
+  scrape_interval: 15s
+`)
+
+		// test
+		after, err := Load(main)
+
+		// verify
+		assert.NoError(t, err)
+		assert.NotEqual(t, before.Hash(), after.Hash())
+	})
+
+	t.Run("should reject a job defined in both configurations", func(t *testing.T) {
+		// prepare
+		writeFile(t, filepath.Join(dir, "jobs"), "node.yml", `scrape_configs:
+- job_name: node
+`)
+		defer os.Remove(filepath.Join(dir, "jobs", "node.yml"))
+
+		// test
+		_, err := Load(main)
+
+		// verify
+		assert.ErrorIs(t, err, ErrDuplicateJob)
+		assert.EqualError(t, err, fmt.Sprintf(`duplicate job_name: "node" at %s:2, first defined at %s:7`,
+			filepath.Join(dir, "jobs", "node.yml"), prometheusFile))
+	})
+
+	t.Run("should reject what prometheus rejects", func(t *testing.T) {
+		// prepare
+		broken := writeFile(t, dir, "broken.yml", `scrape_config_files: [jobs/*.yml]
+scrape_configs:
+- job_name: node
+  scrape_interval: 5s
+  scrape_timeout: 10s
+`)
+		brokenMain := writeFile(t, dir, "broken-loadbalancer.yaml", "mode: LeastConnection\nprometheus_config_file: "+broken+"\n")
+
+		// test
+		_, err := Load(brokenMain)
+
+		// verify
+		assert.ErrorIs(t, err, ErrInvalidPrometheusConfig)
+		assert.Contains(t, err.Error(), broken)
+	})
+}
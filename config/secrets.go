@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// redacted replaces a secret in every output of the configuration
+const redacted = "<secret>"
+
+// secretKeys are the keys holding credentials in SD configs
+// A "<key>_file" reference in an SD config is replaced by the key with the content of the file
+var secretKeys = map[string]bool{
+	"token":                         true,
+	"password":                      true,
+	"secret_key":                    true,
+	"auth_token":                    true,
+	"client_secret":                 true,
+	"application_credential_secret": true,
+	"bearer_token":                  true,
+	"credentials":                   true,
+}
+
+// envReference matches ${VAR}; $${VAR} is kept as a literal ${VAR}
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// relabelKeys hold relabel configs, whose replacement is not expanded since it refers to the groups of the
+// regex as ${1} or ${name}
+var relabelKeys = map[string]bool{
+	"relabel_configs":        true,
+	"metric_relabel_configs": true,
+}
+
+// expandNode expands the references of every scalar, collecting the variables that are not set, and reports
+// whether anything was expanded
+func expandNode(node *yamlv3.Node, undefined *[]string) bool {
+	expanded := false
+	if node.Kind == yamlv3.ScalarNode && envReference.MatchString(node.Value) {
+		// a plain value is typed after expansion, so port: ${PORT} remains a number
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		node.Value = envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref[1] == '$' {
+				return ref[1:]
+			}
+			name := envReference.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				*undefined = append(*undefined, name)
+			}
+			return value
+		})
+		expanded = true
+	}
+	for i, child := range node.Content {
+		if node.Kind == yamlv3.MappingNode && i%2 == 1 && relabelKeys[node.Content[i-1].Value] {
+			if expandRelabelConfigs(child, undefined) {
+				expanded = true
+			}
+			continue
+		}
+		if expandNode(child, undefined) {
+			expanded = true
+		}
+	}
+	return expanded
+}
+
+// expandRelabelConfigs expands the references of a list of relabel configs except their replacement
+func expandRelabelConfigs(node *yamlv3.Node, undefined *[]string) bool {
+	expanded := false
+	for _, item := range node.Content {
+		if item.Kind != yamlv3.MappingNode {
+			if expandNode(item, undefined) {
+				expanded = true
+			}
+			continue
+		}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			if item.Content[i].Value != "replacement" && expandNode(item.Content[i+1], undefined) {
+				expanded = true
+			}
+		}
+	}
+	return expanded
+}
+
+// resolveSecretFiles replaces the "<key>_file" references below an SD config node by the content of the files
+// SD runs in the load balancer only, the references of the scrape configs are left to the collectors
+func resolveSecretFiles(node *yamlv3.Node, files *[]string) error {
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			name := strings.TrimSuffix(key.Value, "_file")
+			if name == key.Value || !secretKeys[name] || value.Kind != yamlv3.ScalarNode {
+				continue
+			}
+			content, err := ioutil.ReadFile(value.Value)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %s", ErrInvalidSecretFile, key.Value, err)
+			}
+			*files = append(*files, value.Value)
+			key.Value = name
+			value.Value, value.Tag, value.Style = strings.TrimSpace(string(content)), "!!str", yamlv3.DoubleQuotedStyle
+		}
+	}
+	for _, child := range node.Content {
+		if err := resolveSecretFiles(child, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration without the credentials of the API, for display
+// The credentials of the scrape jobs are Prometheus secrets, which marshal as <secret> already
+func (c Config) Redacted() Config {
+	if c.Auth != nil {
+		auth := *c.Auth
+		auth.Tokens = make([]TokenConfig, 0, len(c.Auth.Tokens))
+		for _, token := range c.Auth.Tokens {
+			auth.Tokens = append(auth.Tokens, TokenConfig{Name: token.Name, Token: redacted})
+		}
+		auth.BasicAuth = make([]BasicAuthConfig, 0, len(c.Auth.BasicAuth))
+		for _, user := range c.Auth.BasicAuth {
+			auth.BasicAuth = append(auth.BasicAuth, BasicAuthConfig{Username: user.Username, PasswordHash: redacted})
+		}
+		c.Auth = &auth
+	}
+	return c
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	promcommon "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/discovery/consul"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfigExpansion(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	tokenFile := writeFile(t, dir, "consul-token", "s3cr3t\n")
+	os.Setenv("LB_TEST_CONSUL_SERVER", "consul.domain:8500")
+	defer os.Unsetenv("LB_TEST_CONSUL_SERVER")
+	os.Setenv("LB_TEST_TARGET_LABEL", "region")
+	defer os.Unsetenv("LB_TEST_TARGET_LABEL")
+	main := writeFile(t, dir, "loadbalancer.yaml", `mode: LeastConnection
+# the server is read from ${LB_TEST_UNSET} in other environments
+config:
+  scrape_configs:
+  - job_name: consul
+    consul_sd_configs:
+    - server: ${LB_TEST_CONSUL_SERVER}
+      token_file: `+tokenFile+`
+      tls_config:
+        ca_file: /etc/ca.pem
+    basic_auth:
+      username: prometheus
+      password_file: /etc/password
+    relabel_configs:
+    - source_labels: [__meta_consul_service]
+      replacement: ${1}-$${LB_TEST_LITERAL}
+      target_label: service
+    - source_labels: [__meta_consul_dc]
+      regex: (?P<region>[a-z]+)-(?P<zone>[0-9]+)
+      replacement: ${region}.${zone}
+      target_label: ${LB_TEST_TARGET_LABEL}
+    metric_relabel_configs:
+    - source_labels: [__name__]
+      regex: (?P<prefix>[a-z]+)_.*
+      replacement: ${prefix}
+      target_label: subsystem
+    params:
+      module:
+      - $${LB_TEST_LITERAL}
+`)
+
+	t.Run("should expand variables and resolve secret files of SD configs", func(t *testing.T) {
+		// test
+		cfg, err := Load(main)
+
+		// verify
+		assert.NoError(t, err)
+		job := cfg.Config.ScrapeConfigs[0]
+		sd := job.ServiceDiscoveryConfigs[0].(*consul.SDConfig)
+		assert.Equal(t, "consul.domain:8500", sd.Server)
+		assert.Equal(t, promcommon.Secret("s3cr3t"), sd.Token)
+		assert.Equal(t, "/etc/ca.pem", sd.HTTPClientConfig.TLSConfig.CAFile)
+		assert.Equal(t, "/etc/password", job.HTTPClientConfig.BasicAuth.PasswordFile)
+		assert.Equal(t, "${1}-$${LB_TEST_LITERAL}", job.RelabelConfigs[0].Replacement)
+		assert.Equal(t, "${region}.${zone}", job.RelabelConfigs[1].Replacement)
+		assert.Equal(t, "region", job.RelabelConfigs[1].TargetLabel)
+		assert.Equal(t, "${prefix}", job.MetricRelabelConfigs[0].Replacement)
+		assert.Equal(t, []string{"${LB_TEST_LITERAL}"}, job.Params["module"])
+		assert.Equal(t, []string{tokenFile}, cfg.SecretFiles)
+	})
+
+	t.Run("should change the hash with the content of a secret file", func(t *testing.T) {
+		// prepare
+		before, err := Load(main)
+		assert.NoError(t, err)
+		writeFile(t, dir, "consul-token", "rotated")
+
+		// test
+		after, err := Load(main)
+
+		// verify
+		assert.NoError(t, err)
+		assert.NotEqual(t, before.Hash(), after.Hash())
+	})
+
+	t.Run("should reject an undefined variable", func(t *testing.T) {
+		// prepare
+		os.Unsetenv("LB_TEST_CONSUL_SERVER")
+		defer os.Setenv("LB_TEST_CONSUL_SERVER", "consul.domain:8500")
+
+		// test
+		_, err := Load(main)
+
+		// verify
+		assert.ErrorIs(t, err, ErrUndefinedVariable)
+		assert.Contains(t, err.Error(), "LB_TEST_CONSUL_SERVER")
+	})
+
+	t.Run("should reject a missing secret file", func(t *testing.T) {
+		// prepare
+		assert.NoError(t, os.Remove(tokenFile))
+
+		// test
+		_, err := Load(main)
+
+		// verify
+		assert.ErrorIs(t, err, ErrInvalidSecretFile)
+	})
+}
+
+func TestConfigRedacted(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	main := writeFile(t, dir, "loadbalancer.yaml", `mode: LeastConnection
+config:
+  scrape_configs:
+  - job_name: consul
+    consul_sd_configs:
+    - server: consul.domain:8500
+      token: s3cr3t
+    basic_auth:
+      username: prometheus
+      password: hunter2
+auth:
+  tokens:
+  - name: collector-1
+    token: collector-1-token
+  basic_auth:
+  - username: admin
+    password_hash: $2a$10$...
+`)
+	cfg, err := Load(main)
+	assert.NoError(t, err)
+
+	// test
+	out, err := yaml.Marshal(cfg.Redacted())
+
+	// verify
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "s3cr3t")
+	assert.NotContains(t, string(out), "hunter2")
+	assert.NotContains(t, string(out), "collector-1-token")
+	assert.NotContains(t, string(out), "$2a$10$")
+	assert.Contains(t, string(out), "consul.domain:8500")
+	assert.Contains(t, string(out), "collector-1")
+	assert.Equal(t, "collector-1-token", cfg.Auth.Tokens[0].Token)
+}
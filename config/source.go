@@ -0,0 +1,180 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	promconfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// source is a configuration file with its environment variables expanded and its secret files resolved
+type source struct {
+	file string
+	// doc holds the positions of the file
+	doc *yamlv3.Node
+	// jobsPath is the path of keys to the scrape_configs sequence
+	jobsPath []string
+	// data is the YAML that is decoded, re-encoded from doc when anything was expanded or resolved
+	data        []byte
+	secretFiles []string
+	// lines maps the lines of the re-encoded data back to the lines of the file
+	lines map[int]int
+}
+
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+// parseSource reads a configuration file as is
+func parseSource(file string, jobsPath ...string) (*source, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLBFile, file)
+	}
+	s := &source{file: file, doc: &yamlv3.Node{}, jobsPath: jobsPath, data: data}
+	if err := yamlv3.Unmarshal(data, s.doc); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLBYAML, file, err)
+	}
+	return s, nil
+}
+
+// readSource reads a configuration file, expanding its variables and resolving the secret files of its SD configs
+func readSource(file string, jobsPath ...string) (*source, error) {
+	s, err := parseSource(file, jobsPath...)
+	if err != nil {
+		return nil, err
+	}
+
+	var undefined []string
+	expanded := expandNode(s.doc, &undefined)
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("%w: %s: %s", ErrUndefinedVariable, file, strings.Join(undefined, ", "))
+	}
+	for _, job := range s.jobs() {
+		for i := 0; i+1 < len(job.Content); i += 2 {
+			if strings.HasSuffix(job.Content[i].Value, "_sd_configs") {
+				if err := resolveSecretFiles(job.Content[i+1], &s.secretFiles); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if !expanded && len(s.secretFiles) == 0 {
+		return s, nil
+	}
+	if err := s.reencode(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reencode replaces the data by the encoding of the changed doc, keeping track of the lines of the file
+func (s *source) reencode() error {
+	data, err := yamlv3.Marshal(s.doc)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidLBYAML, s.file, err)
+	}
+	reencoded := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, &reencoded); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidLBYAML, s.file, err)
+	}
+	s.data = data
+	s.lines = map[int]int{}
+	mapLines(&reencoded, s.doc, s.lines)
+	return nil
+}
+
+// mapLines pairs the nodes of the re-encoded document with the ones of the file, which have the same shape
+func mapLines(reencoded, original *yamlv3.Node, lines map[int]int) {
+	if _, ok := lines[reencoded.Line]; !ok {
+		lines[reencoded.Line] = original.Line
+	}
+	for i := 0; i < len(reencoded.Content) && i < len(original.Content); i++ {
+		mapLines(reencoded.Content[i], original.Content[i], lines)
+	}
+}
+
+// jobs returns the nodes of the scrape jobs of the file
+func (s *source) jobs() []*yamlv3.Node {
+	if len(s.doc.Content) == 0 {
+		return nil
+	}
+	node := s.doc.Content[0]
+	for _, key := range s.jobsPath {
+		node = mappingValue(node, key)
+	}
+	if node == nil || node.Kind != yamlv3.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// decode decodes the file into out with errors pointing at the lines of the file
+// The validation errors of scrape jobs carry no line, so the failing job is looked up
+func (s *source) decode(out interface{}) error {
+	err := yaml.UnmarshalStrict(s.data, out)
+	if err == nil {
+		return nil
+	}
+	typeErr := &yaml.TypeError{}
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidLBYAML, s.file, s.remap(err.Error()))
+	}
+	for _, job := range s.jobs() {
+		data, marshalErr := yamlv3.Marshal(job)
+		if marshalErr != nil {
+			continue
+		}
+		if jobErr := yaml.UnmarshalStrict(data, &promconfig.ScrapeConfig{}); jobErr != nil {
+			return fmt.Errorf("%w: %s:%d: %s", ErrInvalidLBYAML, s.file, job.Line, jobErr)
+		}
+	}
+	return fmt.Errorf("%w: %s: %s", ErrInvalidLBYAML, s.file, err)
+}
+
+// remap replaces the lines of the re-encoded data in an error message by the lines of the file
+func (s *source) remap(msg string) string {
+	if s.lines == nil {
+		return msg
+	}
+	return errorLine.ReplaceAllStringFunc(msg, func(ref string) string {
+		line, _ := strconv.Atoi(errorLine.FindStringSubmatch(ref)[1])
+		if original, ok := s.lines[line]; ok {
+			return "line " + strconv.Itoa(original)
+		}
+		return ref
+	})
+}
+
+// recordJobs records where each job_name of the file is defined, keyed by job name as "file:line"
+func (s *source) recordJobs(defined map[string]string) error {
+	for _, job := range s.jobs() {
+		name := mappingValue(job, "job_name")
+		if name == nil {
+			continue
+		}
+		at := fmt.Sprintf("%s:%d", s.file, name.Line)
+		if first, ok := defined[name.Value]; ok {
+			return fmt.Errorf("%w: %q at %s, first defined at %s", ErrDuplicateJob, name.Value, at, first)
+		}
+		defined[name.Value] = at
+	}
+	return nil
+}
+
+// mappingValue returns the value of a key of a YAML mapping, or nil
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"gopkg.in/yaml.v2"
+)
+
+// debugState is everything needed to explain an assignment, served at /debug/state
+type debugState struct {
+	Config       debugConfig        `json:"config"`
+	Discovery    lbdiscovery.Sync   `json:"discovery"`
+	LoadBalancer loadbalancer.State `json:"load_balancer"`
+}
+
+type debugConfig struct {
+	Hash     string    `json:"hash"`
+	LoadedAt time.Time `json:"loaded_at"`
+	// YAML is the effective configuration, monitor jobs included and credentials redacted
+	YAML string `json:"yaml"`
+}
+
+func debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	redacted, _ := yaml.Marshal(effectiveConfig().Redacted())
+	writeJSON(w, r, "", debugState{
+		Config:       debugConfig{Hash: lbConfig.Hash(), LoadedAt: lbConfigLoaded, YAML: string(redacted)},
+		Discovery:    lbdiscovery.LastSync(),
+		LoadBalancer: lb.State(),
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/consul"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugState(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	defer func(cfg config.Config) { lbConfig = cfg }(lbConfig)
+	lbConfig = config.Config{Config: config.ScrapeConfig{ScrapeConfigs: []*promconfig.ScrapeConfig{
+		{JobName: "consul", ServiceDiscoveryConfigs: discovery.Configs{&consul.SDConfig{Token: "s3cr3t"}}},
+	}}}
+
+	// test
+	resp, err := http.Get(srv.URL + "/debug/state")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// verify
+	var state debugState
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	assert.Equal(t, lbConfig.Hash(), state.Config.Hash)
+	assert.Contains(t, state.Config.YAML, "job_name: consul")
+	assert.NotContains(t, state.Config.YAML, "s3cr3t")
+	assert.Equal(t, "collector-1", state.LoadBalancer.TargetItemMap["job-atarg:1000"].Collector)
+	assert.Len(t, state.LoadBalancer.CollectorMap, 2)
+	assert.Contains(t, state.LoadBalancer.TargetSet, "job-atarg:1000")
+}
+
+func TestDumpCommand(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	authenticator = auth.WithAdmins(auth.StaticTokens{"admin-token": "admin", "col-1-token": "collector-1"}, []string{"admin"})
+	defer func() { authenticator = nil }()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	t.Run("should pretty-print the state", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runDump([]string{"-url", srv.URL, "-token", "admin-token"}, &out)
+
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(out.String(), "{\n  \"config\": {"))
+		assert.Contains(t, out.String(), `"job-atarg:1000": {`)
+	})
+
+	t.Run("should fail for a collector", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runDump([]string{"-url", srv.URL, "-token", "col-1-token"}, &out)
+
+		assert.Error(t, err)
+		assert.Empty(t, out.String())
+	})
+}
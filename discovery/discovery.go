@@ -4,28 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/http-sd-loadbalancer/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery"
-	"github.com/prometheus/prometheus/discovery/azure"
-	"github.com/prometheus/prometheus/discovery/consul"
-	"github.com/prometheus/prometheus/discovery/digitalocean"
-	"github.com/prometheus/prometheus/discovery/dns"
-	"github.com/prometheus/prometheus/discovery/eureka"
-	"github.com/prometheus/prometheus/discovery/file"
-	"github.com/prometheus/prometheus/discovery/gce"
-	"github.com/prometheus/prometheus/discovery/hetzner"
-	"github.com/prometheus/prometheus/discovery/http"
-	"github.com/prometheus/prometheus/discovery/kubernetes"
-	"github.com/prometheus/prometheus/discovery/linode"
-	"github.com/prometheus/prometheus/discovery/marathon"
-	"github.com/prometheus/prometheus/discovery/openstack"
-	"github.com/prometheus/prometheus/discovery/scaleway"
-	"github.com/prometheus/prometheus/discovery/triton"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
 )
 
 var (
@@ -38,10 +29,76 @@ type TargetGroup struct {
 	Labels  model.LabelSet
 }
 
+// DropReasonRelabel is set on a discovered target that the relabel_configs of its job drop
+const DropReasonRelabel = "relabel"
+
 type TargetData struct {
 	JobName string
 	Target  string
 	Labels  model.LabelSet
+	// DropReason is set on a discovered target that is not to be allocated
+	DropReason string
+}
+
+// Group is a target group as received from an SD provider, before it is flattened into TargetData
+type Group struct {
+	Source  string         `json:"source"`
+	Targets []string       `json:"targets"`
+	Labels  model.LabelSet `json:"labels"`
+}
+
+// Sync records the last update received from the discovery manager
+type Sync struct {
+	Time time.Time `json:"time"`
+	// Providers holds the SD config kinds of every job, e.g. file_sd_configs
+	Providers map[string][]string `json:"providers"`
+	// Groups holds the raw target groups of every job by provider, sorted by source
+	Groups map[string]map[string][]Group `json:"groups"`
+}
+
+var (
+	lastSyncMtx sync.RWMutex
+	lastSync    = Sync{Providers: map[string][]string{}, Groups: map[string]map[string][]Group{}}
+	// relabelConfigs holds the relabel_configs of every job, guarded by lastSyncMtx
+	relabelConfigs = map[string][]*relabel.Config{}
+)
+
+// LastSync returns the last update received from the discovery manager
+func LastSync() Sync {
+	lastSyncMtx.RLock()
+	defer lastSyncMtx.RUnlock()
+	return lastSync
+}
+
+func recordSync(tsets map[string][]*targetgroup.Group) {
+	groups := make(map[string]map[string][]Group, len(tsets))
+	for name, tgs := range tsets {
+		jobName, provider := splitSetName(name)
+		jobGroups := make([]Group, 0, len(tgs))
+		for _, tg := range tgs {
+			group := Group{Source: tg.Source, Targets: make([]string, 0, len(tg.Targets)), Labels: tg.Labels}
+			for _, target := range tg.Targets {
+				group.Targets = append(group.Targets, string(target[model.AddressLabel]))
+			}
+			jobGroups = append(jobGroups, group)
+		}
+		sort.Slice(jobGroups, func(i, j int) bool { return jobGroups[i].Source < jobGroups[j].Source })
+		if groups[jobName] == nil {
+			groups[jobName] = make(map[string][]Group)
+		}
+		groups[jobName][provider] = jobGroups
+	}
+	lastSyncMtx.Lock()
+	defer lastSyncMtx.Unlock()
+	lastSync.Time = time.Now()
+	lastSync.Groups = groups
+}
+
+func recordProviders(providers map[string][]string, relabels map[string][]*relabel.Config) {
+	lastSyncMtx.Lock()
+	defer lastSyncMtx.Unlock()
+	lastSync.Providers = providers
+	relabelConfigs = relabels
 }
 
 func run(discoveryManager *discovery.Manager) error {
@@ -53,259 +110,61 @@ func run(discoveryManager *discovery.Manager) error {
 
 func getTargets(discoveryManager *discovery.Manager) ([]TargetData, error) {
 	tsets := <-discoveryManager.SyncCh()
+	recordSync(tsets)
 	targets := []TargetData{}
 
-	for jobName, tgs := range tsets {
-		for _, target := range tgs {
-			var targetGroup = TargetGroup{}
-			targetInterface, err := target.MarshalYAML()
-			if err != nil {
-				return nil, err
-			}
-
-			targetYAML, err := yaml.Marshal(targetInterface)
-			if err != nil {
-				return nil, err
-			}
-
-			if err = yaml.Unmarshal(targetYAML, &targetGroup); err != nil {
-				return nil, err
-			}
-
-			targetsList := targetGroup.Targets
-			for _, target := range targetsList {
-				targets = append(targets, TargetData{JobName: jobName, Target: target, Labels: targetGroup.Labels})
+	lastSyncMtx.RLock()
+	defer lastSyncMtx.RUnlock()
+	for name, tgs := range tsets {
+		jobName, _ := splitSetName(name)
+		for _, tg := range tgs {
+			for _, target := range tg.Targets {
+				// target labels, e.g. the pod of a Kubernetes SD target, take precedence over the group labels
+				labels := tg.Labels.Merge(target)
+				delete(labels, model.AddressLabel)
+				data := TargetData{JobName: jobName, Target: string(target[model.AddressLabel]), Labels: labels}
+				if !keep(target[model.AddressLabel], labels, relabelConfigs[jobName]) {
+					data.DropReason = DropReasonRelabel
+				}
+				targets = append(targets, data)
 			}
 		}
 	}
 	return targets, nil
 }
 
-func NewManager(ctx context.Context) *discovery.Manager {
-	return discovery.NewManager(ctx, log.NewNopLogger())
+// keep runs the relabel configs of the job over the target and reports whether the collector would scrape it;
+// targets it would drop are flagged and not allocated. The target keeps its labels from before relabelling
+func keep(address model.LabelValue, labels model.LabelSet, cfgs []*relabel.Config) bool {
+	if len(cfgs) == 0 {
+		return true
+	}
+	lset := make(promlabels.Labels, 0, len(labels)+1)
+	lset = append(lset, promlabels.Label{Name: model.AddressLabel, Value: string(address)})
+	for name, value := range labels {
+		lset = append(lset, promlabels.Label{Name: string(name), Value: string(value)})
+	}
+	sort.Sort(lset)
+	return relabel.Process(lset, cfgs...) != nil
+}
+
+func NewManager(ctx context.Context, logger log.Logger) *discovery.Manager {
+	return discovery.NewManager(ctx, log.With(logger, "component", "discovery manager"))
 }
 
-func Watch(discoveryManager *discovery.Manager, targets *[]TargetData) {
+func Watch(discoveryManager *discovery.Manager, targets *[]TargetData, logger log.Logger) {
 	var err error
 	*targets, err = getTargets(discoveryManager)
 	if err != nil {
-		fmt.Println(err)
+		level.Error(logger).Log("msg", "failed to read discovered targets", "err", err)
+		return
 	}
+	level.Debug(logger).Log("msg", "targets discovered", "targets", len(*targets))
 }
 
-func Get(discoveryManager *discovery.Manager, cfg config.Config) ([]TargetData, error) {
-	discoveryCfg := make(map[string]discovery.Configs)
-
-	for _, scrapeConfig := range cfg.Config.ScrapeConfigs {
-		discoveryConfigs := discovery.Configs{}
-		for name, sd := range scrapeConfig {
-			if strings.HasSuffix(name, "_sd_configs") {
-				sdYAML, _ := yaml.Marshal(sd)
-				switch name {
-				case "azure_sd_configs":
-					sdConfig := []azure.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling azure sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "consul_sd_configs":
-					sdConfig := []consul.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling consul sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "digitalocean_sd_configs":
-					sdConfig := []digitalocean.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling digitalocean sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				// case "docker_sd_configs":
-				// 	sdConfig := []docker.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error unmarshalling docker sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				// case "dockerswarm_sd_configs":
-				// 	sdConfig := []dockerswarm.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error unmarshalling dockerswarm sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				case "dns_sd_configs":
-					sdConfig := []dns.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling dns sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				// case "ec2_sd_configs":
-				// 	sdConfig := []ec2.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error unmarshalling ec2 sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				case "openstack_sd_configs":
-					sdConfig := []openstack.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling openstack sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "file_sd_configs":
-					sdConfig := []file.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling file sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "gce_sd_configs":
-					sdConfig := []gce.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling gce sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "hetzner_sd_configs":
-					sdConfig := []hetzner.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling hetzner sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "http_sd_configs":
-					sdConfig := []http.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling http sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "kubernetes_sd_configs":
-					sdConfig := []kubernetes.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling kubernetes sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				// case "lightsail_sd_configs":
-				// 	sdConfig := []lightsail.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error unmarshalling lightsail sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				case "linode_sd_configs":
-					sdConfig := []linode.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling linode sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "marathon_sd_configs":
-					sdConfig := []marathon.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling marathon sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				// case "nerve_sd_configs":
-				// 	sdConfig := []nerve.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error nerve azure sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				// case "serverset_sd_configs":
-				// 	sdConfig := []serverset.SDConfig{}
-				// 	err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-				// 	if err != nil {
-				// 		fmt.Printf("error serverset azure sd config: %s", err)
-				// 	}
-				// 	for index, _ := range sdConfig {
-				// 		discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-				// 	}
-				case "triton_sd_configs":
-					sdConfig := []triton.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling triton sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "eureka_sd_configs":
-					sdConfig := []eureka.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling eureka sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				case "scaleway_sd_configs":
-					sdConfig := []scaleway.SDConfig{}
-					err := yaml.UnmarshalStrict(sdYAML, &sdConfig)
-					if err != nil {
-						fmt.Printf("error unmarshalling scaleway sd config: %s", err)
-					}
-					for index := range sdConfig {
-						discoveryConfigs = append(discoveryConfigs, &sdConfig[index])
-					}
-				}
-			} else if name == "static_configs" {
-				staticYAML, _ := yaml.Marshal(sd)
-				staticConfig := discovery.StaticConfig{}
-				err := yaml.UnmarshalStrict(staticYAML, &staticConfig)
-				if err != nil {
-					fmt.Printf("error unmarshalling static config: %s", err)
-				}
-				discoveryConfigs = append(discoveryConfigs, staticConfig)
-			}
-		}
-		discoveryCfg[scrapeConfig["job_name"].(string)] = discoveryConfigs
-	}
-
-	if err := discoveryManager.ApplyConfig(discoveryCfg); err != nil {
+// Get applies the scrape jobs of the configuration, starts the discovery manager and waits for the first targets
+func Get(discoveryManager *discovery.Manager, cfg config.Config, logger log.Logger) ([]TargetData, error) {
+	if err := Apply(discoveryManager, cfg, logger); err != nil {
 		return nil, err
 	}
 
@@ -318,3 +177,57 @@ func Get(discoveryManager *discovery.Manager, cfg config.Config) ([]TargetData,
 
 	return targets, nil
 }
+
+// Apply replaces the scrape jobs of a running discovery manager; their targets are picked up by the next Watch
+func Apply(discoveryManager *discovery.Manager, cfg config.Config, logger log.Logger) error {
+	discoveryCfg := make(map[string]discovery.Configs)
+	providers := make(map[string][]string)
+	relabels := make(map[string][]*relabel.Config)
+
+	for _, scrapeConfig := range cfg.Config.ScrapeConfigs {
+		jobName := scrapeConfig.JobName
+		relabels[jobName] = scrapeConfig.RelabelConfigs
+		kinds := map[string]bool{}
+		for _, sd := range scrapeConfig.ServiceDiscoveryConfigs {
+			name := setName(jobName, providerName(sd))
+			discoveryCfg[name] = append(discoveryCfg[name], sd)
+			kinds[providerName(sd)] = true
+		}
+		// the discovery manager sends an empty group for a job without SD configs, which clears its targets
+		if len(kinds) == 0 {
+			discoveryCfg[setName(jobName, "static_configs")] = discovery.Configs{}
+		}
+		providers[jobName] = []string{}
+		for kind := range kinds {
+			providers[jobName] = append(providers[jobName], kind)
+		}
+		sort.Strings(providers[jobName])
+	}
+	recordProviders(providers, relabels)
+	level.Debug(logger).Log("msg", "discovery configs applied", "jobs", len(discoveryCfg))
+
+	return discoveryManager.ApplyConfig(discoveryCfg)
+}
+
+// setName is the name the SD configs of one provider of a job are registered under in the discovery manager,
+// so that the target groups it sends can be told apart by provider
+func setName(jobName, provider string) string {
+	return jobName + "/" + provider
+}
+
+// splitSetName returns the job and the provider of a set name; provider names have no slash, job names may
+func splitSetName(name string) (jobName, provider string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
+
+// providerName is the key of an SD config in a scrape job, e.g. file_sd_configs
+func providerName(sd discovery.Config) string {
+	if _, ok := sd.(discovery.StaticConfig); ok {
+		return "static_configs"
+	}
+	return sd.Name() + "_sd_configs"
+}
@@ -7,9 +7,13 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/go-kit/log"
 	"github.com/http-sd-loadbalancer/config"
 	"github.com/http-sd-loadbalancer/suite"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func copyFileHelper(src string, dst string) error {
@@ -46,10 +50,10 @@ func TestTargetDiscovery(t *testing.T) {
 	defaultConfigTestFile := suite.GetConfigTestFile()
 	cfg, err := config.Load(defaultConfigTestFile)
 	assert.NoError(t, err)
-	discoveryManager := NewManager(context.Background())
+	discoveryManager := NewManager(context.Background(), log.NewNopLogger())
 
 	t.Run("should discover targets", func(t *testing.T) {
-		targets, err := Get(discoveryManager, cfg)
+		targets, err := Get(discoveryManager, cfg, log.NewNopLogger())
 		assert.NoError(t, err)
 
 		actualTargets := []string{}
@@ -65,10 +69,18 @@ func TestTargetDiscovery(t *testing.T) {
 
 		assert.Equal(t, expectedTargets, actualTargets)
 
+		sync := LastSync()
+		assert.False(t, sync.Time.IsZero())
+		assert.Equal(t, []string{"file_sd_configs", "static_configs"}, sync.Providers["prometheus"])
+		assert.NotEmpty(t, sync.Groups["prometheus"]["file_sd_configs"])
+		assert.NotEmpty(t, sync.Groups["prometheus"]["static_configs"])
+		for _, group := range sync.Groups["prometheus"]["static_configs"] {
+			assert.NotContains(t, group.Targets, "promfile.domain:1001")
+		}
 	})
 
 	t.Run("should update targets", func(t *testing.T) {
-		targets, err := Get(discoveryManager, cfg)
+		targets, err := Get(discoveryManager, cfg, log.NewNopLogger())
 		assert.NoError(t, err)
 
 		actualTargets := []string{}
@@ -76,7 +88,7 @@ func TestTargetDiscovery(t *testing.T) {
 
 		copyFile(t, suite.GetFileSdTestInitialFile(), suite.GetFileSdTestModFile())
 
-		Watch(discoveryManager, &targets)
+		Watch(discoveryManager, &targets, log.NewNopLogger())
 
 		assert.Len(t, targets, 6)
 		for _, targets := range targets {
@@ -92,3 +104,41 @@ func TestTargetDiscovery(t *testing.T) {
 
 	})
 }
+
+func TestKeep(t *testing.T) {
+	// prepare
+	cfgs := []*relabel.Config{}
+	err := yaml.UnmarshalStrict([]byte(`
+- action: keep
+  source_labels: [__meta_kubernetes_endpoint_port_name]
+  regex: metrics
+- action: drop
+  source_labels: [__address__]
+  regex: .*:9999
+`), &cfgs)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		address  model.LabelValue
+		labels   model.LabelSet
+		expected bool
+	}{
+		{"should keep a target of the port", "10.0.0.1:8080", model.LabelSet{"__meta_kubernetes_endpoint_port_name": "metrics"}, true},
+		{"should drop a target of another port", "10.0.0.1:8081", model.LabelSet{"__meta_kubernetes_endpoint_port_name": "http"}, false},
+		{"should drop a target by its address", "10.0.0.1:9999", model.LabelSet{"__meta_kubernetes_endpoint_port_name": "metrics"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			kept := keep(tt.address, tt.labels, cfgs)
+
+			// verify
+			assert.Equal(t, tt.expected, kept)
+		})
+	}
+
+	t.Run("should keep every target without relabel configs", func(t *testing.T) {
+		assert.True(t, keep("10.0.0.1:8081", model.LabelSet{}, nil))
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"time"
+
+	"github.com/http-sd-loadbalancer/client"
+)
+
+// runDump implements the dump subcommand: it fetches /debug/state from a running load balancer and pretty-prints it
+func runDump(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:3030", "Address of the load balancer HTTP API.")
+	token := fs.String("token", "", "Bearer token of an admin identity.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time to wait for the state.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var opts []client.Option
+	if *token != "" {
+		opts = append(opts, client.WithBearerToken(*token))
+	}
+	c, err := client.New(*url, opts...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	state, err := c.DebugState(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, state, "", "  "); err != nil {
+		return err
+	}
+	pretty.WriteByte('\n')
+	_, err = pretty.WriteTo(out)
+	return err
+}
@@ -0,0 +1,55 @@
+// Package election elects a single leader among the load balancer replicas
+// The leader allocates targets; the other replicas follow it
+package election
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoIdentity represents an elector configured without an identity for this replica.
+	ErrNoIdentity = errors.New("election identity is required")
+	// ErrFileLockUnsupported represents a file lock elector on a platform without flock.
+	ErrFileLockUnsupported = errors.New("the file election backend is not supported on this platform")
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// Callbacks are invoked as the leadership changes; any of them may be nil
+type Callbacks struct {
+	// OnStartedLeading is called when this replica becomes the leader, ctx is cancelled once it stops leading
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called when this replica loses the leadership or stops campaigning
+	OnStoppedLeading func()
+	// OnNewLeader is called whenever another identity, or this one, is observed as the leader
+	OnNewLeader func(identity string)
+}
+
+// Elector campaigns for the leadership until ctx is done
+type Elector interface {
+	Run(ctx context.Context, callbacks Callbacks) error
+}
+
+func (c Callbacks) startedLeading(ctx context.Context) {
+	if c.OnStartedLeading != nil {
+		c.OnStartedLeading(ctx)
+	}
+}
+
+func (c Callbacks) stoppedLeading() {
+	if c.OnStoppedLeading != nil {
+		c.OnStoppedLeading()
+	}
+}
+
+func (c Callbacks) newLeader(identity string) {
+	if c.OnNewLeader != nil {
+		c.OnNewLeader(identity)
+	}
+}
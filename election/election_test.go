@@ -0,0 +1,101 @@
+package election
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recorder keeps what the callbacks reported
+type recorder struct {
+	mu      sync.Mutex
+	leading bool
+	leader  string
+}
+
+func (r *recorder) callbacks() Callbacks {
+	return Callbacks{
+		OnStartedLeading: func(ctx context.Context) { r.set(func() { r.leading = true }) },
+		OnStoppedLeading: func() { r.set(func() { r.leading = false }) },
+		OnNewLeader:      func(identity string) { r.set(func() { r.leader = identity }) },
+	}
+}
+
+func (r *recorder) set(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f()
+}
+
+func (r *recorder) state() (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leading, r.leader
+}
+
+func TestFileLock(t *testing.T) {
+	// prepare
+	path := filepath.Join(t.TempDir(), "leader")
+	first, second := &recorder{}, &recorder{}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	// test
+	go FileLock{Path: path, Identity: "http://replica-1:3030", RetryPeriod: 10 * time.Millisecond}.Run(ctx1, first.callbacks())
+	assert.Eventually(t, func() bool { leading, _ := first.state(); return leading }, time.Second, 10*time.Millisecond)
+	go FileLock{Path: path, Identity: "http://replica-2:3030", RetryPeriod: 10 * time.Millisecond}.Run(ctx2, second.callbacks())
+
+	// verify
+	assert.Eventually(t, func() bool { _, leader := second.state(); return leader == "http://replica-1:3030" }, time.Second, 10*time.Millisecond)
+	leading, _ := second.state()
+	assert.False(t, leading)
+
+	t.Run("should hand over once the leader stops", func(t *testing.T) {
+		cancel1()
+
+		assert.Eventually(t, func() bool { leading, _ := second.state(); return leading }, time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool { leading, _ := first.state(); return !leading }, time.Second, 10*time.Millisecond)
+		_, leader := second.state()
+		assert.Equal(t, "http://replica-2:3030", leader)
+	})
+}
+
+func TestLease(t *testing.T) {
+	// prepare
+	client := fake.NewSimpleClientset()
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lease := Lease{
+		Client:        client,
+		Namespace:     "monitoring",
+		Name:          "http-sd-loadbalancer",
+		Identity:      "http://replica-1:3030",
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+
+	// test
+	go lease.Run(ctx, r.callbacks())
+
+	// verify
+	assert.Eventually(t, func() bool { leading, _ := r.state(); return leading }, 5*time.Second, 10*time.Millisecond)
+	_, leader := r.state()
+	assert.Equal(t, "http://replica-1:3030", leader)
+	held, err := client.CoordinationV1().Leases("monitoring").Get(context.Background(), "http-sd-loadbalancer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://replica-1:3030", *held.Spec.HolderIdentity)
+}
+
+func TestMissingIdentity(t *testing.T) {
+	assert.ErrorIs(t, FileLock{Path: "leader"}.Run(context.Background(), Callbacks{}), ErrNoIdentity)
+	assert.ErrorIs(t, Lease{}.Run(context.Background(), Callbacks{}), ErrNoIdentity)
+}
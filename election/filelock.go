@@ -0,0 +1,93 @@
+//go:build !windows
+// +build !windows
+
+package election
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileLock elects the leader through an exclusive flock on a file shared by the replicas, for local setups and tests
+// The leader writes its identity to the file so the followers know who leads
+type FileLock struct {
+	Path     string
+	Identity string
+	// RetryPeriod defaults to 2s
+	RetryPeriod time.Duration
+}
+
+func (f FileLock) Run(ctx context.Context, callbacks Callbacks) error {
+	if f.Identity == "" {
+		return ErrNoIdentity
+	}
+	retry := time.NewTicker(orDefault(f.RetryPeriod, defaultRetryPeriod))
+	defer retry.Stop()
+	leader := ""
+	for {
+		file, err := f.tryLock()
+		switch {
+		case err == nil:
+			defer file.Close()
+			return f.lead(ctx, file, callbacks)
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			if current := f.leader(); current != "" && current != leader {
+				leader = current
+				callbacks.newLeader(leader)
+			}
+		default:
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-retry.C:
+		}
+	}
+}
+
+// tryLock opens the file and takes the lock without blocking
+func (f FileLock) tryLock() (*os.File, error) {
+	file, err := os.OpenFile(f.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// lead publishes the identity and holds the lock until ctx is done
+func (f FileLock) lead(ctx context.Context, file *os.File, callbacks Callbacks) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt([]byte(f.Identity), 0); err != nil {
+		return err
+	}
+	callbacks.newLeader(f.Identity)
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go callbacks.startedLeading(leaderCtx)
+
+	<-ctx.Done()
+	file.Truncate(0)
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	callbacks.stoppedLeading()
+	return nil
+}
+
+func (f FileLock) leader() string {
+	identity, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(identity))
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package election
+
+import (
+	"context"
+	"time"
+)
+
+// FileLock elects the leader through an exclusive flock on a file shared by the replicas, which Windows lacks
+type FileLock struct {
+	Path     string
+	Identity string
+	// RetryPeriod defaults to 2s
+	RetryPeriod time.Duration
+}
+
+func (f FileLock) Run(ctx context.Context, callbacks Callbacks) error {
+	return ErrFileLockUnsupported
+}
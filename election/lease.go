@@ -0,0 +1,62 @@
+package election
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Lease elects the leader through a Kubernetes coordination.k8s.io Lease
+type Lease struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Identity  string
+	// LeaseDuration, RenewDeadline and RetryPeriod default to 15s, 10s and 2s
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func (l Lease) Run(ctx context.Context, callbacks Callbacks) error {
+	if l.Identity == "" {
+		return ErrNoIdentity
+	}
+	cfg := leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: l.Namespace, Name: l.Name},
+			Client:     l.Client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: l.Identity},
+		},
+		LeaseDuration:   orDefault(l.LeaseDuration, defaultLeaseDuration),
+		RenewDeadline:   orDefault(l.RenewDeadline, defaultRenewDeadline),
+		RetryPeriod:     orDefault(l.RetryPeriod, defaultRetryPeriod),
+		ReleaseOnCancel: true,
+		Name:            l.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: callbacks.startedLeading,
+			OnStoppedLeading: callbacks.stoppedLeading,
+			OnNewLeader:      callbacks.newLeader,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(cfg)
+	if err != nil {
+		return err
+	}
+	// Run returns whenever the leadership is lost, campaign again until ctx is done
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+)
+
+// keepAliveInterval is how often a comment is sent on an idle event stream so proxies keep it open
+const keepAliveInterval = 15 * time.Second
+
+// eventsHandler streams allocation changes as Server-Sent Events
+// Clients may filter with ?collector= and ?job= and resume with the Last-Event-ID header (or ?last_event_id=)
+// When events were evicted before the client could resume, a resync event is sent first
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	collectorFilter, jobFilter := q.Get("collector"), q.Get("job")
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = q.Get("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			http.Error(w, "last event id must be an unsigned integer", http.StatusBadRequest)
+			return
+		}
+	} else {
+		lastID = lb.Events.LastID()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		events, complete, next := lb.Events.Since(lastID)
+		if !complete {
+			fmt.Fprintf(w, "event: resync\ndata: {}\n\n")
+			if len(events) == 0 {
+				lastID = 0
+			}
+		}
+		for _, e := range events {
+			lastID = e.ID
+			if collectorFilter != "" && !e.Collector(collectorFilter) {
+				continue
+			}
+			if jobFilter != "" && e.JobName != jobFilter {
+				continue
+			}
+			writeEvent(w, e)
+		}
+		flusher.Flush()
+
+		select {
+		case <-next:
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e loadbalancer.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,119 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/http-sd-loadbalancer/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// WithAuth returns the server options that authenticate every call like the HTTP API and only let collectors
+// read their own assignment; calls that don't name a collector are scoped to the caller
+// The authenticator is looked up on every call like the load balancer of Server, a nil authenticator leaves
+// the API open
+func WithAuth(authenticator func() auth.Authenticator) []grpc.ServerOption {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		identity, err := authenticate(ctx, authenticator())
+		if err != nil {
+			return nil, err
+		}
+		if identity == nil {
+			return handler(ctx, req)
+		}
+		if !authorized(*identity, req) {
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		return handler(auth.NewContext(ctx, *identity), req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		identity, err := authenticate(ss.Context(), authenticator())
+		if err != nil {
+			return err
+		}
+		if identity == nil {
+			return handler(srv, ss)
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: auth.NewContext(ss.Context(), *identity), identity: *identity})
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary), grpc.ChainStreamInterceptor(stream)}
+}
+
+// authenticate resolves the identity of a call from its authorization metadata and its verified client
+// certificate, or returns nil without an authenticator
+func authenticate(ctx context.Context, authenticator auth.Authenticator) (*auth.Identity, error) {
+	if authenticator == nil {
+		return nil, nil
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("authorization") {
+			r.Header.Add("Authorization", value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	identity, err := authenticator.Authenticate(r)
+	switch {
+	case errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrUnauthenticated):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &identity, nil
+}
+
+// authorized reports whether the identity may make the call, the rules are those of the matching HTTP routes
+func authorized(identity auth.Identity, req interface{}) bool {
+	if identity.Admin {
+		return true
+	}
+	switch req := req.(type) {
+	case *ListJobsRequest:
+		return true
+	case *GetTargetsRequest:
+		if req.Collector == "" {
+			req.Collector = identity.Name
+		}
+		return req.Collector == identity.Name
+	case *WatchAssignmentsRequest:
+		if req.Collector == "" {
+			req.Collector = identity.Name
+		}
+		return req.Collector == identity.Name
+	}
+	return false
+}
+
+// authorizedStream authorizes the request of a streaming call once it is received
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	identity auth.Identity
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !authorized(s.identity, m) {
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return nil
+}
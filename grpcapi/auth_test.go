@@ -0,0 +1,99 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/http-sd-loadbalancer/auth"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/http-sd-loadbalancer/mode/modetest"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorization(t *testing.T) {
+	// prepare
+	lb := modetest.New([]string{"col-1", "col-2"},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	authenticator := auth.WithAdmins(auth.StaticTokens{"col-1-token": "col-1", "admin-token": "admin"}, []string{"admin"})
+	client := serve(t, func() *loadbalancer.LoadBalancer { return lb }, WithAuth(func() auth.Authenticator { return authenticator })...)
+	withToken := func(token string) context.Context {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return ctx
+	}
+	watch := func(token, collector string) error {
+		ctx, cancel := context.WithTimeout(withToken(token), 5*time.Second)
+		defer cancel()
+		stream, err := client.WatchAssignments(ctx, &WatchAssignmentsRequest{Collector: collector})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		expected codes.Code
+	}{
+		{"anonymous", func() error {
+			_, err := client.ListJobs(withToken(""), &ListJobsRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"invalid token", func() error {
+			_, err := client.ListJobs(withToken("wrong"), &ListJobsRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"collector lists jobs", func() error {
+			_, err := client.ListJobs(withToken("col-1-token"), &ListJobsRequest{})
+			return err
+		}, codes.OK},
+		{"collector reads its targets", func() error {
+			_, err := client.GetTargets(withToken("col-1-token"), &GetTargetsRequest{Job: "job-a", Collector: "col-1"})
+			return err
+		}, codes.OK},
+		{"collector reads other targets", func() error {
+			_, err := client.GetTargets(withToken("col-1-token"), &GetTargetsRequest{Job: "job-a", Collector: "col-2"})
+			return err
+		}, codes.PermissionDenied},
+		{"collector lists collectors", func() error {
+			_, err := client.ListCollectors(withToken("col-1-token"), &ListCollectorsRequest{})
+			return err
+		}, codes.PermissionDenied},
+		{"collector watches its assignment", func() error { return watch("col-1-token", "col-1") }, codes.OK},
+		{"collector watches other assignment", func() error { return watch("col-1-token", "col-2") }, codes.PermissionDenied},
+		{"anonymous watches an assignment", func() error { return watch("", "col-1") }, codes.Unauthenticated},
+		{"admin reads every target", func() error {
+			_, err := client.GetTargets(withToken("admin-token"), &GetTargetsRequest{Job: "job-a"})
+			return err
+		}, codes.OK},
+		{"admin lists collectors", func() error {
+			_, err := client.ListCollectors(withToken("admin-token"), &ListCollectorsRequest{})
+			return err
+		}, codes.OK},
+		{"admin watches other assignment", func() error { return watch("admin-token", "col-2") }, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, status.Code(tt.call()))
+		})
+	}
+
+	t.Run("collector reads its targets implicitly", func(t *testing.T) {
+		resp, err := client.GetTargets(withToken("col-1-token"), &GetTargetsRequest{Job: "job-a"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.TargetGroups, 1)
+		assert.Equal(t, []string{"targ:1000"}, resp.TargetGroups[0].Targets)
+	})
+}
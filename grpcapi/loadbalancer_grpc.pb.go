@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// LoadBalancerClient is the client API for LoadBalancer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LoadBalancerClient interface {
+	// ListJobs returns every job that has targets.
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+	// GetTargets returns the target groups of a job, for a single collector when one is given.
+	GetTargets(ctx context.Context, in *GetTargetsRequest, opts ...grpc.CallOption) (*GetTargetsResponse, error)
+	// ListCollectors returns the assignment summary of every collector.
+	ListCollectors(ctx context.Context, in *ListCollectorsRequest, opts ...grpc.CallOption) (*ListCollectorsResponse, error)
+	// WatchAssignments sends the current targets of a collector, then a delta every time they change.
+	// The stream ends with UNAVAILABLE when the configuration is reloaded; watch again to receive a new snapshot.
+	WatchAssignments(ctx context.Context, in *WatchAssignmentsRequest, opts ...grpc.CallOption) (LoadBalancer_WatchAssignmentsClient, error)
+}
+
+type loadBalancerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLoadBalancerClient(cc grpc.ClientConnInterface) LoadBalancerClient {
+	return &loadBalancerClient{cc}
+}
+
+func (c *loadBalancerClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, "/loadbalancer.v1.LoadBalancer/ListJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loadBalancerClient) GetTargets(ctx context.Context, in *GetTargetsRequest, opts ...grpc.CallOption) (*GetTargetsResponse, error) {
+	out := new(GetTargetsResponse)
+	err := c.cc.Invoke(ctx, "/loadbalancer.v1.LoadBalancer/GetTargets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loadBalancerClient) ListCollectors(ctx context.Context, in *ListCollectorsRequest, opts ...grpc.CallOption) (*ListCollectorsResponse, error) {
+	out := new(ListCollectorsResponse)
+	err := c.cc.Invoke(ctx, "/loadbalancer.v1.LoadBalancer/ListCollectors", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loadBalancerClient) WatchAssignments(ctx context.Context, in *WatchAssignmentsRequest, opts ...grpc.CallOption) (LoadBalancer_WatchAssignmentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LoadBalancer_ServiceDesc.Streams[0], "/loadbalancer.v1.LoadBalancer/WatchAssignments", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &loadBalancerWatchAssignmentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LoadBalancer_WatchAssignmentsClient interface {
+	Recv() (*AssignmentDelta, error)
+	grpc.ClientStream
+}
+
+type loadBalancerWatchAssignmentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *loadBalancerWatchAssignmentsClient) Recv() (*AssignmentDelta, error) {
+	m := new(AssignmentDelta)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadBalancerServer is the server API for LoadBalancer service.
+// All implementations must embed UnimplementedLoadBalancerServer
+// for forward compatibility
+type LoadBalancerServer interface {
+	// ListJobs returns every job that has targets.
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	// GetTargets returns the target groups of a job, for a single collector when one is given.
+	GetTargets(context.Context, *GetTargetsRequest) (*GetTargetsResponse, error)
+	// ListCollectors returns the assignment summary of every collector.
+	ListCollectors(context.Context, *ListCollectorsRequest) (*ListCollectorsResponse, error)
+	// WatchAssignments sends the current targets of a collector, then a delta every time they change.
+	// The stream ends with UNAVAILABLE when the configuration is reloaded; watch again to receive a new snapshot.
+	WatchAssignments(*WatchAssignmentsRequest, LoadBalancer_WatchAssignmentsServer) error
+	mustEmbedUnimplementedLoadBalancerServer()
+}
+
+// UnimplementedLoadBalancerServer must be embedded to have forward compatible implementations.
+type UnimplementedLoadBalancerServer struct {
+}
+
+func (UnimplementedLoadBalancerServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedLoadBalancerServer) GetTargets(context.Context, *GetTargetsRequest) (*GetTargetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTargets not implemented")
+}
+func (UnimplementedLoadBalancerServer) ListCollectors(context.Context, *ListCollectorsRequest) (*ListCollectorsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCollectors not implemented")
+}
+func (UnimplementedLoadBalancerServer) WatchAssignments(*WatchAssignmentsRequest, LoadBalancer_WatchAssignmentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAssignments not implemented")
+}
+func (UnimplementedLoadBalancerServer) mustEmbedUnimplementedLoadBalancerServer() {}
+
+// UnsafeLoadBalancerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LoadBalancerServer will
+// result in compilation errors.
+type UnsafeLoadBalancerServer interface {
+	mustEmbedUnimplementedLoadBalancerServer()
+}
+
+func RegisterLoadBalancerServer(s grpc.ServiceRegistrar, srv LoadBalancerServer) {
+	s.RegisterService(&LoadBalancer_ServiceDesc, srv)
+}
+
+func _LoadBalancer_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoadBalancerServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/loadbalancer.v1.LoadBalancer/ListJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoadBalancerServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoadBalancer_GetTargets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTargetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoadBalancerServer).GetTargets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/loadbalancer.v1.LoadBalancer/GetTargets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoadBalancerServer).GetTargets(ctx, req.(*GetTargetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoadBalancer_ListCollectors_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCollectorsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoadBalancerServer).ListCollectors(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/loadbalancer.v1.LoadBalancer/ListCollectors",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoadBalancerServer).ListCollectors(ctx, req.(*ListCollectorsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoadBalancer_WatchAssignments_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchAssignmentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LoadBalancerServer).WatchAssignments(m, &loadBalancerWatchAssignmentsServer{stream})
+}
+
+type LoadBalancer_WatchAssignmentsServer interface {
+	Send(*AssignmentDelta) error
+	grpc.ServerStream
+}
+
+type loadBalancerWatchAssignmentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *loadBalancerWatchAssignmentsServer) Send(m *AssignmentDelta) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// LoadBalancer_ServiceDesc is the grpc.ServiceDesc for LoadBalancer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LoadBalancer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "loadbalancer.v1.LoadBalancer",
+	HandlerType: (*LoadBalancerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListJobs",
+			Handler:    _LoadBalancer_ListJobs_Handler,
+		},
+		{
+			MethodName: "GetTargets",
+			Handler:    _LoadBalancer_GetTargets_Handler,
+		},
+		{
+			MethodName: "ListCollectors",
+			Handler:    _LoadBalancer_ListCollectors_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchAssignments",
+			Handler:       _LoadBalancer_WatchAssignments_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "loadbalancer.proto",
+}
@@ -0,0 +1,158 @@
+package grpcapi
+
+//go:generate buf generate --template buf.gen.yaml loadbalancer.proto
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the LoadBalancer gRPC service on top of the current load balancer
+// The load balancer is looked up on every call since it is replaced when the configuration is reloaded
+type Server struct {
+	UnimplementedLoadBalancerServer
+	lb func() *loadbalancer.LoadBalancer
+}
+
+func NewServer(lb func() *loadbalancer.LoadBalancer) *Server {
+	return &Server{lb: lb}
+}
+
+// reloadCheckInterval is how often a watch checks whether the load balancer was replaced by a configuration reload
+var reloadCheckInterval = 5 * time.Second
+
+// Register creates a gRPC server serving the LoadBalancer service
+func Register(lb func() *loadbalancer.LoadBalancer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	RegisterLoadBalancerServer(s, NewServer(lb))
+	return s
+}
+
+func (s *Server) ListJobs(ctx context.Context, req *ListJobsRequest) (*ListJobsResponse, error) {
+	lb := s.lb()
+	lb.RLock()
+	defer lb.RUnlock()
+	resp := &ListJobsResponse{}
+	for name, link := range lb.Cache.DisplayJobMapping {
+		resp.Jobs = append(resp.Jobs, &Job{Name: name, Link: link.Link})
+	}
+	sort.Slice(resp.Jobs, func(i, j int) bool { return resp.Jobs[i].Name < resp.Jobs[j].Name })
+	return resp, nil
+}
+
+func (s *Server) GetTargets(ctx context.Context, req *GetTargetsRequest) (*GetTargetsResponse, error) {
+	if req.Job == "" {
+		return nil, status.Error(codes.InvalidArgument, "job is required")
+	}
+	lb := s.lb()
+	lb.RLock()
+	defer lb.RUnlock()
+	resp := &GetTargetsResponse{Index: lb.Cache.Index(req.Job, req.Collector)}
+	if req.Collector != "" {
+		if _, ok := lb.CollectorMap[req.Collector]; !ok {
+			return nil, status.Error(codes.NotFound, loadbalancer.ErrCollectorNotFound.Error())
+		}
+		resp.TargetGroups = toTargetGroups(lb.Cache.DisplayTargetMapping[req.Job+req.Collector])
+		return resp, nil
+	}
+	collectors := make([]string, 0, len(lb.Cache.DisplayJobs[req.Job]))
+	for name := range lb.Cache.DisplayJobs[req.Job] {
+		collectors = append(collectors, name)
+	}
+	sort.Strings(collectors)
+	for _, name := range collectors {
+		resp.TargetGroups = append(resp.TargetGroups, toTargetGroups(lb.Cache.DisplayJobs[req.Job][name])...)
+	}
+	return resp, nil
+}
+
+func (s *Server) ListCollectors(ctx context.Context, req *ListCollectorsRequest) (*ListCollectorsResponse, error) {
+	resp := &ListCollectorsResponse{}
+	for _, c := range s.lb().CollectorStatuses() {
+		col := &Collector{Name: c.Name, Targets: int64(c.NumTargets), Jobs: make(map[string]int64), State: c.State.String()}
+		for job, n := range c.Jobs {
+			col.Jobs[job] = int64(n)
+		}
+		if c.LastSeen != nil {
+			col.LastSeen = timestamppb.New(*c.LastSeen)
+		}
+		resp.Collectors = append(resp.Collectors, col)
+	}
+	return resp, nil
+}
+
+// WatchAssignments sends a snapshot of the collector's targets, then a delta whenever they change
+// The watch ends with Unavailable when the load balancer is replaced, the client watches again for a new snapshot
+func (s *Server) WatchAssignments(req *WatchAssignmentsRequest, stream LoadBalancer_WatchAssignmentsServer) error {
+	lb := s.lb()
+	changed := lb.Changed()
+	current, generation, err := lb.Assignments(req.Collector)
+	if errors.Is(err, loadbalancer.ErrCollectorNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if err := stream.Send(&AssignmentDelta{Generation: generation, Snapshot: true, Added: toTargets(current)}); err != nil {
+		return err
+	}
+
+	reload := time.NewTicker(reloadCheckInterval)
+	defer reload.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-reload.C:
+			if s.lb() != lb {
+				return status.Error(codes.Unavailable, "the load balancer was reloaded")
+			}
+			continue
+		case <-stream.Context().Done():
+			return nil
+		}
+		changed = lb.Changed()
+		next, generation, err := lb.Assignments(req.Collector)
+		if errors.Is(err, loadbalancer.ErrCollectorNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		delta := loadbalancer.NewDelta(current, next, generation)
+		current = next
+		if delta.Empty() {
+			continue
+		}
+		if err := stream.Send(&AssignmentDelta{Generation: delta.Generation, Added: toTargets(delta.Added), Removed: toTargets(delta.Removed)}); err != nil {
+			return err
+		}
+	}
+}
+
+func toTargetGroups(tgs []lbdiscovery.TargetGroup) []*TargetGroup {
+	groups := make([]*TargetGroup, 0, len(tgs))
+	for _, tg := range tgs {
+		groups = append(groups, &TargetGroup{Targets: tg.Targets, Labels: toLabels(tg.Labels)})
+	}
+	return groups
+}
+
+func toTargets(targets []lbdiscovery.TargetData) []*Target {
+	result := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		result = append(result, &Target{Job: t.JobName, Target: t.Target, Labels: toLabels(t.Labels)})
+	}
+	return result
+}
+
+func toLabels(ls model.LabelSet) map[string]string {
+	labels := make(map[string]string, len(ls))
+	for k, v := range ls {
+		labels[string(k)] = string(v)
+	}
+	return labels
+}
@@ -0,0 +1,168 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/http-sd-loadbalancer/mode/modetest"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startServer(t *testing.T, lb *loadbalancer.LoadBalancer) LoadBalancerClient {
+	t.Helper()
+	return serve(t, func() *loadbalancer.LoadBalancer { return lb })
+}
+
+func serve(t *testing.T, lb func() *loadbalancer.LoadBalancer, opts ...grpc.ServerOption) LoadBalancerClient {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	s := Register(lb, opts...)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return listener.Dial() }
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewLoadBalancerClient(conn)
+}
+
+func TestUnaryCalls(t *testing.T) {
+	// prepare
+	lb := modetest.New([]string{"col-1", "col-2"},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{"foo": "bar"}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{"foo": "bar"}},
+		lbdiscovery.TargetData{JobName: "job-b", Target: "targ:2000", Labels: model.LabelSet{}},
+	)
+	client := startServer(t, lb)
+	ctx := context.Background()
+
+	t.Run("should list jobs", func(t *testing.T) {
+		resp, err := client.ListJobs(ctx, &ListJobsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Jobs, 2)
+		assert.Equal(t, "job-a", resp.Jobs[0].Name)
+		assert.Equal(t, "/jobs/job-a/targets", resp.Jobs[0].Link)
+	})
+
+	t.Run("should get targets of a collector", func(t *testing.T) {
+		resp, err := client.GetTargets(ctx, &GetTargetsRequest{Job: "job-a", Collector: "col-1"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.TargetGroups, 1)
+		assert.Equal(t, []string{"targ:1000"}, resp.TargetGroups[0].Targets)
+		assert.Equal(t, map[string]string{"foo": "bar"}, resp.TargetGroups[0].Labels)
+		assert.Equal(t, lb.Cache.Index("job-a", "col-1"), resp.Index)
+	})
+
+	t.Run("should get targets of every collector", func(t *testing.T) {
+		resp, err := client.GetTargets(ctx, &GetTargetsRequest{Job: "job-a"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.TargetGroups, 2)
+	})
+
+	t.Run("should reject unknown collectors", func(t *testing.T) {
+		_, err := client.GetTargets(ctx, &GetTargetsRequest{Job: "job-a", Collector: "missing"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = client.GetTargets(ctx, &GetTargetsRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("should list collectors", func(t *testing.T) {
+		resp, err := client.ListCollectors(ctx, &ListCollectorsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Collectors, 2)
+		assert.Equal(t, "col-1", resp.Collectors[0].Name)
+		assert.Equal(t, int64(2), resp.Collectors[0].Targets)
+		assert.Equal(t, map[string]int64{"job-a": 1, "job-b": 1}, resp.Collectors[0].Jobs)
+		assert.Equal(t, "active", resp.Collectors[0].State)
+	})
+}
+
+func TestWatchAssignments(t *testing.T) {
+	// prepare
+	lb := modetest.New([]string{"col-1", "col-2"},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	client := startServer(t, lb)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// test
+	stream, err := client.WatchAssignments(ctx, &WatchAssignmentsRequest{Collector: "col-1"})
+	assert.NoError(t, err)
+	snapshot, err := stream.Recv()
+
+	// verify
+	assert.NoError(t, err)
+	assert.True(t, snapshot.Snapshot)
+	assert.Len(t, snapshot.Added, 1)
+	assert.Equal(t, "targ:1000", snapshot.Added[0].Target)
+
+	// test that draining the other collector pushes its target as an addition
+	_, err = lb.Drain("col-2")
+	assert.NoError(t, err)
+	delta, err := stream.Recv()
+
+	// verify
+	assert.NoError(t, err)
+	assert.False(t, delta.Snapshot)
+	assert.Greater(t, delta.Generation, snapshot.Generation)
+	assert.Len(t, delta.Added, 1)
+	assert.Equal(t, "targ:1001", delta.Added[0].Target)
+	assert.Empty(t, delta.Removed)
+
+	// test that removed targets are pushed as removals
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}}})
+	lb.RefreshJobs()
+	delta, err = stream.Recv()
+
+	// verify
+	assert.NoError(t, err)
+	assert.Empty(t, delta.Added)
+	assert.Len(t, delta.Removed, 1)
+	assert.Equal(t, "targ:1000", delta.Removed[0].Target)
+}
+
+func TestWatchUnknownCollector(t *testing.T) {
+	client := startServer(t, modetest.New([]string{"col-1", "col-2"}))
+
+	stream, err := client.WatchAssignments(context.Background(), &WatchAssignmentsRequest{Collector: "missing"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// Tests that a watch ends once the load balancer is replaced, so that the client does not wait on the old one
+func TestWatchReload(t *testing.T) {
+	// prepare
+	defer func(interval time.Duration) { reloadCheckInterval = interval }(reloadCheckInterval)
+	reloadCheckInterval = 10 * time.Millisecond
+	var current atomic.Value
+	current.Store(modetest.New([]string{"col-1", "col-2"}, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}}))
+	client := serve(t, func() *loadbalancer.LoadBalancer { return current.Load().(*loadbalancer.LoadBalancer) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.WatchAssignments(ctx, &WatchAssignmentsRequest{Collector: "col-1"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+
+	// test
+	current.Store(modetest.New([]string{"col-1", "col-2"}))
+	_, err = stream.Recv()
+
+	// verify
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
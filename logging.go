@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/promlog"
+)
+
+var (
+	// logger is replaced by the one configured with the log.* flags once they are parsed
+	logger log.Logger = log.NewNopLogger()
+
+	logLevel  promlog.AllowedLevel
+	logFormat promlog.AllowedFormat
+)
+
+func init() {
+	logLevel.Set("info")
+	logFormat.Set("logfmt")
+	flag.Var(&logLevel, "log.level", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
+	flag.Var(&logFormat, "log.format", "Output format of log messages. One of: [logfmt, json]")
+}
+
+func newLogger() log.Logger {
+	return promlog.New(&promlog.Config{Level: &logLevel, Format: &logFormat})
+}
@@ -3,140 +3,526 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-co-op/gocron"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	v1 "github.com/http-sd-loadbalancer/api/v1"
+	"github.com/http-sd-loadbalancer/auth"
 	"github.com/http-sd-loadbalancer/collector"
 	"github.com/http-sd-loadbalancer/config"
 	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/http-sd-loadbalancer/grpcapi"
 	loadbalancer "github.com/http-sd-loadbalancer/mode"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/prometheus/discovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
-	lb     *loadbalancer.LoadBalancer
-	server *http.Server
+	lb       *loadbalancer.LoadBalancer
+	lbConfig config.Config
+	// lbConfigLoaded is when lbConfig was last loaded
+	lbConfigLoaded time.Time
+	server         *http.Server
+	grpcOnce       sync.Once
+
+	configFragments       = flag.String("config.fragments", "", "Glob of files whose scrape_configs are merged into the configuration, e.g. ./conf.d/*.yaml. Reloaded when a matching file changes.")
+	listenAddress         = flag.String("web.listen-address", ":3030", "Address to serve the HTTP API on.")
+	grpcListenAddress     = flag.String("grpc.listen-address", "", "Address to serve the gRPC API on, e.g. :3031. It is authenticated and served over TLS like the HTTP API. Empty disables it.")
+	tlsCertFile           = flag.String("web.tls-cert-file", "", "Certificate to serve the HTTP API over TLS with. Reloaded when it changes.")
+	tlsKeyFile            = flag.String("web.tls-key-file", "", "Private key of the TLS certificate.")
+	tlsClientCAFile       = flag.String("web.tls-client-ca-file", "", "CA bundle to verify client certificates against. The certificate CN identifies the collector.")
+	tlsRequireClient      = flag.Bool("web.tls-require-client-cert", false, "Reject TLS clients without a verified certificate.")
+	maxInFlight           = flag.Int("web.max-in-flight", 0, "Maximum number of HTTP requests served at once, event streams and blocking queries waiting for a change excluded. 0 disables the limit.")
+	rateLimit             = flag.Float64("web.rate-limit", 0, "Requests per second allowed per client, and per address for requests that fail authentication. 0 disables the limit.")
+	rateLimitBurst        = flag.Int("web.rate-limit-burst", 0, "Requests a client may send at once above the rate limit. Defaults to the rate limit.")
+	electionBackend       = flag.String("election.backend", "", "Leader election backend when running several replicas, lease or file. Empty disables leader election.")
+	electionIdentity      = flag.String("election.identity", "", "URL followers proxy requests to while this replica leads. Defaults to the hostname and the listen port.")
+	electionLeaseName     = flag.String("election.lease-name", "http-sd-loadbalancer", "Name of the Kubernetes Lease of the lease backend.")
+	electionNamespace     = flag.String("election.lease-namespace", "default", "Namespace of the Kubernetes Lease of the lease backend.")
+	electionLockFile      = flag.String("election.lock-file", "http-sd-loadbalancer.lock", "File shared by the replicas with the file backend.")
+	electionSyncTokenFile = flag.String("election.sync-token-file", "", "Bearer token of an admin followers present to the leader to replicate its assignment and to proxy the requests they authorized, when the API is authenticated. Reloaded when it changes.")
+	electionTLSCAFile     = flag.String("election.tls-ca-file", "", "CA bundle followers verify the certificate of the leader against. Defaults to the system roots. Followers present the certificate of web.tls-cert-file to leaders that ask for a client certificate.")
+
+	tlsCerts *tlsReloader
+	// configWatcher reloads the configuration when it, its fragments, its Prometheus configuration or its secret files change
+	configWatcher *fsnotify.Watcher
+	// refresh refreshes the targets and collectors of the current configuration
+	refresh *gocron.Scheduler
+	// stopRefresh stops the discovery of the previous configuration
+	stopRefresh context.CancelFunc
 )
 
+// configDir holds the loadbalancer configuration, any write in it triggers a reload
+const configDir = "./conf"
+
+// shutdownTimeout is how long a reload or exit waits for the HTTP requests in flight
+const shutdownTimeout = 30 * time.Second
+
 func router() *mux.Router {
 	router := mux.NewRouter()
 	router.HandleFunc("/jobs", jobHandler).Methods("GET")
 	router.HandleFunc("/jobs/{job_id}/targets", targetHandler).Methods("GET")
+	router.HandleFunc("/collectors", collectorsHandler).Methods("GET")
+	router.HandleFunc("/collectors/{name}", collectorHandler).Methods("GET")
+	router.HandleFunc("/collectors/{name}/targets", collectorTargetsHandler).Methods("GET")
+	router.HandleFunc("/events", eventsHandler).Methods("GET")
+	router.HandleFunc("/admin/collectors/{name}/cordon", cordonHandler).Methods("POST")
+	router.HandleFunc("/admin/collectors/{name}/uncordon", uncordonHandler).Methods("POST")
+	router.HandleFunc("/admin/collectors/{name}/drain", drainHandler).Methods("POST")
+	router.HandleFunc("/admin/pins", pinsHandler).Methods("GET")
+	router.HandleFunc("/admin/pins", addPinHandler).Methods("POST")
+	router.HandleFunc("/admin/pins", removePinHandler).Methods("DELETE")
+	router.HandleFunc(v1.Prefix+"/jobs", v1JobsHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/jobs/{job_id}/targets", v1JobTargetsHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/collectors", v1CollectorsHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/collectors/{name}", v1CollectorHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/collectors/{name}/targets", v1CollectorTargetsHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/collectors/{name}/otel-config", otelConfigHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/status", v1StatusHandler).Methods("GET")
+	router.HandleFunc(v1.Prefix+"/config", v1ConfigHandler).Methods("GET")
+	router.HandleFunc("/debug/state", debugStateHandler).Methods("GET")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	router.HandleFunc("/internal/sync", syncHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	authLimiters = newClientLimiters(*rateLimit, *rateLimitBurst)
+	router.Use(followerMiddleware, gzipMiddleware, inFlightMiddleware(*maxInFlight), authMiddleware, rateLimitMiddleware(newClientLimiters(*rateLimit, *rateLimitBurst)))
 
 	return router
 }
 
 func jobHandler(w http.ResponseWriter, r *http.Request) {
+	lb.RLock()
+	defer lb.RUnlock()
 	displayData := lb.Cache.DisplayJobMapping
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(displayData)
+	writeJSON(w, r, lb.Cache.ETag("/jobs"), displayData)
 }
 
 func targetHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()["collector_id"]
 	params := mux.Vars(r)
+	if len(q) == 0 {
+		if cn := clientCertIdentity(r); cn != "" {
+			q = []string{cn}
+		}
+	}
+	collectorID := ""
+	if len(q) > 0 {
+		collectorID = q[0]
+		lb.MarkSeen(collectorID)
+	}
+	index := func() uint64 { return lb.Cache.Index(params["job_id"], collectorID) }
+	if err := waitForIndex(r, index); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lb.RLock()
+	defer lb.RUnlock()
+	w.Header().Set(indexHeader, strconv.FormatUint(index(), 10))
+	link := "/jobs/" + params["job_id"] + "/targets"
 	if len(q) == 0 {
 		targets := lb.Cache.DisplayCollectorJson[params["job_id"]]
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(targets)
+		writeJSON(w, r, lb.Cache.ETag(link), targets)
 
 	} else {
 		tgs := lb.Cache.DisplayTargetMapping[params["job_id"]+q[0]]
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tgs)
+		writeJSON(w, r, lb.Cache.ETag(link+"?collector_id="+q[0]), tgs)
 	}
 }
 
-func distribute(ctx context.Context) {
-	cfg, err := config.Load()
+func collectorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.CollectorStatuses())
+}
+
+func collectorHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := lb.CollectorStatus(mux.Vars(r)["name"])
 	if err != nil {
-		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// collectorTargetsHandler serves every target of the collector across all jobs as a single HTTP SD document
+func collectorTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := lb.CollectorStatus(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	lb.MarkSeen(name)
+	index := func() uint64 { return lb.Cache.Index("", name) }
+	if err := waitForIndex(r, index); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lb.RLock()
+	defer lb.RUnlock()
+	w.Header().Set(indexHeader, strconv.FormatUint(index(), 10))
+	tgs, ok := lb.Cache.DisplayCollectorTargets[name]
+	if !ok {
+		tgs = []lbdiscovery.TargetGroup{}
+	}
+	writeJSON(w, r, lb.Cache.ETag("/collectors/"+name+"/targets"), tgs)
+}
 
-	// returns the list of collectors based on label selector
-	collectors, err := collector.Get(ctx, cfg.LabelSelector)
+// refreshTargets waits for the next discovery update and reallocates the targets
+func refreshTargets(lb *loadbalancer.LoadBalancer, discoveryManager *discovery.Manager, targets *[]lbdiscovery.TargetData) {
+	lbdiscovery.Watch(discoveryManager, targets, logger)
+	lb.UpdateTargetSet(*targets)
+	if replica.isLeader() {
+		lb.RefreshJobs()
+	}
+}
+
+// refreshCollectors looks up the collectors again so targets follow collectors joining or leaving
+func refreshCollectors(ctx context.Context, lb *loadbalancer.LoadBalancer, selectors map[string]map[string]string) {
+	if !replica.isLeader() {
+		return
+	}
+	pools, err := collector.Get(ctx, logger, selectors)
 	if err != nil {
-		fmt.Println(err)
+		level.Error(logger).Log("msg", "failed to look up collectors", "err", err)
+		return
+	}
+	if err := lb.UpdatePools(pools); err != nil {
+		level.Error(logger).Log("msg", "failed to update collectors", "err", err)
 	}
+}
+
+// loadConfig loads the configuration file merged with the fragments of the config.fragments flag
+func loadConfig() (config.Config, error) {
+	if *configFragments == "" {
+		return config.Load()
+	}
+	return config.Load(configDir+"/loadbalancer.yaml", *configFragments)
+}
+
+// isConfigChange reports whether a file event changes the configuration: a write to the configuration
+// directory, or any change to a fragment or a secret file
+func isConfigChange(event fsnotify.Event) bool {
+	if filepath.Clean(filepath.Dir(event.Name)) == filepath.Clean(configDir) && event.Op&fsnotify.Write != 0 {
+		return true
+	}
+	if *configFragments != "" {
+		fragment, _ := filepath.Match(filepath.Clean(*configFragments), filepath.Clean(event.Name))
+		if fragment && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+			return true
+		}
+	}
+	for _, pattern := range lbConfig.PrometheusFiles {
+		prometheusFile, _ := filepath.Match(filepath.Clean(pattern), filepath.Clean(event.Name))
+		if prometheusFile && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+			return true
+		}
+	}
+	// secret files are matched by their directory, see tlsReloader.watches
+	for _, file := range lbConfig.SecretFiles {
+		if filepath.Clean(filepath.Dir(event.Name)) == filepath.Clean(filepath.Dir(file)) && event.Op != fsnotify.Chmod {
+			return true
+		}
+	}
+	return false
+}
+
+// watchSecretFiles watches the directories of the secret files the configuration was resolved from
+func watchSecretFiles(cfg config.Config) {
+	for _, file := range cfg.SecretFiles {
+		if err := configWatcher.Add(filepath.Dir(file)); err != nil {
+			level.Error(logger).Log("msg", "failed to watch secret file", "file", file, "err", err)
+		}
+	}
+}
+
+// watchPrometheusFiles watches the directories of the Prometheus configuration file and of its includes
+func watchPrometheusFiles(cfg config.Config) {
+	for _, pattern := range cfg.PrometheusFiles {
+		if err := configWatcher.Add(filepath.Dir(pattern)); err != nil {
+			level.Error(logger).Log("msg", "failed to watch prometheus configuration", "file", pattern, "err", err)
+		}
+	}
+}
+
+func distribute(ctx context.Context) {
+	ctx = startRefresh(ctx)
+	cfg, cfgErr := loadConfig()
+	if cfgErr != nil {
+		level.Error(logger).Log("msg", "failed to load configuration", "err", cfgErr)
+	}
+	watchSecretFiles(cfg)
+	watchPrometheusFiles(cfg)
+
+	// returns the collectors of every pool based on its label selector
+	pools, err := collector.Get(ctx, logger, cfg.PoolSelectors())
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to look up collectors", "err", err)
+	}
+	if len(cfg.Pools) > 0 && !collector.SelectorsResolved {
+		level.Warn(logger).Log("msg", "collectors are not looked up by label selector yet, every pool holds the same collectors", "pools", len(cfg.Pools))
+	}
+	collectors := collector.Names(pools)
 
 	// creates a new discovery manager
-	discoveryManager := lbdiscovery.NewManager(ctx)
+	discoveryManager := lbdiscovery.NewManager(ctx, logger)
+
+	// jobs of ServiceMonitors and PodMonitors join the static jobs, changes to them are picked up by refreshTargets
+	watchMonitors(ctx, cfg, func() {
+		if err := lbdiscovery.Apply(discoveryManager, withMonitorJobs(cfg), logger); err != nil {
+			level.Error(logger).Log("msg", "failed to apply monitor jobs", "err", err)
+		}
+	})
 
 	// returns the list of targets
-	targets, err := lbdiscovery.Get(discoveryManager, cfg)
+	targets, err := lbdiscovery.Get(discoveryManager, withMonitorJobs(cfg), logger)
 	if err != nil {
-		fmt.Println(err)
+		level.Error(logger).Log("msg", "failed to discover targets", "err", err)
 	}
 
-	// starts a cronjob to monitor sd targets every 30s
-	s := gocron.NewScheduler(time.UTC)
-	s.Every(30).Seconds().Do(lbdiscovery.Watch, discoveryManager, &targets)
-	s.StartAsync()
+	lbConfig, lbConfigLoaded = cfg, time.Now()
+	setAuthenticator(cfg.Auth, cfgErr)
+	level.Info(logger).Log("msg", "configuration loaded", "hash", cfg.Hash(), "mode", cfg.Mode, "jobs", len(cfg.Config.ScrapeConfigs), "collectors", len(collectors), "targets", len(targets))
 
-	lb = loadbalancer.Init()
+	lb = loadbalancer.Init(log.With(logger, "component", "loadbalancer"))
 	lb.InitializeCollectors(collectors)
+	if err := lb.UpdatePools(pools); err != nil {
+		level.Error(logger).Log("msg", "failed to update collectors", "err", err)
+	}
+	if err := lb.SetPools(cfg.Pools); err != nil {
+		level.Error(logger).Log("msg", "invalid pools", "err", err)
+	}
+	if err := lb.SetPins(cfg.Pins); err != nil {
+		level.Error(logger).Log("msg", "invalid pins", "err", err)
+	}
+	if err := lb.SetAllocation(cfg.DefaultAllocation(), cfg.Allocation); err != nil {
+		level.Error(logger).Log("msg", "invalid allocation", "err", err)
+	}
 	lb.UpdateTargetSet(targets)
-	lb.RefreshJobs()
+	// followers allocate once they are elected
+	if replica.isLeader() {
+		lb.RefreshJobs()
+	}
+
+	// starts a cronjob to monitor sd targets every 30s and reallocate them
+	refresh.Every(30).Seconds().Do(refreshTargets, lb, discoveryManager, &targets)
+	refresh.Every(30).Seconds().Do(refreshCollectors, ctx, lb, cfg.PoolSelectors())
+	refresh.StartAsync()
+
+	// the gRPC server outlives configuration reloads, it is started once the first load balancer exists
+	if *grpcListenAddress != "" {
+		grpcOnce.Do(func() { go serveGRPC(*grpcListenAddress) })
+	}
 
-	handler := router()
-	server = &http.Server{Addr: ":3030", Handler: handler}
+	server = newServer(*listenAddress, router())
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error in starting server: %+s\n", err)
+		var err error
+		if tlsCerts != nil {
+			server.TLSConfig = tlsCerts.config()
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			level.Error(logger).Log("msg", "error in starting server", "err", err)
+			os.Exit(1)
 		}
 	}()
-	fmt.Println("Server started...")
+	level.Info(logger).Log("msg", "server started", "address", *listenAddress, "tls", tlsCerts != nil)
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
 	<-c
-	fmt.Println("Server shutting down...")
+	level.Info(logger).Log("msg", "server shutting down")
+
+	shutdownServer(ctx, server)
+}
 
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+// startRefresh stops the refresh and the discovery of the previous configuration and returns the context
+// the discovery of the next one runs in, its jobs are added to refresh
+func startRefresh(ctx context.Context) context.Context {
+	if refresh != nil {
+		refresh.Stop()
+	}
+	if stopRefresh != nil {
+		stopRefresh()
+	}
+	ctx, stopRefresh = context.WithCancel(ctx)
+	refresh = gocron.NewScheduler(time.UTC)
+	return ctx
+}
+
+// newServer returns a server whose event streams and blocking queries end when it is shut down, so that
+// a shutdown does not wait for clients that hold their connection open
+func newServer(address string, handler http.Handler) *http.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &http.Server{Addr: address, Handler: handler, BaseContext: func(net.Listener) context.Context { return ctx }}
+	s.RegisterOnShutdown(cancel)
+	return s
+}
+
+// shutdownServer waits up to shutdownTimeout for the requests in flight and closes the server after that
+func shutdownServer(ctx context.Context, s *http.Server) {
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
 	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		level.Error(logger).Log("msg", "error in shutting down server", "err", err)
+		s.Close()
+	}
+}
+
+// serveGRPC serves the gRPC API until the process exits
+func serveGRPC(address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		level.Error(logger).Log("msg", "error in starting gRPC server", "err", err)
+		os.Exit(1)
+	}
+	level.Info(logger).Log("msg", "gRPC server started", "address", address, "tls", tlsCerts != nil)
+	if err := newGRPCServer().Serve(listener); err != nil {
+		level.Error(logger).Log("msg", "error in serving gRPC", "err", err)
+		os.Exit(1)
+	}
+}
 
-	if err := server.Shutdown(ctx); err != http.ErrServerClosed {
-		fmt.Println(err)
+// newGRPCServer returns a gRPC server authenticated like the HTTP API and served over its TLS certificate
+func newGRPCServer() *grpc.Server {
+	opts := grpcapi.WithAuth(func() auth.Authenticator { return authenticator })
+	if tlsCerts != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCerts.config())))
 	}
+	return grpcapi.Register(func() *loadbalancer.LoadBalancer { return lb }, opts...)
 }
 
 func main() {
+	flag.Parse()
+	logger = newLogger()
+	if flag.Arg(0) == "dump" {
+		if err := runDump(flag.Args()[1:], os.Stdout); err != nil {
+			level.Error(logger).Log("msg", "failed to dump the state", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "otel-config" {
+		if err := runOTelConfig(flag.Args()[1:], os.Stdout); err != nil {
+			level.Error(logger).Log("msg", "failed to render the OpenTelemetry Collector configuration", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// watcher to monitor file changes in ConfigMap
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		fmt.Println(err)
+		level.Error(logger).Log("msg", "failed to create file watcher", "err", err)
 	}
 	defer watcher.Close()
+	configWatcher = watcher
+
+	err = watcher.Add(configDir)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to watch the configuration", "dir", configDir, "err", err)
+		os.Exit(1)
+	}
+
+	// fragments may live outside the configuration directory, e.g. in a ConfigMap per team
+	if *configFragments != "" {
+		if dir := filepath.Dir(*configFragments); filepath.Clean(dir) != filepath.Clean(configDir) {
+			if err := watcher.Add(dir); err != nil {
+				level.Error(logger).Log("msg", "failed to watch the configuration fragments", "dir", dir, "err", err)
+				os.Exit(1)
+			}
+		}
+	}
 
-	err = watcher.Add("./conf")
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		tlsCerts, err = newTLSReloader(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile, *tlsRequireClient)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load TLS certificate", "err", err)
+			os.Exit(1)
+		}
+		for _, dir := range tlsCerts.dirs() {
+			if err := watcher.Add(dir); err != nil {
+				level.Error(logger).Log("msg", "failed to watch TLS certificate", "dir", dir, "err", err)
+				os.Exit(1)
+			}
+		}
+	} else if *tlsClientCAFile != "" || *tlsRequireClient {
+		level.Error(logger).Log("msg", "client certificates need web.tls-cert-file and web.tls-key-file")
+		os.Exit(1)
+	}
+
+	if *electionSyncTokenFile != "" {
+		syncTokens, err = newTokenFile(*electionSyncTokenFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to read the sync token", "err", err)
+			os.Exit(1)
+		}
+		if err := watcher.Add(filepath.Dir(*electionSyncTokenFile)); err != nil {
+			level.Error(logger).Log("msg", "failed to watch the sync token", "file", *electionSyncTokenFile, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if *electionBackend != "" && *electionIdentity == "" {
+		*electionIdentity = defaultIdentity()
+	}
+	elector, err := newElector()
 	if err != nil {
-		log.Fatal(err)
+		level.Error(logger).Log("msg", "failed to set up leader election", "err", err)
+		os.Exit(1)
+	}
+	if elector != nil {
+		// a follower must not allocate on its own before the first leader is known
+		replica.elect(*electionIdentity)
+		go runElection(ctx, elector)
 	}
 
 	go func() {
 		for {
 			select {
 			case event := <-watcher.Events:
-				switch event.Op {
-				case fsnotify.Write:
-					fmt.Println("ConfigMap updated!")
-					server.Shutdown(ctx)
+				if tlsCerts != nil && tlsCerts.watches(event.Name) {
+					if err := tlsCerts.reload(); err != nil {
+						level.Error(logger).Log("msg", "failed to reload TLS certificate", "err", err)
+					} else {
+						level.Info(logger).Log("msg", "TLS certificate reloaded", "file", event.Name)
+					}
+				}
+				if syncTokens != nil && syncTokens.watches(event.Name) && event.Op != fsnotify.Chmod {
+					if err := syncTokens.reload(); err != nil {
+						level.Error(logger).Log("msg", "failed to reload the sync token", "err", err)
+					} else {
+						level.Info(logger).Log("msg", "sync token reloaded", "file", event.Name)
+					}
+				}
+				if isConfigChange(event) {
+					level.Info(logger).Log("msg", "configuration changed, reloading", "file", event.Name)
+					shutdownServer(ctx, server)
 					distribute(ctx)
 				}
 			case err := <-watcher.Errors:
-				fmt.Println(err)
+				level.Error(logger).Log("msg", "file watcher failed", "err", err)
 			}
 		}
 	}()
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-co-op/gocron"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/http-sd-loadbalancer/mode/modetest"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func initTestLoadBalancer(t testing.TB, targets ...lbdiscovery.TargetData) {
+	t.Helper()
+	lb = modetest.New([]string{"collector-1", "collector-2"}, targets...)
+}
+
+func TestBlockingTargetQuery(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/jobs/job-a/targets?collector_id=collector-1")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	index := resp.Header.Get(indexHeader)
+	assert.NotEmpty(t, index)
+
+	t.Run("should return after wait without changes", func(t *testing.T) {
+		start := time.Now()
+		resp, err := http.Get(srv.URL + "/jobs/job-a/targets?collector_id=collector-1&index=" + index + "&wait=100ms")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, index, resp.Header.Get(indexHeader))
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	})
+
+	t.Run("should return once the assignment changes", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			lb.UpdateTargetSet([]lbdiscovery.TargetData{
+				{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+				{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+				{JobName: "job-a", Target: "targ:1002", Labels: model.LabelSet{}},
+			})
+			lb.RefreshJobs()
+		}()
+		resp, err := http.Get(srv.URL + "/jobs/job-a/targets?collector_id=collector-2&index=" + index + "&wait=10s")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		previous, _ := strconv.ParseUint(index, 10, 64)
+		latest, err := strconv.ParseUint(resp.Header.Get(indexHeader), 10, 64)
+		assert.NoError(t, err)
+		assert.Greater(t, latest, previous)
+	})
+
+	t.Run("should reject a malformed index", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/jobs/job-a/targets?index=abc")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestConditionalGet(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/jobs/job-a/targets?collector_id=collector-1")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// test
+	req, _ := http.NewRequest("GET", srv.URL+"/jobs/job-a/targets?collector_id=collector-1", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// verify
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+}
+
+func TestGzipResponse(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	// test
+	req, _ := http.NewRequest("GET", srv.URL+"/jobs", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// verify
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	var jobs map[string]loadbalancer.LinkLabel
+	assert.NoError(t, json.NewDecoder(gz).Decode(&jobs))
+	assert.Equal(t, "/jobs/job-a/targets", jobs["job-a"].Link)
+}
+
+// Tests that the metrics, which promhttp compresses itself, are compressed once
+func TestGzipMetrics(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	// test
+	req, _ := http.NewRequest("GET", srv.URL+"/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// verify
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "# TYPE go_goroutines gauge")
+}
+
+func TestEventStream(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-b", Target: "targ:2000", Labels: model.LabelSet{}},
+	)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	// test resuming from the start with a job filter
+	req, _ := http.NewRequest("GET", srv.URL+"/events?job=job-b", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	// verify
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"id: 2", "event: target_added"}, readEvent(t, reader)[:2])
+
+	// test that new changes are pushed
+	_, err = lb.Drain("collector-2")
+	assert.NoError(t, err)
+
+	// verify
+	lines := readEvent(t, reader)
+	assert.Equal(t, "event: target_moved", lines[1])
+	var e loadbalancer.Event
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &e))
+	assert.Equal(t, "targ:2000", e.Target)
+	assert.Equal(t, "collector-2", e.OldCollector)
+	assert.Equal(t, "collector-1", e.NewCollector)
+	assert.Equal(t, loadbalancer.ReasonDrain, e.Reason)
+}
+
+// Tests that the links of the jobs of monitors lead to their targets
+func TestMonitorJobRoutes(t *testing.T) {
+	// prepare
+	job := "serviceMonitor_shop_checkout_0"
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: job, Target: "10.0.0.1:8080", Labels: model.LabelSet{"__meta_kubernetes_namespace": "shop"}})
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	getJSON := func(path string, v interface{}) {
+		resp, err := http.Get(srv.URL + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	// test
+	var jobs map[string]loadbalancer.LinkLabel
+	getJSON("/jobs", &jobs)
+	var tgs []lbdiscovery.TargetGroup
+	getJSON(jobs[job].Link+"?collector_id=collector-1", &tgs)
+	var v1Jobs struct {
+		Jobs []struct {
+			Name       string `json:"name"`
+			TargetsURL string `json:"targets_url"`
+		} `json:"jobs"`
+	}
+	getJSON("/api/v1/jobs", &v1Jobs)
+	var v1Targets struct {
+		Collectors []struct {
+			TargetGroups []lbdiscovery.TargetGroup `json:"target_groups"`
+		} `json:"collectors"`
+	}
+	getJSON(v1Jobs.Jobs[0].TargetsURL, &v1Targets)
+
+	// verify
+	assert.Equal(t, "/jobs/"+job+"/targets", jobs[job].Link)
+	assert.Len(t, tgs, 1)
+	assert.Equal(t, []string{"10.0.0.1:8080"}, tgs[0].Targets)
+	assert.Equal(t, job, v1Jobs.Jobs[0].Name)
+	assert.Len(t, v1Targets.Collectors, 1)
+	assert.Equal(t, []string{"10.0.0.1:8080"}, v1Targets.Collectors[0].TargetGroups[0].Targets)
+}
+
+// Tests that shutting down the server ends event streams and blocking queries instead of waiting for them
+func TestShutdownEndsLongLivedRequests(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := newServer(listener.Addr().String(), router())
+	go s.Serve(listener)
+	url := "http://" + listener.Addr().String()
+
+	resp, err := http.Get(url + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	index := strconv.FormatUint(lb.Cache.Index("job-a", "collector-1"), 10)
+	blocked := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url + "/jobs/job-a/targets?collector_id=collector-1&index=" + index + "&wait=10m")
+		if err == nil {
+			resp.Body.Close()
+		}
+		blocked <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// test
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	s.Close()
+
+	// verify
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, <-blocked)
+}
+
+// readEvent returns the lines of the next event on the stream
+func readEvent(t testing.TB, reader *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return lines
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(lines) > 0 {
+			return lines
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestConfigChange(t *testing.T) {
+	// prepare
+	defer func(fragments string) { *configFragments = fragments }(*configFragments)
+	*configFragments = "./conf.d/*.yaml"
+	defer func(cfg config.Config) { lbConfig = cfg }(lbConfig)
+	lbConfig = config.Config{
+		SecretFiles:     []string{"/etc/secrets/consul/token"},
+		PrometheusFiles: []string{"/etc/prometheus/prometheus.yml", "/etc/prometheus/jobs/*.yml"},
+	}
+
+	tests := []struct {
+		name     string
+		event    fsnotify.Event
+		expected bool
+	}{
+		{"should reload on a write to the configuration", fsnotify.Event{Name: "conf/loadbalancer.yaml", Op: fsnotify.Write}, true},
+		{"should ignore a new file in the configuration directory", fsnotify.Event{Name: "conf/other.yaml", Op: fsnotify.Create}, false},
+		{"should reload on a new fragment", fsnotify.Event{Name: "conf.d/team-a.yaml", Op: fsnotify.Create}, true},
+		{"should reload on a removed fragment", fsnotify.Event{Name: "conf.d/team-a.yaml", Op: fsnotify.Remove}, true},
+		{"should ignore other files next to the fragments", fsnotify.Event{Name: "conf.d/README.md", Op: fsnotify.Write}, false},
+		{"should ignore a permission change of a fragment", fsnotify.Event{Name: "conf.d/team-a.yaml", Op: fsnotify.Chmod}, false},
+		{"should reload on a rotated secret", fsnotify.Event{Name: "/etc/secrets/consul/..data", Op: fsnotify.Create}, true},
+		{"should ignore other secrets", fsnotify.Event{Name: "/etc/secrets/azure/..data", Op: fsnotify.Create}, false},
+		{"should reload on a write to the prometheus configuration", fsnotify.Event{Name: "/etc/prometheus/prometheus.yml", Op: fsnotify.Write}, true},
+		{"should reload on a new scrape config file", fsnotify.Event{Name: "/etc/prometheus/jobs/cart.yml", Op: fsnotify.Create}, true},
+		{"should ignore the rule files of prometheus", fsnotify.Event{Name: "/etc/prometheus/rules.yml", Op: fsnotify.Write}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isConfigChange(tt.event))
+		})
+	}
+}
+
+// Tests that a reload stops the refresh and the discovery of the configuration it replaces
+func TestReloadStopsRefresh(t *testing.T) {
+	// prepare
+	defer func() {
+		refresh.Stop()
+		stopRefresh()
+		refresh, stopRefresh = nil, nil
+	}()
+	var (
+		schedulers []*gocron.Scheduler
+		contexts   []context.Context
+	)
+
+	// test
+	for i := 0; i < 3; i++ {
+		ctx := startRefresh(context.Background())
+		refresh.Every(30).Seconds().Do(func() {})
+		refresh.StartAsync()
+		schedulers, contexts = append(schedulers, refresh), append(contexts, ctx)
+	}
+
+	// verify
+	var active int
+	for i, s := range schedulers {
+		if s.IsRunning() {
+			active += len(s.Jobs())
+			assert.NoError(t, contexts[i].Err())
+		} else {
+			assert.Error(t, contexts[i].Err())
+		}
+	}
+	assert.Equal(t, 1, active)
+	assert.True(t, refresh.IsRunning())
+}
@@ -0,0 +1,220 @@
+package mode
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+)
+
+// Allocator picks the collector a new target of a job is assigned to
+// Every job has its own allocator, the load of a collector is the number of targets it holds across all jobs
+type Allocator interface {
+	// Allocate returns one of the candidates, which are ordered by name, for the target
+	Allocate(target lbdiscovery.TargetData, candidates []*Collector) *Collector
+}
+
+// NewAllocator returns an allocator of the given allocation, which must be valid
+func NewAllocator(allocation config.AllocationConfig) Allocator {
+	if allocation.Mode == config.ModeConsistentHashing {
+		virtualNodes := allocation.VirtualNodes
+		if virtualNodes == 0 {
+			virtualNodes = config.DefaultVirtualNodes
+		}
+		hashLabels := make([]model.LabelName, 0, len(allocation.HashLabels))
+		for _, name := range allocation.HashLabels {
+			hashLabels = append(hashLabels, model.LabelName(name))
+		}
+		return &consistentHashing{virtualNodes: virtualNodes, hashLabels: hashLabels}
+	}
+	return leastConnection{}
+}
+
+// leastConnection assigns a target to the candidate with the fewest targets, ties go to the first one
+type leastConnection struct{}
+
+func (leastConnection) Allocate(_ lbdiscovery.TargetData, candidates []*Collector) *Collector {
+	var next *Collector
+	for _, c := range candidates {
+		if next == nil || c.NumTargs < next.NumTargs {
+			next = c
+		}
+	}
+	return next
+}
+
+// consistentHashing assigns a target to the first collector at or after its hash on a ring of virtual nodes,
+// so only the targets of a collector that leaves change their collector, and the ones a joining collector owns
+// move to it; the assignment depends on the collectors only, not on the order they came in
+type consistentHashing struct {
+	virtualNodes int
+	hashLabels   []model.LabelName
+	// ring is rebuilt when the candidates change
+	members []*Collector
+	ring    []ringPoint
+}
+
+type ringPoint struct {
+	hash      uint64
+	collector *Collector
+}
+
+func (h *consistentHashing) Allocate(target lbdiscovery.TargetData, candidates []*Collector) *Collector {
+	if len(candidates) == 0 {
+		return nil
+	}
+	h.build(candidates)
+	key := h.hash(target)
+	i := sort.Search(len(h.ring), func(i int) bool { return h.ring[i].hash >= key })
+	if i == len(h.ring) {
+		i = 0
+	}
+	return h.ring[i].collector
+}
+
+// build places the virtual nodes of the candidates on the ring, unless it already holds exactly them
+func (h *consistentHashing) build(candidates []*Collector) {
+	if sameMembers(h.members, candidates) {
+		return
+	}
+	h.members = append([]*Collector{}, candidates...)
+	h.ring = make([]ringPoint, 0, len(candidates)*h.virtualNodes)
+	for _, c := range candidates {
+		for i := 0; i < h.virtualNodes; i++ {
+			h.ring = append(h.ring, ringPoint{hash: hashString(c.Name + "-" + strconv.Itoa(i)), collector: c})
+		}
+	}
+	sort.Slice(h.ring, func(i, j int) bool {
+		if h.ring[i].hash != h.ring[j].hash {
+			return h.ring[i].hash < h.ring[j].hash
+		}
+		return h.ring[i].collector.Name < h.ring[j].collector.Name
+	})
+}
+
+func sameMembers(a, b []*Collector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hash hashes the values of the hash labels of the target, or its address without hash labels
+func (h *consistentHashing) hash(target lbdiscovery.TargetData) uint64 {
+	if len(h.hashLabels) == 0 {
+		return hashString(target.Target)
+	}
+	values := make([]string, 0, len(h.hashLabels))
+	for _, name := range h.hashLabels {
+		values = append(values, string(target.Labels[name]))
+	}
+	return hashString(strings.Join(values, "\x00"))
+}
+
+// hashString hashes with FNV-1a followed by the splitmix64 finalizer, which spreads similar names over the ring
+func hashString(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	h := f.Sum64()
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+// allocator returns the allocator of the job, created on first use
+func (lb *LoadBalancer) allocator(jobName string) Allocator {
+	if a, ok := lb.allocators[jobName]; ok {
+		return a
+	}
+	allocation, ok := lb.Allocation[jobName]
+	if !ok {
+		allocation = lb.DefaultAllocation
+	}
+	a := NewAllocator(allocation)
+	lb.allocators[jobName] = a
+	return a
+}
+
+// SetAllocation sets the allocation of the jobs without one of their own and the allocation of the other jobs
+// Targets keep their collectors, the allocation applies to the targets assigned from now on
+func (lb *LoadBalancer) SetAllocation(defaultAllocation config.AllocationConfig, jobs map[string]config.AllocationConfig) error {
+	if err := defaultAllocation.Validate(); err != nil {
+		return err
+	}
+	for _, allocation := range jobs {
+		if err := allocation.Validate(); err != nil {
+			return err
+		}
+	}
+	lb.Lock()
+	defer lb.Unlock()
+	lb.DefaultAllocation = defaultAllocation
+	lb.Allocation = make(map[string]config.AllocationConfig, len(jobs))
+	for job, allocation := range jobs {
+		lb.Allocation[job] = allocation
+	}
+	lb.allocators = make(map[string]Allocator)
+	return nil
+}
+
+// SetPools binds the jobs to the pools and moves the assigned targets into the pools of their jobs
+func (lb *LoadBalancer) SetPools(pools []config.CollectorPool) error {
+	for _, pool := range pools {
+		if err := pool.Validate(); err != nil {
+			return err
+		}
+	}
+	lb.Lock()
+	defer lb.Unlock()
+	lb.Pools = append([]config.CollectorPool{}, pools...)
+	lb.reassign(nil)
+	lb.ApplyPins()
+	lb.UpdateCache()
+	return nil
+}
+
+// jobPool returns the pool the job is allocated within
+func (lb *LoadBalancer) jobPool(jobName string) string {
+	return config.JobPool(lb.Pools, jobName)
+}
+
+// allocate picks the collector of a target among the collectors of the pool of its job, with the allocator of
+// the job, or returns nil when the pool has no collector
+func (lb *LoadBalancer) allocate(target lbdiscovery.TargetData) *Collector {
+	col := lb.allocator(target.JobName).Allocate(target, lb.candidates(target.JobName))
+	if col != nil {
+		lb.NextCol.NextCollector = col
+	}
+	return col
+}
+
+// candidates returns the collectors a target of the job may be allocated to, ordered by name
+// Cordoned and draining collectors are only considered when no other collector of the pool is available
+func (lb *LoadBalancer) candidates(jobName string) []*Collector {
+	pool := lb.jobPool(jobName)
+	members := []*Collector{}
+	for _, name := range lb.collectorNames() {
+		if lb.CollectorMap[name].InPool(pool) {
+			members = append(members, lb.CollectorMap[name])
+		}
+	}
+	candidates := []*Collector{}
+	for _, c := range members {
+		if c.Schedulable() {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return members
+	}
+	return candidates
+}
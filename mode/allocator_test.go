@@ -0,0 +1,136 @@
+package mode_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func jobTargets(job string, n int) []lbdiscovery.TargetData {
+	targets := []lbdiscovery.TargetData{}
+	for i := 0; i < n; i++ {
+		targets = append(targets, lbdiscovery.TargetData{JobName: job, Target: fmt.Sprintf("%s:%d", job, 1000+i), Labels: model.LabelSet{}})
+	}
+	return targets
+}
+
+func assignments(lb *loadbalancer.LoadBalancer, job string) map[string]string {
+	assigned := map[string]string{}
+	for _, item := range lb.TargetItemMap {
+		if item.JobName == job {
+			assigned[item.TargetUrl] = item.CollectorPtr.Name
+		}
+	}
+	return assigned
+}
+
+// Tests that consistently hashed targets only move off a collector that leaves and onto one that joins, whatever
+// the load of the others
+func TestConsistentHashingAllocation(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2", "col-3"})
+	err := lb.SetAllocation(config.AllocationConfig{Mode: config.ModeLeastConnection}, map[string]config.AllocationConfig{
+		"node": {Mode: config.ModeConsistentHashing},
+	})
+	assert.NoError(t, err)
+	lb.UpdateTargetSet(jobTargets("node", 30))
+	lb.RefreshJobs()
+	before := assignments(lb, "node")
+
+	// test
+	assert.NoError(t, lb.UpdateCollectors([]string{"col-1", "col-2"}))
+
+	// verify
+	after := assignments(lb, "node")
+	assert.Len(t, after, 30)
+	moved := 0
+	for target, col := range before {
+		if col == "col-3" {
+			moved++
+			assert.NotEqual(t, "col-3", after[target])
+		} else {
+			assert.Equal(t, col, after[target], target)
+		}
+	}
+	assert.NotZero(t, moved)
+
+	// test that another load balancer assigns the same targets to the same collectors
+	other := loadbalancer.Init(log.NewNopLogger())
+	other.InitializeCollectors([]string{"col-2", "col-1"})
+	assert.NoError(t, other.SetAllocation(config.AllocationConfig{Mode: config.ModeConsistentHashing}, nil))
+	other.UpdateTargetSet(jobTargets("node", 30))
+	other.RefreshJobs()
+
+	// verify
+	assert.Equal(t, after, assignments(other, "node"))
+
+	// test that the targets a joining collector owns move to it
+	assert.NoError(t, lb.UpdateCollectors([]string{"col-1", "col-2", "col-3"}))
+	fresh := loadbalancer.Init(log.NewNopLogger())
+	fresh.InitializeCollectors([]string{"col-1", "col-2", "col-3"})
+	assert.NoError(t, fresh.SetAllocation(config.AllocationConfig{Mode: config.ModeConsistentHashing}, nil))
+	fresh.UpdateTargetSet(jobTargets("node", 30))
+	fresh.RefreshJobs()
+
+	// verify
+	rejoined := assignments(lb, "node")
+	assert.Equal(t, assignments(fresh, "node"), rejoined)
+	assert.Equal(t, before, rejoined)
+	for target, col := range after {
+		if rejoined[target] != col {
+			assert.Equal(t, "col-3", rejoined[target], target)
+		}
+	}
+}
+
+// Tests that the least connection jobs balance the load left by the consistently hashed ones
+func TestPerJobAllocation(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2"})
+	err := lb.SetAllocation(config.AllocationConfig{Mode: config.ModeLeastConnection}, map[string]config.AllocationConfig{
+		"edge": {Mode: config.ModeConsistentHashing, VirtualNodes: 1, HashLabels: []string{"zone"}},
+	})
+	assert.NoError(t, err)
+	targets := []lbdiscovery.TargetData{}
+	for _, target := range jobTargets("edge", 6) {
+		target.Labels = model.LabelSet{"zone": "eu-1"}
+		targets = append(targets, target)
+	}
+	targets = append(targets, jobTargets("kafka", 6)...)
+
+	// test
+	lb.UpdateTargetSet(targets)
+	lb.RefreshJobs()
+
+	// verify
+	edgeCollectors := map[string]bool{}
+	for _, col := range assignments(lb, "edge") {
+		edgeCollectors[col] = true
+	}
+	assert.Len(t, edgeCollectors, 1, "targets of the same zone share a collector")
+	assert.Equal(t, 6, lb.CollectorMap["col-1"].NumTargs)
+	assert.Equal(t, 6, lb.CollectorMap["col-2"].NumTargs)
+}
+
+func TestSetAllocationValidation(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+
+	// test
+	err := lb.SetAllocation(config.AllocationConfig{Mode: config.ModeLeastConnection}, map[string]config.AllocationConfig{
+		"node": {Mode: "RoundRobin"},
+	})
+
+	// verify
+	assert.ErrorIs(t, err, config.ErrInvalidAllocation)
+	assert.Equal(t, config.ModeLeastConnection, lb.DefaultAllocation.Mode)
+	assert.Empty(t, lb.Allocation)
+}
@@ -0,0 +1,356 @@
+package mode
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+)
+
+var (
+	// ErrCollectorNotFound represents a request for a collector that is not managed by the load balancer.
+	ErrCollectorNotFound = errors.New("collector not found")
+	// ErrNoSchedulableCollector represents a drain that has no other collector in the pool of a target to move it to.
+	ErrNoSchedulableCollector = errors.New("no schedulable collector available")
+	// ErrInvalidCollectorState represents a collector state name that is not known.
+	ErrInvalidCollectorState = errors.New("invalid collector state")
+)
+
+// CollectorState describes whether a collector may receive new targets
+type CollectorState int
+
+const (
+	// StateActive collectors keep their targets and receive new ones
+	StateActive CollectorState = iota
+	// StateCordoned collectors keep their targets but receive no new ones
+	StateCordoned
+	// StateDraining collectors have their targets moved away and receive no new ones
+	StateDraining
+)
+
+var collectorStateNames = map[CollectorState]string{
+	StateActive:   "active",
+	StateCordoned: "cordoned",
+	StateDraining: "draining",
+}
+
+func (s CollectorState) String() string {
+	return collectorStateNames[s]
+}
+
+func (s CollectorState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *CollectorState) UnmarshalText(text []byte) error {
+	for state, name := range collectorStateNames {
+		if name == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return ErrInvalidCollectorState
+}
+
+// Schedulable reports whether new targets may be assigned to the collector
+func (c *Collector) Schedulable() bool {
+	return c.State == StateActive
+}
+
+// InPool reports whether the collector is a member of the pool
+func (c *Collector) InPool(pool string) bool {
+	for _, p := range c.Pools {
+		if p == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// schedulable reports whether the pool has a collector new targets can be assigned to
+func (lb *LoadBalancer) schedulable(pool string) bool {
+	for _, c := range lb.CollectorMap {
+		if c.InPool(pool) && c.Schedulable() {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectorStatus is the display form of a collector on the http server
+type CollectorStatus struct {
+	Name       string         `json:"name"`
+	NumTargets int            `json:"targets"`
+	Jobs       map[string]int `json:"jobs"`
+	State      CollectorState `json:"state"`
+	Pools      []string       `json:"pools"`
+	LastSeen   *time.Time     `json:"last_seen,omitempty"`
+	Link       string         `json:"_link"`
+}
+
+// CollectorStatuses returns the status of every collector ordered by name
+func (lb *LoadBalancer) CollectorStatuses() []CollectorStatus {
+	lb.RLock()
+	defer lb.RUnlock()
+	statuses := []CollectorStatus{}
+	for _, name := range lb.collectorNames() {
+		statuses = append(statuses, lb.status(lb.CollectorMap[name]))
+	}
+	return statuses
+}
+
+// CollectorStatus returns the status of a single collector
+func (lb *LoadBalancer) CollectorStatus(name string) (CollectorStatus, error) {
+	lb.RLock()
+	defer lb.RUnlock()
+	col, ok := lb.CollectorMap[name]
+	if !ok {
+		return CollectorStatus{}, ErrCollectorNotFound
+	}
+	return lb.status(col), nil
+}
+
+// status builds the display form of the collector including the number of targets it holds per job
+func (lb *LoadBalancer) status(c *Collector) CollectorStatus {
+	jobs := make(map[string]int)
+	for _, item := range lb.TargetItemMap {
+		if item.CollectorPtr == c {
+			jobs[item.JobName]++
+		}
+	}
+	status := CollectorStatus{Name: c.Name, NumTargets: c.NumTargs, Jobs: jobs, State: c.State, Pools: append([]string{}, c.Pools...), Link: "/collectors/" + c.Name + "/targets"}
+	if lastSeen := c.LastSeen(); !lastSeen.IsZero() {
+		status.LastSeen = &lastSeen
+	}
+	return status
+}
+
+// MarkSeen records that the collector just fetched its targets
+func (lb *LoadBalancer) MarkSeen(name string) {
+	lb.RLock()
+	defer lb.RUnlock()
+	if col, ok := lb.CollectorMap[name]; ok {
+		atomic.StoreInt64(&col.lastSeen, time.Now().UnixNano())
+	}
+}
+
+// LastSeen returns when the collector last fetched its targets, zero if it never did
+func (c *Collector) LastSeen() time.Time {
+	nanos := atomic.LoadInt64(&c.lastSeen)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Cordon stops new targets from being assigned to the collector; its current targets are kept
+func (lb *LoadBalancer) Cordon(name string) (CollectorStatus, error) {
+	return lb.setState(name, StateCordoned)
+}
+
+// Uncordon makes the collector eligible for new targets again
+func (lb *LoadBalancer) Uncordon(name string) (CollectorStatus, error) {
+	return lb.setState(name, StateActive)
+}
+
+func (lb *LoadBalancer) setState(name string, state CollectorState) (CollectorStatus, error) {
+	lb.Lock()
+	defer lb.Unlock()
+	col, ok := lb.CollectorMap[name]
+	if !ok {
+		return CollectorStatus{}, ErrCollectorNotFound
+	}
+	col.State = state
+	return lb.status(col), nil
+}
+
+// Drain cordons the collector and reassigns all of its targets to the remaining collectors of their pools
+func (lb *LoadBalancer) Drain(name string) (CollectorStatus, error) {
+	lb.Lock()
+	defer lb.Unlock()
+	col, ok := lb.CollectorMap[name]
+	if !ok {
+		return CollectorStatus{}, ErrCollectorNotFound
+	}
+	var keys []string
+	for k, v := range lb.TargetItemMap {
+		if v.CollectorPtr == col {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	previous := col.State
+	col.State = StateDraining
+	if lb.leastLoaded(true) == nil {
+		col.State = previous
+		return CollectorStatus{}, ErrNoSchedulableCollector
+	}
+	// the targets only move within the pools of their jobs
+	for _, k := range keys {
+		if !lb.schedulable(lb.jobPool(lb.TargetItemMap[k].JobName)) {
+			col.State = previous
+			return CollectorStatus{}, ErrNoSchedulableCollector
+		}
+	}
+	for _, k := range keys {
+		item := lb.TargetItemMap[k]
+		next := lb.allocate(lbdiscovery.TargetData{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label})
+		if next == nil {
+			continue
+		}
+		item.CollectorPtr = next
+		next.NumTargs++
+		col.NumTargs--
+		lb.moveReasons[k] = ReasonDrain
+	}
+	lb.UpdateCache()
+	return lb.status(col), nil
+}
+
+// UpdateCollectors reconciles the collector set with the given names, all of them in the default pool: new
+// collectors join with no targets, and the targets of collectors that left are reassigned to the remaining ones
+func (lb *LoadBalancer) UpdateCollectors(collectors []string) error {
+	return lb.UpdatePools(map[string][]string{config.DefaultPool: collectors})
+}
+
+// UpdatePools reconciles the collector set with the collectors of the pools: new collectors join with no targets,
+// and the targets of collectors that left, or that left the pool of the job, are reassigned within the pool
+// A target that no collector of its pool can take is unassigned until one joins
+func (lb *LoadBalancer) UpdatePools(pools map[string][]string) error {
+	membership := make(map[string][]string)
+	for pool, collectors := range pools {
+		for _, name := range collectors {
+			membership[name] = append(membership[name], pool)
+		}
+	}
+	if len(membership) == 0 {
+		return ErrNoSchedulableCollector
+	}
+	lb.Lock()
+	defer lb.Unlock()
+	lb.Refreshes.CollectorsUpdated = time.Now()
+
+	changed := false
+	for _, name := range sortedKeys(membership) {
+		sort.Strings(membership[name])
+		col, ok := lb.CollectorMap[name]
+		if !ok {
+			col = &Collector{Name: name}
+			lb.CollectorMap[name] = col
+			lb.publish(Event{Type: EventCollectorJoined, NewCollector: name, Reason: ReasonDiscovered})
+			changed = true
+		}
+		if !samePools(col.Pools, membership[name]) {
+			col.Pools = membership[name]
+			changed = true
+		}
+	}
+
+	left := make(map[*Collector]bool)
+	for _, name := range lb.collectorNames() {
+		if _, ok := membership[name]; !ok {
+			left[lb.CollectorMap[name]] = true
+			delete(lb.CollectorMap, name)
+			lb.publish(Event{Type: EventCollectorLeft, OldCollector: name, Reason: ReasonDisappeared})
+		}
+	}
+	if !changed && len(left) == 0 {
+		return nil
+	}
+	lb.reassign(left)
+	if left[lb.NextCol.NextCollector] {
+		lb.SetNextCollector()
+	}
+	lb.ApplyPins()
+	lb.UpdateCache()
+	return nil
+}
+
+// reassign moves the targets of the collectors that left, and the ones that may not stay on their collector,
+// within the pools of their jobs; consistently hashed targets move to the collector owning them on the ring
+func (lb *LoadBalancer) reassign(left map[*Collector]bool) {
+	keys := make([]string, 0, len(lb.TargetItemMap))
+	rehashed := make(map[string]bool)
+	for k, v := range lb.TargetItemMap {
+		switch {
+		case left[v.CollectorPtr] || !lb.placeable(v):
+			keys = append(keys, k)
+		case lb.rehashed(v):
+			keys = append(keys, k)
+			rehashed[k] = true
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		item := lb.TargetItemMap[k]
+		reason := ReasonPool
+		if rehashed[k] {
+			reason = ReasonRebalance
+		}
+		if left[item.CollectorPtr] {
+			reason = ReasonCollectorLeft
+		} else {
+			item.CollectorPtr.NumTargs--
+		}
+		target := lbdiscovery.TargetData{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label}
+		col := lb.pinnedCollector(target)
+		if col == nil {
+			col = lb.allocate(target)
+		}
+		if col == nil {
+			delete(lb.TargetItemMap, k)
+			delete(lb.TargetMap, k)
+			continue
+		}
+		item.CollectorPtr = col
+		col.NumTargs++
+		lb.moveReasons[k] = reason
+	}
+}
+
+// placeable reports whether the target may stay on its collector: the collector is in the pool of the job,
+// or the target is pinned to it
+func (lb *LoadBalancer) placeable(item *TargetItem) bool {
+	if item.CollectorPtr.InPool(lb.jobPool(item.JobName)) {
+		return true
+	}
+	return lb.pinnedCollector(lbdiscovery.TargetData{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label}) == item.CollectorPtr
+}
+
+// rehashed reports whether the target of a consistently hashed job is owned by another collector on the ring,
+// which happens when a collector joins; pinned targets and the targets of cordoned or draining collectors stay
+func (lb *LoadBalancer) rehashed(item *TargetItem) bool {
+	if _, ok := lb.allocator(item.JobName).(*consistentHashing); !ok || !item.CollectorPtr.Schedulable() {
+		return false
+	}
+	target := lbdiscovery.TargetData{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label}
+	if lb.pinnedCollector(target) != nil {
+		return false
+	}
+	return lb.allocator(item.JobName).Allocate(target, lb.candidates(item.JobName)) != item.CollectorPtr
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func samePools(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package mode_test
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/http-sd-loadbalancer/mode/modetest"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func initLoadBalancer(cols []string, targets []string) *loadbalancer.LoadBalancer {
+	return modetest.New(cols, sampleTargets(targets)...)
+}
+
+func initLoadBalancerWith(lb *loadbalancer.LoadBalancer, targets []string) *loadbalancer.LoadBalancer {
+	return modetest.Allocate(lb, sampleTargets(targets)...)
+}
+
+// sampleTargets returns the targets of the sample-name job at the addresses
+func sampleTargets(addresses []string) []lbdiscovery.TargetData {
+	var targets []lbdiscovery.TargetData
+	for _, address := range addresses {
+		targets = append(targets, lbdiscovery.TargetData{JobName: "sample-name", Target: address, Labels: model.LabelSet{}})
+	}
+	return targets
+}
+
+// Tests that a cordoned collector keeps its targets but receives no new ones
+func TestCordonCollector(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+
+	// test
+	status, err := lb.Cordon("col-1")
+	assert.NoError(t, err)
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1001", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1002", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1003", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+
+	// verify
+	assert.Equal(t, loadbalancer.StateCordoned, status.State)
+	assert.Equal(t, "col-1", lb.TargetItemMap["sample-nametarg:1000"].CollectorPtr.Name)
+	assert.Equal(t, "col-2", lb.TargetItemMap["sample-nametarg:1002"].CollectorPtr.Name)
+	assert.Equal(t, "col-2", lb.TargetItemMap["sample-nametarg:1003"].CollectorPtr.Name)
+
+	// test that uncordoning makes the collector eligible again
+	_, err = lb.Uncordon("col-1")
+	assert.NoError(t, err)
+	lb.SetNextCollector()
+
+	// verify
+	assert.Equal(t, "col-1", lb.NextCol.NextCollector.Name)
+}
+
+// Tests that draining moves every target of the collector to the others
+func TestDrainCollector(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2", "col-3"}, []string{"targ:1000", "targ:1001", "targ:1002", "targ:1003", "targ:1004", "targ:1005"})
+
+	// test
+	status, err := lb.Drain("col-2")
+
+	// verify
+	assert.NoError(t, err)
+	assert.Equal(t, loadbalancer.StateDraining, status.State)
+	assert.Equal(t, 0, status.NumTargets)
+	assert.Equal(t, 3, lb.CollectorMap["col-1"].NumTargs)
+	assert.Equal(t, 3, lb.CollectorMap["col-3"].NumTargs)
+	for _, item := range lb.TargetItemMap {
+		assert.NotEqual(t, "col-2", item.CollectorPtr.Name)
+	}
+	_, ok := lb.Cache.DisplayJobs["sample-name"]["col-2"]
+	assert.False(t, ok)
+}
+
+func TestDrainErrors(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1"}, []string{"targ:1000"})
+
+	// test
+	_, notFoundErr := lb.Drain("missing")
+	_, lastErr := lb.Drain("col-1")
+
+	// verify
+	assert.ErrorIs(t, notFoundErr, loadbalancer.ErrCollectorNotFound)
+	assert.ErrorIs(t, lastErr, loadbalancer.ErrNoSchedulableCollector)
+	assert.Equal(t, loadbalancer.StateActive, lb.CollectorMap["col-1"].State)
+}
+
+// Tests the per job breakdown and the cross job target document of a collector
+func TestCollectorStatus(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2"})
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{"foo": "bar"}},
+		{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{"foo": "bar"}},
+		{JobName: "job-b", Target: "targ:2000", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+
+	// test
+	lb.MarkSeen("col-1")
+	status, err := lb.CollectorStatus("col-1")
+	_, notFoundErr := lb.CollectorStatus("missing")
+
+	// verify
+	assert.NoError(t, err)
+	assert.ErrorIs(t, notFoundErr, loadbalancer.ErrCollectorNotFound)
+	assert.Equal(t, 2, status.NumTargets)
+	assert.Equal(t, map[string]int{"job-a": 1, "job-b": 1}, status.Jobs)
+	assert.Equal(t, loadbalancer.StateActive, status.State)
+	assert.NotNil(t, status.LastSeen)
+	assert.Nil(t, lb.CollectorStatuses()[1].LastSeen)
+
+	tgs := lb.Cache.DisplayCollectorTargets["col-1"]
+	assert.Equal(t, []lbdiscovery.TargetGroup{
+		{Targets: []string{"targ:1000"}, Labels: model.LabelSet{"foo": "bar", loadbalancer.JobLabel: "job-a"}},
+		{Targets: []string{"targ:2000"}, Labels: model.LabelSet{loadbalancer.JobLabel: "job-b"}},
+	}, tgs)
+	assert.Equal(t, model.LabelSet{"foo": "bar"}, lb.Cache.DisplayTargetMapping["job-acol-1"][0].Labels)
+}
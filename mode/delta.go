@@ -0,0 +1,73 @@
+package mode
+
+import (
+	"sort"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+)
+
+// Delta holds the targets added to and removed from a collector since the previous delta
+type Delta struct {
+	Generation uint64
+	Added      []lbdiscovery.TargetData
+	Removed    []lbdiscovery.TargetData
+}
+
+// Empty reports whether the delta carries no change
+func (d Delta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// Assignments returns the targets assigned to the collector, ordered by job and target, along with the current generation
+func (lb *LoadBalancer) Assignments(collector string) ([]lbdiscovery.TargetData, uint64, error) {
+	lb.RLock()
+	defer lb.RUnlock()
+	col, ok := lb.CollectorMap[collector]
+	if !ok {
+		return nil, 0, ErrCollectorNotFound
+	}
+	targets := []lbdiscovery.TargetData{}
+	for _, item := range lb.TargetItemMap {
+		if item.CollectorPtr == col {
+			targets = append(targets, lbdiscovery.TargetData{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label})
+		}
+	}
+	sortTargets(targets)
+	return targets, lb.Cache.Generation, nil
+}
+
+// NewDelta compares two assignments of a collector; a target whose labels changed is both removed and added
+func NewDelta(prev, next []lbdiscovery.TargetData, generation uint64) Delta {
+	delta := Delta{Generation: generation}
+	previous := make(map[string]lbdiscovery.TargetData, len(prev))
+	for _, t := range prev {
+		previous[t.JobName+t.Target] = t
+	}
+	current := make(map[string]bool, len(next))
+	for _, t := range next {
+		current[t.JobName+t.Target] = true
+		if old, ok := previous[t.JobName+t.Target]; !ok || !old.Labels.Equal(t.Labels) {
+			delta.Added = append(delta.Added, t)
+			if ok {
+				delta.Removed = append(delta.Removed, old)
+			}
+		}
+	}
+	for _, t := range prev {
+		if !current[t.JobName+t.Target] {
+			delta.Removed = append(delta.Removed, t)
+		}
+	}
+	sortTargets(delta.Added)
+	sortTargets(delta.Removed)
+	return delta
+}
+
+func sortTargets(targets []lbdiscovery.TargetData) {
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].JobName != targets[j].JobName {
+			return targets[i].JobName < targets[j].JobName
+		}
+		return targets[i].Target < targets[j].Target
+	})
+}
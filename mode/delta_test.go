@@ -0,0 +1,41 @@
+package mode_test
+
+import (
+	"testing"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignmentsAndDelta(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001", "targ:1002"})
+	before, _, err := lb.Assignments("col-1")
+	assert.NoError(t, err)
+	_, _, notFoundErr := lb.Assignments("missing")
+
+	// test
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{"foo": "bar"}},
+		{JobName: "sample-name", Target: "targ:1001", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+	after, generation, err := lb.Assignments("col-1")
+	assert.NoError(t, err)
+	delta := loadbalancer.NewDelta(before, after, generation)
+
+	// verify
+	assert.ErrorIs(t, notFoundErr, loadbalancer.ErrCollectorNotFound)
+	assert.Equal(t, []string{"targ:1000", "targ:1002"}, []string{before[0].Target, before[1].Target})
+	assert.Equal(t, lb.Cache.Generation, delta.Generation)
+	assert.Empty(t, delta.Added)
+	assert.Equal(t, []lbdiscovery.TargetData{{JobName: "sample-name", Target: "targ:1002", Labels: model.LabelSet{}}}, delta.Removed)
+	assert.True(t, loadbalancer.NewDelta(after, after, generation).Empty())
+
+	relabeled := []lbdiscovery.TargetData{{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{"foo": "baz"}}}
+	changed := loadbalancer.NewDelta(after, relabeled, generation)
+	assert.Equal(t, relabeled, changed.Added)
+	assert.Len(t, changed.Removed, 1)
+}
@@ -0,0 +1,37 @@
+package mode
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+// ETag returns the entity tag of the response served at link, or an empty string if there is none
+func (c DisplayCache) ETag(link string) string {
+	return c.ETags[link]
+}
+
+// updateETags hashes the JSON encoding of every cached response so unchanged responses can be answered with 304
+func (lb *LoadBalancer) updateETags() {
+	lb.Cache.ETags = make(map[string]string)
+	lb.Cache.ETags["/jobs"] = etag(lb.Cache.DisplayJobMapping)
+	for k, v := range lb.Cache.DisplayCollectorJson {
+		lb.Cache.ETags["/jobs/"+k+"/targets"] = etag(v)
+		for kk, vv := range v {
+			lb.Cache.ETags[vv.Link] = etag(lb.Cache.DisplayTargetMapping[k+kk])
+		}
+	}
+	for k, v := range lb.Cache.DisplayCollectorTargets {
+		lb.Cache.ETags["/collectors/"+k+"/targets"] = etag(v)
+	}
+}
+
+func etag(v interface{}) string {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf("%q", fmt.Sprintf("%016x", h.Sum64()))
+}
@@ -0,0 +1,38 @@
+package mode_test
+
+import (
+	"testing"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that the entity tags only change for the responses whose content changed
+func TestETags(t *testing.T) {
+	// prepare
+	targets := []string{"targ:1000", "targ:1001", "targ:1002", "targ:1003"}
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, targets)
+	other := initLoadBalancer([]string{"col-1", "col-2"}, targets)
+	link := "/jobs/sample-name/targets?collector_id=col-1"
+
+	// verify that identical assignments produce identical tags
+	assert.NotEmpty(t, lb.Cache.ETag(link))
+	assert.Equal(t, lb.Cache.ETags, other.Cache.ETags)
+	assert.Empty(t, lb.Cache.ETag("/jobs/unknown/targets"))
+
+	// test
+	before := lb.Cache.ETags
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1001", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1002", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+
+	// verify
+	assert.Equal(t, before[link], lb.Cache.ETag(link))
+	assert.NotEqual(t, before["/jobs/sample-name/targets?collector_id=col-2"], lb.Cache.ETag("/jobs/sample-name/targets?collector_id=col-2"))
+	assert.NotEqual(t, before["/jobs/sample-name/targets"], lb.Cache.ETag("/jobs/sample-name/targets"))
+	assert.Equal(t, before["/jobs"], lb.Cache.ETag("/jobs"))
+}
@@ -0,0 +1,130 @@
+package mode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Event types published whenever the allocation changes
+const (
+	EventTargetAdded     = "target_added"
+	EventTargetRemoved   = "target_removed"
+	EventTargetMoved     = "target_moved"
+	EventCollectorJoined = "collector_joined"
+	EventCollectorLeft   = "collector_left"
+)
+
+// Reasons attached to events
+const (
+	ReasonDiscovered    = "discovered"
+	ReasonDisappeared   = "disappeared"
+	ReasonRebalance     = "rebalance"
+	ReasonDrain         = "drain"
+	ReasonPin           = "pin"
+	ReasonCollectorLeft = "collector_left"
+	ReasonPool          = "pool"
+)
+
+// defaultEventLogSize is the number of events kept for clients resuming a stream
+const defaultEventLogSize = 1024
+
+// Event describes a single allocation change
+type Event struct {
+	ID           uint64    `json:"id"`
+	Type         string    `json:"type"`
+	JobName      string    `json:"job,omitempty"`
+	Target       string    `json:"target,omitempty"`
+	OldCollector string    `json:"old_collector,omitempty"`
+	NewCollector string    `json:"new_collector,omitempty"`
+	Reason       string    `json:"reason"`
+	Time         time.Time `json:"time"`
+}
+
+// Collector returns whether the event concerns the named collector
+func (e Event) Collector(name string) bool {
+	return e.OldCollector == name || e.NewCollector == name
+}
+
+// EventLog keeps the most recent events in a bounded ring so clients can resume from the last id they saw
+type EventLog struct {
+	mu     sync.Mutex
+	ring   []Event
+	lastID uint64
+	notify chan struct{}
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{ring: make([]Event, 0, size), notify: make(chan struct{})}
+}
+
+// Append assigns the next id to the event, stores it and wakes up waiting readers
+func (l *EventLog) Append(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	e.ID = l.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if len(l.ring) < cap(l.ring) {
+		l.ring = append(l.ring, e)
+	} else {
+		copy(l.ring, l.ring[1:])
+		l.ring[len(l.ring)-1] = e
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// Since returns the retained events with an id greater than id, whether none in between were evicted,
+// and a channel that is closed when the next event is appended
+// An id that was never handed out, e.g. from before a restart, is treated as a resume from the start
+func (l *EventLog) Since(id uint64) ([]Event, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	complete := true
+	if id > l.lastID {
+		id = 0
+		complete = false
+	}
+	if len(l.ring) > 0 && l.ring[0].ID > id+1 {
+		complete = false
+	}
+	var events []Event
+	for _, e := range l.ring {
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events, complete, l.notify
+}
+
+// LastID returns the id of the most recent event
+func (l *EventLog) LastID() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// publish appends the event to the log and logs it; target moves and collector changes are logged
+// at info level, targets appearing or disappearing at debug level
+func (lb *LoadBalancer) publish(e Event) {
+	lb.Events.Append(e)
+	logger := level.Debug(lb.logger)
+	if e.Type != EventTargetAdded && e.Type != EventTargetRemoved {
+		logger = level.Info(lb.logger)
+	}
+	keyvals := []interface{}{"msg", "allocation changed", "event", e.Type, "reason", e.Reason}
+	if e.JobName != "" {
+		keyvals = append(keyvals, "job", e.JobName, "target", e.Target)
+	}
+	if e.OldCollector != "" {
+		keyvals = append(keyvals, "old_collector", e.OldCollector)
+	}
+	if e.NewCollector != "" {
+		keyvals = append(keyvals, "new_collector", e.NewCollector)
+	}
+	logger.Log(keyvals...)
+}
@@ -0,0 +1,111 @@
+package mode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLogRing(t *testing.T) {
+	// prepare
+	events := loadbalancer.NewEventLog(3)
+	for i := 0; i < 5; i++ {
+		events.Append(loadbalancer.Event{Type: loadbalancer.EventTargetAdded})
+	}
+
+	// test
+	resumed, resumedComplete, _ := events.Since(3)
+	evicted, evictedComplete, _ := events.Since(1)
+	unknown, unknownComplete, _ := events.Since(42)
+
+	// verify
+	assert.Equal(t, uint64(5), events.LastID())
+	assert.True(t, resumedComplete)
+	assert.Len(t, resumed, 2)
+	assert.Equal(t, uint64(4), resumed[0].ID)
+	assert.False(t, evictedComplete)
+	assert.Len(t, evicted, 3)
+	assert.False(t, unknownComplete)
+	assert.Len(t, unknown, 3)
+}
+
+// Tests that refreshing, draining and pinning publish the matching events
+func TestAllocationEvents(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+	events, _, _ := lb.Events.Since(0)
+	assert.Len(t, events, 2)
+	assert.Equal(t, loadbalancer.Event{ID: 1, Type: loadbalancer.EventTargetAdded, JobName: "sample-name", Target: "targ:1000", NewCollector: "col-1", Reason: loadbalancer.ReasonDiscovered, Time: events[0].Time}, events[0])
+	lastID := lb.Events.LastID()
+
+	// test
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}}})
+	lb.RefreshJobs()
+	_, err := lb.Drain("col-1")
+	assert.NoError(t, err)
+
+	// verify
+	events, complete, _ := lb.Events.Since(lastID)
+	assert.True(t, complete)
+	assert.Len(t, events, 2)
+	assert.Equal(t, loadbalancer.EventTargetRemoved, events[0].Type)
+	assert.Equal(t, "col-2", events[0].OldCollector)
+	assert.Equal(t, loadbalancer.ReasonDisappeared, events[0].Reason)
+	assert.Equal(t, loadbalancer.EventTargetMoved, events[1].Type)
+	assert.Equal(t, "targ:1000", events[1].Target)
+	assert.Equal(t, "col-1", events[1].OldCollector)
+	assert.Equal(t, "col-2", events[1].NewCollector)
+	assert.Equal(t, loadbalancer.ReasonDrain, events[1].Reason)
+}
+
+// Tests that targets follow collectors joining and leaving
+func TestUpdateCollectors(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001", "targ:1002", "targ:1003"})
+	lastID := lb.Events.LastID()
+
+	// test
+	err := lb.UpdateCollectors([]string{"col-2", "col-3"})
+
+	// verify
+	assert.NoError(t, err)
+	assert.Len(t, lb.CollectorMap, 2)
+	assert.Equal(t, 2, lb.CollectorMap["col-2"].NumTargs)
+	assert.Equal(t, 2, lb.CollectorMap["col-3"].NumTargs)
+	for _, item := range lb.TargetItemMap {
+		assert.NotEqual(t, "col-1", item.CollectorPtr.Name)
+	}
+	events, _, _ := lb.Events.Since(lastID)
+	assert.Len(t, events, 4)
+	assert.Equal(t, loadbalancer.EventCollectorJoined, events[0].Type)
+	assert.Equal(t, "col-3", events[0].NewCollector)
+	assert.Equal(t, loadbalancer.EventCollectorLeft, events[1].Type)
+	assert.Equal(t, "col-1", events[1].OldCollector)
+	assert.Equal(t, loadbalancer.ReasonCollectorLeft, events[2].Reason)
+	assert.Equal(t, "col-3", events[2].NewCollector)
+	assert.Error(t, lb.UpdateCollectors(nil))
+}
+
+// Tests that allocation changes are logged with structured fields, target additions only at debug level
+func TestAllocationChangesAreLogged(t *testing.T) {
+	// prepare
+	var buf bytes.Buffer
+	lb := loadbalancer.Init(level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowInfo()))
+	lb.InitializeCollectors([]string{"col-1", "col-2"})
+	initLoadBalancerWith(lb, []string{"targ:1000", "targ:1001"})
+
+	// test
+	_, err := lb.Drain("col-1")
+	assert.NoError(t, err)
+
+	// verify
+	assert.NotContains(t, buf.String(), "event=target_added")
+	assert.Contains(t, buf.String(), "level=info msg=\"allocation changed\" event=target_moved reason=drain job=sample-name target=targ:1000 old_collector=col-1 new_collector=col-2")
+	assert.Contains(t, buf.String(), "level=info msg=\"assignment updated\" generation=3 added=0 removed=0 moved=1 targets=2")
+}
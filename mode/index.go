@@ -0,0 +1,104 @@
+package mode
+
+import (
+	"sort"
+
+	"github.com/go-kit/log/level"
+)
+
+// assignment records where a target was placed the last time the cache was built
+type assignment struct {
+	JobName   string
+	Target    string
+	Collector string
+}
+
+// Index returns the generation at which the assignment last changed for the job and collector
+// Either may be empty to get the index of the whole job or of the collector across every job
+func (c DisplayCache) Index(jobName, collectorName string) uint64 {
+	var index uint64
+	switch {
+	case jobName != "" && collectorName != "":
+		index = c.TargetIndex[jobName+collectorName]
+	case jobName != "":
+		index = c.JobIndex[jobName]
+	case collectorName != "":
+		index = c.CollectorIndex[collectorName]
+	default:
+		index = c.Generation
+	}
+	if index == 0 {
+		return 1
+	}
+	return index
+}
+
+// Changed returns a channel that is closed the next time the generation moves forward
+func (lb *LoadBalancer) Changed() <-chan struct{} {
+	lb.RLock()
+	defer lb.RUnlock()
+	return lb.changed
+}
+
+// updateIndex compares the current assignment with the one of the previous cache, bumps the
+// generation if anything moved and records it against every job and collector that was touched
+// Every difference is published to the event log
+func (lb *LoadBalancer) updateIndex(prev DisplayCache) {
+	lb.Cache.Generation = prev.Generation
+	lb.Cache.JobIndex = prev.JobIndex
+	lb.Cache.CollectorIndex = prev.CollectorIndex
+	lb.Cache.TargetIndex = prev.TargetIndex
+
+	current := make(map[string]assignment, len(lb.TargetItemMap))
+	keys := make([]string, 0, len(lb.TargetItemMap)+len(lb.assigned))
+	for k, v := range lb.TargetItemMap {
+		current[k] = assignment{JobName: v.JobName, Target: v.TargetUrl, Collector: v.CollectorPtr.Name}
+		keys = append(keys, k)
+	}
+	for k := range lb.assigned {
+		if _, ok := current[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var touched []assignment
+	added, removed, moved := 0, 0, 0
+	for _, k := range keys {
+		v, ok := current[k]
+		old, existed := lb.assigned[k]
+		switch {
+		case !ok:
+			touched = append(touched, old)
+			removed++
+			lb.publish(Event{Type: EventTargetRemoved, JobName: old.JobName, Target: old.Target, OldCollector: old.Collector, Reason: ReasonDisappeared})
+		case !existed:
+			touched = append(touched, v)
+			added++
+			lb.publish(Event{Type: EventTargetAdded, JobName: v.JobName, Target: v.Target, NewCollector: v.Collector, Reason: ReasonDiscovered})
+		case old != v:
+			touched = append(touched, old, v)
+			moved++
+			reason, found := lb.moveReasons[k]
+			if !found {
+				reason = ReasonRebalance
+			}
+			lb.publish(Event{Type: EventTargetMoved, JobName: v.JobName, Target: v.Target, OldCollector: old.Collector, NewCollector: v.Collector, Reason: reason})
+		}
+	}
+	lb.assigned = current
+	lb.moveReasons = make(map[string]string)
+	if len(touched) == 0 {
+		return
+	}
+
+	lb.Cache.Generation++
+	for _, a := range touched {
+		lb.Cache.JobIndex[a.JobName] = lb.Cache.Generation
+		lb.Cache.CollectorIndex[a.Collector] = lb.Cache.Generation
+		lb.Cache.TargetIndex[a.JobName+a.Collector] = lb.Cache.Generation
+	}
+	close(lb.changed)
+	lb.changed = make(chan struct{})
+	level.Info(lb.logger).Log("msg", "assignment updated", "generation", lb.Cache.Generation, "added", added, "removed", removed, "moved", moved, "targets", len(current))
+}
@@ -0,0 +1,54 @@
+package mode_test
+
+import (
+	"testing"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that the generation only moves when an assignment changes and only for the touched jobs and collectors
+func TestAssignmentIndex(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+	generation := lb.Cache.Generation
+	changed := lb.Changed()
+
+	// test that refreshing without changes keeps the generation
+	lb.RefreshJobs()
+
+	// verify
+	assert.Equal(t, generation, lb.Cache.Generation)
+	assert.Equal(t, generation, lb.Cache.Index("sample-name", "col-1"))
+	select {
+	case <-changed:
+		t.Fatal("changed channel closed without a change")
+	default:
+	}
+
+	// test that a new target in another job bumps only that job and its collector
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1001", Labels: model.LabelSet{}},
+		{JobName: "other-job", Target: "targ:2000", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+
+	// verify
+	assert.Equal(t, generation+1, lb.Cache.Generation)
+	assert.Equal(t, generation+1, lb.Cache.Index("other-job", "col-1"))
+	assert.Equal(t, generation+1, lb.Cache.Index("", "col-1"))
+	assert.Equal(t, generation, lb.Cache.Index("sample-name", "col-1"))
+	assert.Equal(t, generation, lb.Cache.Index("", "col-2"))
+	assert.Equal(t, uint64(1), lb.Cache.Index("unknown-job", ""))
+	<-changed
+
+	// test that draining moves the index of both collectors
+	_, err := lb.Drain("col-2")
+
+	// verify
+	assert.NoError(t, err)
+	assert.Equal(t, generation+2, lb.Cache.Index("sample-name", "col-2"))
+	assert.Equal(t, generation+2, lb.Cache.Index("sample-name", "col-1"))
+}
@@ -1,8 +1,14 @@
 package mode
 
 import (
-	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/http-sd-loadbalancer/config"
 	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
 	"github.com/prometheus/common/model"
 )
@@ -16,8 +22,14 @@ import (
 // This struct will be parsed into endpoint with collector and jobs info
 
 type Collector struct {
+	// lastSeen is when the collector last fetched its targets in unix nanoseconds, it is stored atomically
+	// under the read lock so that fetches don't wait for allocations; first for 64-bit alignment
+	lastSeen int64
 	Name     string
 	NumTargs int
+	State    CollectorState
+	// Pools lists the pools the collector is a member of, in name order
+	Pools []string
 }
 
 // Label to display on the http server
@@ -43,34 +55,104 @@ type TargetItem struct {
 	CollectorPtr *Collector
 }
 
+// JobLabel is added to every target group served by /collectors/<name>/targets so a collector can tell the jobs apart
+const JobLabel model.LabelName = "__meta_loadbalancer_job"
+
 type DisplayCache struct {
-	DisplayJobs          map[string](map[string][]lbdiscovery.TargetGroup)
-	DisplayCollectorJson map[string](map[string]CollectorJson)
-	DisplayJobMapping    map[string]LinkLabel
-	DisplayTargetMapping map[string][]lbdiscovery.TargetGroup
+	DisplayJobs             map[string](map[string][]lbdiscovery.TargetGroup)
+	DisplayCollectorJson    map[string](map[string]CollectorJson)
+	DisplayJobMapping       map[string]LinkLabel
+	DisplayTargetMapping    map[string][]lbdiscovery.TargetGroup
+	DisplayCollectorTargets map[string][]lbdiscovery.TargetGroup
+	// Generation is bumped every time an assignment changes, the index maps hold the generation of the last change
+	Generation     uint64
+	JobIndex       map[string]uint64
+	CollectorIndex map[string]uint64
+	TargetIndex    map[string]uint64
+	// ETags holds a content hash of every response, keyed by the link it is served at
+	ETags map[string]string
 }
 
 type LoadBalancer struct {
+	sync.RWMutex
 	TargetSet     map[string]lbdiscovery.TargetData
 	TargetMap     map[string]lbdiscovery.TargetData
 	CollectorMap  map[string]*Collector
 	TargetItemMap map[string]*TargetItem
 	Cache         DisplayCache
 	NextCol       Next
+	Pins          []config.Pin
+	PinConflicts  []PinConflict
+	Events        *EventLog
+	Dropped       []DroppedTarget
+	Refreshes     Refreshes
+	// Pools binds jobs to pools, the jobs bound to none are allocated within the default pool
+	Pools []config.CollectorPool
+	// DefaultAllocation applies to the jobs without an allocation of their own in Allocation
+	DefaultAllocation config.AllocationConfig
+	Allocation        map[string]config.AllocationConfig
+	// allocators holds an allocator per job
+	allocators  map[string]Allocator
+	assigned    map[string]assignment
+	moveReasons map[string]string
+	changed     chan struct{}
+	logger      log.Logger
+	// syncLeader is the leader whose assignment was last applied through ApplySync
+	syncLeader string
 }
 
 // Basic implementation of least connection algorithm - can be enhance or replaced by another delegation algorithm
+// Collectors are visited in name order and ties go to the lexicographically smallest name,
+// so the same inputs always produce the same assignment
+// Cordoned and draining collectors are skipped unless no other collector is available
 func (lb *LoadBalancer) SetNextCollector() {
-	for _, v := range lb.CollectorMap {
-		if v.NumTargs < lb.NextCol.NextCollector.NumTargs {
-			lb.NextCol.NextCollector = v
+	if next := lb.leastLoaded(true); next != nil {
+		lb.NextCol.NextCollector = next
+	} else if next := lb.leastLoaded(false); next != nil {
+		lb.NextCol.NextCollector = next
+	}
+}
+
+// leastLoaded returns the collector with the fewest targets, optionally only considering schedulable collectors
+func (lb *LoadBalancer) leastLoaded(schedulableOnly bool) *Collector {
+	var next *Collector
+	for _, name := range lb.collectorNames() {
+		v := lb.CollectorMap[name]
+		if schedulableOnly && !v.Schedulable() {
+			continue
+		}
+		if next == nil || v.NumTargs < next.NumTargs {
+			next = v
 		}
 	}
+	return next
+}
+
+// collectorNames returns the keys of CollectorMap in sorted order
+func (lb *LoadBalancer) collectorNames() []string {
+	names := make([]string, 0, len(lb.CollectorMap))
+	for name := range lb.CollectorMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetSetKeys returns the keys of TargetSet in sorted order
+func (lb *LoadBalancer) targetSetKeys() []string {
+	keys := make([]string, 0, len(lb.TargetSet))
+	for k := range lb.TargetSet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // Initlialize the set of targets which will be used to compare the targets in use by the collector instances
 // This function will periodically be called when changes are made in the target discovery
 func (lb *LoadBalancer) UpdateTargetSet(targetList []lbdiscovery.TargetData) {
+	lb.Lock()
+	defer lb.Unlock()
 	// Dump old data
 	for k := range lb.TargetSet {
 		delete(lb.TargetSet, k)
@@ -78,19 +160,24 @@ func (lb *LoadBalancer) UpdateTargetSet(targetList []lbdiscovery.TargetData) {
 
 	// Set new data
 	for _, i := range targetList {
-		lb.TargetSet[i.JobName+i.Target] = i
+		if i.DropReason == "" {
+			lb.TargetSet[i.JobName+i.Target] = i
+		}
 	}
+	lb.Dropped = droppedTargets(targetList)
+	lb.Refreshes.TargetsUpdated = time.Now()
 }
 
-// Initalize our set of collectors with key=collectorName, value=Collector object
+// Initalize our set of collectors with key=collectorName, value=Collector object, all of them in the default pool
 // Collector instances are stable. Once initiated & allocated, these should not change. Only their jobs will change
 func (lb *LoadBalancer) InitializeCollectors(collectors []string) {
 	if len(collectors) == 0 {
-		log.Fatal("no collector instances present")
+		level.Error(lb.logger).Log("msg", "no collector instances present")
+		os.Exit(1)
 	}
 
 	for _, i := range collectors {
-		collector := Collector{Name: i, NumTargs: 0}
+		collector := Collector{Name: i, NumTargs: 0, Pools: []string{config.DefaultPool}}
 		lb.CollectorMap[i] = &collector
 	}
 	lb.NextCol.NextCollector = lb.CollectorMap[collectors[0]]
@@ -108,16 +195,30 @@ func (lb *LoadBalancer) RemoveOutdatedTargets() {
 }
 
 //Add jobs that were added into our struct
+// Pinned targets go to their pinned collector, the rest are handed to the allocator of their job
+// Targets of a pool without collectors are left out until one joins
 func (lb *LoadBalancer) AddUpdatedTargets() {
-	for k, v := range lb.TargetSet {
+	unassigned := map[string]int{}
+	for _, k := range lb.targetSetKeys() {
+		v := lb.TargetSet[k]
 		if _, ok := lb.TargetItemMap[k]; !ok {
-			lb.SetNextCollector()
+			col := lb.pinnedCollector(v)
+			if col == nil {
+				col = lb.allocate(v)
+			}
+			if col == nil {
+				unassigned[lb.jobPool(v.JobName)]++
+				continue
+			}
 			lb.TargetMap[k] = v
-			targetItem := TargetItem{JobName: v.JobName, Link: LinkLabel{"/jobs/" + v.JobName + "/targets"}, TargetUrl: v.Target, Label: v.Labels, CollectorPtr: lb.NextCol.NextCollector}
-			lb.NextCol.NextCollector.NumTargs++
+			targetItem := TargetItem{JobName: v.JobName, Link: LinkLabel{"/jobs/" + v.JobName + "/targets"}, TargetUrl: v.Target, Label: v.Labels, CollectorPtr: col}
+			col.NumTargs++
 			lb.TargetItemMap[v.JobName+v.Target] = &targetItem
 		}
 	}
+	for pool, n := range unassigned {
+		level.Warn(lb.logger).Log("msg", "no collector in pool, targets left unassigned", "pool", pool, "targets", n)
+	}
 }
 
 func (lb *LoadBalancer) GenerateCache() {
@@ -145,17 +246,23 @@ func (lb *LoadBalancer) GenerateCache() {
 				labelSet[targetItem.TargetUrl] = targetItem.Label
 				targetArr = append(targetArr, targetItem.TargetUrl)
 			}
+			sort.Strings(targetArr)
 			targetGroupList = append(targetGroupList, lbdiscovery.TargetGroup{Targets: targetArr, Labels: labelSet[targetArr[0]]})
 
 		}
+		// Keep the display order stable so identical assignments produce identical responses
+		sort.Slice(targetGroupList, func(i, j int) bool {
+			return targetGroupList[i].Labels.String() < targetGroupList[j].Labels.String()
+		})
 		lb.Cache.DisplayJobs[v.JobName][v.CollectorPtr.Name] = targetGroupList
 	}
 }
 
-// TODO: Add mutex
 // UpdateCache gets called whenever RefreshJobs gets called
 func (lb *LoadBalancer) UpdateCache() {
+	prev := lb.Cache
 	lb.GenerateCache() // Create cached structure
+	lb.updateIndex(prev)
 	// Create the display maps
 	lb.Cache.DisplayTargetMapping = make(map[string][]lbdiscovery.TargetGroup)
 	lb.Cache.DisplayJobMapping = make(map[string]LinkLabel)
@@ -177,25 +284,65 @@ func (lb *LoadBalancer) UpdateCache() {
 		}
 	}
 
+	// Targets of every job per collector, jobs in name order
+	lb.Cache.DisplayCollectorTargets = make(map[string][]lbdiscovery.TargetGroup)
+	jobNames := make([]string, 0, len(lb.Cache.DisplayJobs))
+	for k := range lb.Cache.DisplayJobs {
+		jobNames = append(jobNames, k)
+	}
+	sort.Strings(jobNames)
+	for _, k := range jobNames {
+		for kk, vv := range lb.Cache.DisplayJobs[k] {
+			for _, tg := range vv {
+				labels := tg.Labels.Clone()
+				if labels == nil {
+					labels = model.LabelSet{}
+				}
+				labels[JobLabel] = model.LabelValue(k)
+				lb.Cache.DisplayCollectorTargets[kk] = append(lb.Cache.DisplayCollectorTargets[kk], lbdiscovery.TargetGroup{Targets: tg.Targets, Labels: labels})
+			}
+		}
+	}
+
+	lb.updateETags()
 }
 
 // TODO: Add boolean flags to determine if any changes were made that should trigger RefreshJobs
 // RefreshJobs is a function that is called periodically - this will create a cached structure to hold data for consistency
 // when collectors perform GET operations
 func (lb *LoadBalancer) RefreshJobs() {
+	lb.Lock()
+	defer lb.Unlock()
 	lb.RemoveOutdatedTargets()
 	lb.AddUpdatedTargets()
+	lb.ApplyPins()
 	lb.UpdateCache()
+	lb.Refreshes.JobsRefreshed = time.Now()
 }
 
 // UpdateCache updates the DisplayMap so that mapping is consistent
 
-func Init() *LoadBalancer {
+func Init(logger log.Logger) *LoadBalancer {
 	lb := LoadBalancer{
-		TargetSet:     make(map[string]lbdiscovery.TargetData),
-		TargetMap:     make(map[string]lbdiscovery.TargetData),
-		CollectorMap:  make(map[string]*Collector),
-		TargetItemMap: make(map[string]*TargetItem),
-		NextCol:       Next{}}
+		TargetSet:         make(map[string]lbdiscovery.TargetData),
+		TargetMap:         make(map[string]lbdiscovery.TargetData),
+		CollectorMap:      make(map[string]*Collector),
+		TargetItemMap:     make(map[string]*TargetItem),
+		NextCol:           Next{},
+		PinConflicts:      []PinConflict{},
+		Dropped:           []DroppedTarget{},
+		Events:            NewEventLog(defaultEventLogSize),
+		DefaultAllocation: config.AllocationConfig{Mode: config.ModeLeastConnection},
+		Allocation:        make(map[string]config.AllocationConfig),
+		allocators:        make(map[string]Allocator),
+		assigned:          make(map[string]assignment),
+		moveReasons:       make(map[string]string),
+		changed:           make(chan struct{}),
+		logger:            logger,
+		Cache: DisplayCache{
+			Generation:     1,
+			JobIndex:       make(map[string]uint64),
+			CollectorIndex: make(map[string]uint64),
+			TargetIndex:    make(map[string]uint64)}}
 	return &lb
 }
@@ -3,8 +3,9 @@ package mode_test
 import (
 	"testing"
 
+	"github.com/go-kit/log"
 	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
-	"github.com/http-sd-loadbalancer/loadbalancer"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -12,7 +13,7 @@ import (
 // Tests least connection - The expected collector after running SetNextCollector should be the collecter with the least amount of workload
 func TestSettingNextCollector(t *testing.T) {
 	// prepare
-	lb := loadbalancer.Init()
+	lb := loadbalancer.Init(log.NewNopLogger())
 	defaultCol := loadbalancer.Collector{Name: "default-col", NumTargs: 1}
 	maxCol := loadbalancer.Collector{Name: "max-col", NumTargs: 2}
 	leastCol := loadbalancer.Collector{Name: "least-col", NumTargs: 0}
@@ -30,7 +31,7 @@ func TestSettingNextCollector(t *testing.T) {
 func TestInitializingCollectors(t *testing.T) {
 	// prepare
 	cols := []string{"col-1", "col-2", "col-3"}
-	lb := loadbalancer.Init()
+	lb := loadbalancer.Init(log.NewNopLogger())
 
 	// test
 	lb.InitializeCollectors(cols)
@@ -44,7 +45,7 @@ func TestInitializingCollectors(t *testing.T) {
 
 func TestAddingAndRemovingTargetFlow(t *testing.T) {
 	// prepare lb with initial targets and collectors
-	lb := loadbalancer.Init()
+	lb := loadbalancer.Init(log.NewNopLogger())
 	cols := []string{"col-1", "col-2", "col-3"}
 	initTargets := []string{"targ:1000", "targ:1001", "targ:1002", "targ:1003", "targ:1004", "targ:1005"}
 	lb.InitializeCollectors(cols)
@@ -82,3 +83,51 @@ func TestAddingAndRemovingTargetFlow(t *testing.T) {
 		assert.True(t, ok)
 	}
 }
+
+// Tests that ties between collectors with the same workload are broken by collector name
+func TestSettingNextCollectorTieBreak(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-c", "col-a", "col-b"})
+	lb.CollectorMap["col-a"].NumTargs = 2
+
+	// test
+	lb.SetNextCollector()
+
+	// verify
+	assert.Equal(t, "col-b", lb.NextCol.NextCollector.Name)
+}
+
+// Tests that two load balancers given the same input produce identical assignments
+func TestDeterministicAssignment(t *testing.T) {
+	// prepare
+	cols := []string{"col-3", "col-1", "col-2"}
+	initTargets := []string{"targ:1005", "targ:1001", "targ:1003", "targ:1000", "targ:1004", "targ:1002", "targ:1006"}
+	var targetList []lbdiscovery.TargetData
+	for _, i := range initTargets {
+		targetList = append(targetList, lbdiscovery.TargetData{JobName: "sample-name", Target: i, Labels: model.LabelSet{}})
+	}
+	expected := map[string]string{
+		"targ:1000": "col-1",
+		"targ:1001": "col-2",
+		"targ:1002": "col-3",
+		"targ:1003": "col-1",
+		"targ:1004": "col-2",
+		"targ:1005": "col-3",
+		"targ:1006": "col-1",
+	}
+
+	for run := 0; run < 5; run++ {
+		lb := loadbalancer.Init(log.NewNopLogger())
+		lb.InitializeCollectors(cols)
+
+		// test
+		lb.UpdateTargetSet(targetList)
+		lb.RefreshJobs()
+
+		// verify
+		for target, col := range expected {
+			assert.Equal(t, col, lb.TargetItemMap["sample-name"+target].CollectorPtr.Name)
+		}
+	}
+}
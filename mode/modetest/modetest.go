@@ -0,0 +1,22 @@
+// Package modetest builds load balancers for the tests of the packages serving them
+package modetest
+
+import (
+	"github.com/go-kit/log"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+)
+
+// New returns a load balancer that allocated the targets to the collectors
+func New(collectors []string, targets ...lbdiscovery.TargetData) *loadbalancer.LoadBalancer {
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors(collectors)
+	return Allocate(lb, targets...)
+}
+
+// Allocate replaces the targets of the load balancer and allocates them
+func Allocate(lb *loadbalancer.LoadBalancer, targets ...lbdiscovery.TargetData) *loadbalancer.LoadBalancer {
+	lb.UpdateTargetSet(targets)
+	lb.RefreshJobs()
+	return lb
+}
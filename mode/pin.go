@@ -0,0 +1,151 @@
+package mode
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+)
+
+var (
+	// ErrPinNotFound represents the removal of a pin that does not exist.
+	ErrPinNotFound = errors.New("pin not found")
+)
+
+// PinConflict reports a pin that could not be honored, along with the targets it matched
+type PinConflict struct {
+	Pin     config.Pin `json:"pin"`
+	Reason  string     `json:"reason"`
+	Targets []string   `json:"targets"`
+}
+
+// PinStatus is the display form of the configured pins on the http server
+type PinStatus struct {
+	Pins      []config.Pin  `json:"pins"`
+	Conflicts []PinConflict `json:"conflicts"`
+}
+
+// SetPins replaces all pins and moves the already assigned targets accordingly
+func (lb *LoadBalancer) SetPins(pins []config.Pin) error {
+	for _, pin := range pins {
+		if err := pin.Validate(); err != nil {
+			return err
+		}
+	}
+	lb.Lock()
+	defer lb.Unlock()
+	lb.Pins = append([]config.Pin{}, pins...)
+	lb.ApplyPins()
+	lb.UpdateCache()
+	return nil
+}
+
+// AddPin adds a pin, replacing any pin with the same pattern
+func (lb *LoadBalancer) AddPin(pin config.Pin) error {
+	if err := pin.Validate(); err != nil {
+		return err
+	}
+	lb.Lock()
+	defer lb.Unlock()
+	replaced := false
+	for i := range lb.Pins {
+		if lb.Pins[i].Target == pin.Target {
+			lb.Pins[i] = pin
+			replaced = true
+		}
+	}
+	if !replaced {
+		lb.Pins = append(lb.Pins, pin)
+	}
+	lb.ApplyPins()
+	lb.UpdateCache()
+	return nil
+}
+
+// RemovePin removes the pin with the given pattern; targets stay where they are until they are rebalanced
+func (lb *LoadBalancer) RemovePin(pattern string) error {
+	lb.Lock()
+	defer lb.Unlock()
+	for i := range lb.Pins {
+		if lb.Pins[i].Target == pattern {
+			lb.Pins = append(lb.Pins[:i], lb.Pins[i+1:]...)
+			lb.ApplyPins()
+			lb.UpdateCache()
+			return nil
+		}
+	}
+	return ErrPinNotFound
+}
+
+// PinStatus returns the configured pins and the conflicts found the last time they were applied
+func (lb *LoadBalancer) PinStatus() PinStatus {
+	lb.RLock()
+	defer lb.RUnlock()
+	return PinStatus{Pins: append([]config.Pin{}, lb.Pins...), Conflicts: append([]PinConflict{}, lb.PinConflicts...)}
+}
+
+// pinnedCollector returns the collector the target is pinned to, or nil if it is not pinned or the pin can't be honored
+// The first matching pin wins
+func (lb *LoadBalancer) pinnedCollector(target lbdiscovery.TargetData) *Collector {
+	for _, pin := range lb.Pins {
+		if !pin.Matches(target.JobName, target.Target) {
+			continue
+		}
+		col, ok := lb.CollectorMap[pin.Collector]
+		if !ok || col.State == StateDraining {
+			return nil
+		}
+		return col
+	}
+	return nil
+}
+
+// ApplyPins moves assigned targets onto their pinned collectors and records the pins that can't be honored
+func (lb *LoadBalancer) ApplyPins() {
+	keys := make([]string, 0, len(lb.TargetItemMap))
+	for k := range lb.TargetItemMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	conflicts := make(map[int]*PinConflict)
+	for _, k := range keys {
+		item := lb.TargetItemMap[k]
+		for i, pin := range lb.Pins {
+			if !pin.Matches(item.JobName, item.TargetUrl) {
+				continue
+			}
+			col, ok := lb.CollectorMap[pin.Collector]
+			switch {
+			case !ok:
+				lb.addPinConflict(conflicts, i, "collector not found", item)
+			case col.State == StateDraining:
+				lb.addPinConflict(conflicts, i, "collector draining", item)
+			case item.CollectorPtr != col:
+				item.CollectorPtr.NumTargs--
+				item.CollectorPtr = col
+				col.NumTargs++
+				lb.moveReasons[k] = ReasonPin
+			}
+			break
+		}
+	}
+
+	lb.PinConflicts = []PinConflict{}
+	for i, pin := range lb.Pins {
+		if _, ok := lb.CollectorMap[pin.Collector]; !ok && conflicts[i] == nil {
+			conflicts[i] = &PinConflict{Pin: pin, Reason: "collector not found", Targets: []string{}}
+		}
+		if conflicts[i] != nil {
+			lb.PinConflicts = append(lb.PinConflicts, *conflicts[i])
+		}
+	}
+}
+
+func (lb *LoadBalancer) addPinConflict(conflicts map[int]*PinConflict, i int, reason string, item *TargetItem) {
+	if conflicts[i] == nil {
+		conflicts[i] = &PinConflict{Pin: lb.Pins[i], Reason: reason, Targets: []string{}}
+	}
+	conflicts[i].Targets = append(conflicts[i].Targets, item.JobName+"/"+item.TargetUrl)
+}
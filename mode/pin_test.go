@@ -0,0 +1,94 @@
+package mode_test
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/http-sd-loadbalancer/config"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that pinned targets are assigned to their collector before the allocator runs
+func TestPinnedTargetsAssignment(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2", "col-3"})
+	err := lb.SetPins([]config.Pin{{Target: "sample-name/targ:100*", Collector: "col-3"}})
+	assert.NoError(t, err)
+
+	// test
+	lb = initLoadBalancerWith(lb, []string{"targ:1000", "targ:1001", "targ:1002", "targ:2000", "targ:2001"})
+
+	// verify
+	for _, target := range []string{"targ:1000", "targ:1001", "targ:1002"} {
+		assert.Equal(t, "col-3", lb.TargetItemMap["sample-name"+target].CollectorPtr.Name)
+	}
+	assert.Equal(t, "col-1", lb.TargetItemMap["sample-nametarg:2000"].CollectorPtr.Name)
+	assert.Equal(t, "col-2", lb.TargetItemMap["sample-nametarg:2001"].CollectorPtr.Name)
+	assert.Empty(t, lb.PinStatus().Conflicts)
+}
+
+// Tests that pinned targets don't count towards the allocator's decisions for the remaining targets
+func TestPinnedTargetsKeepBalance(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2", "col-3"})
+	err := lb.SetPins([]config.Pin{{Target: "sample-name/targ:1000", Collector: "col-3"}})
+	assert.NoError(t, err)
+
+	// test
+	lb = initLoadBalancerWith(lb, []string{"targ:1000", "targ:2000", "targ:2001"})
+
+	// verify
+	assert.Equal(t, 1, lb.CollectorMap["col-1"].NumTargs)
+	assert.Equal(t, 1, lb.CollectorMap["col-2"].NumTargs)
+	assert.Equal(t, 1, lb.CollectorMap["col-3"].NumTargs)
+}
+
+// Tests that adding a pin at runtime moves already assigned targets
+func TestAddPinMovesTargets(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+
+	// test
+	err := lb.AddPin(config.Pin{Target: "sample-name/*", Collector: "col-2"})
+
+	// verify
+	assert.NoError(t, err)
+	assert.Equal(t, "col-2", lb.TargetItemMap["sample-nametarg:1000"].CollectorPtr.Name)
+	assert.Equal(t, "col-2", lb.TargetItemMap["sample-nametarg:1001"].CollectorPtr.Name)
+	assert.Equal(t, 0, lb.CollectorMap["col-1"].NumTargs)
+	assert.Equal(t, 2, lb.CollectorMap["col-2"].NumTargs)
+	assert.Len(t, lb.Cache.DisplayTargetMapping["sample-namecol-2"][0].Targets, 2)
+
+	// test removing the pin
+	assert.NoError(t, lb.RemovePin("sample-name/*"))
+	assert.ErrorIs(t, lb.RemovePin("sample-name/*"), loadbalancer.ErrPinNotFound)
+	assert.Empty(t, lb.PinStatus().Pins)
+}
+
+// Tests that pins to missing collectors are reported and fall back to the allocator
+func TestPinConflicts(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1", "col-2"})
+	err := lb.SetPins([]config.Pin{
+		{Target: "sample-name/targ:1000", Collector: "col-9"},
+		{Target: "other-job/*", Collector: "col-8"},
+	})
+	assert.NoError(t, err)
+
+	// test
+	lb = initLoadBalancerWith(lb, []string{"targ:1000", "targ:1001"})
+
+	// verify
+	assert.Equal(t, "col-1", lb.TargetItemMap["sample-nametarg:1000"].CollectorPtr.Name)
+	conflicts := lb.PinStatus().Conflicts
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, "col-9", conflicts[0].Pin.Collector)
+	assert.Equal(t, "collector not found", conflicts[0].Reason)
+	assert.Equal(t, []string{"sample-name/targ:1000"}, conflicts[0].Targets)
+	assert.Equal(t, "col-8", conflicts[1].Pin.Collector)
+	assert.Empty(t, conflicts[1].Targets)
+}
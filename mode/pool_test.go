@@ -0,0 +1,127 @@
+package mode_test
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/http-sd-loadbalancer/config"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/stretchr/testify/assert"
+)
+
+func initPools(t *testing.T, pools map[string][]string) *loadbalancer.LoadBalancer {
+	t.Helper()
+	lb := loadbalancer.Init(log.NewNopLogger())
+	assert.NoError(t, lb.SetPools([]config.CollectorPool{{Name: "infra", Jobs: []string{"node"}}}))
+	assert.NoError(t, lb.UpdatePools(pools))
+	lb.UpdateTargetSet(append(jobTargets("node", 4), jobTargets("checkout", 4)...))
+	lb.RefreshJobs()
+	return lb
+}
+
+// Tests that the targets of a job are only assigned to the collectors of its pool
+func TestPoolAllocation(t *testing.T) {
+	// prepare
+	lb := initPools(t, map[string][]string{
+		config.DefaultPool: {"col-1", "col-2"},
+		"infra":            {"col-3"},
+	})
+
+	// verify
+	for target, col := range assignments(lb, "node") {
+		assert.Equal(t, "col-3", col, target)
+	}
+	for target, col := range assignments(lb, "checkout") {
+		assert.NotEqual(t, "col-3", col, target)
+	}
+	status, err := lb.CollectorStatus("col-3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"infra"}, status.Pools)
+}
+
+// Tests that targets follow their pool when a collector changes pools
+func TestPoolMembershipChange(t *testing.T) {
+	// prepare
+	lb := initPools(t, map[string][]string{
+		config.DefaultPool: {"col-1", "col-2"},
+		"infra":            {"col-2", "col-3"},
+	})
+
+	// test
+	err := lb.UpdatePools(map[string][]string{
+		config.DefaultPool: {"col-1", "col-2", "col-3"},
+		"infra":            {"col-2"},
+	})
+
+	// verify
+	assert.NoError(t, err)
+	for target, col := range assignments(lb, "node") {
+		assert.Equal(t, "col-2", col, target)
+	}
+	assert.Equal(t, 4+countOn(lb, "checkout", "col-2"), lb.CollectorMap["col-2"].NumTargs)
+	assert.Equal(t, countOn(lb, "checkout", "col-3"), lb.CollectorMap["col-3"].NumTargs)
+}
+
+// Tests that the targets of a pool without collectors wait for one to join
+func TestEmptyPool(t *testing.T) {
+	// prepare
+	lb := initPools(t, map[string][]string{
+		config.DefaultPool: {"col-1"},
+		"infra":            {"col-2"},
+	})
+
+	// test
+	assert.NoError(t, lb.UpdatePools(map[string][]string{config.DefaultPool: {"col-1"}}))
+
+	// verify
+	assert.Empty(t, assignments(lb, "node"))
+	assert.Len(t, assignments(lb, "checkout"), 4)
+
+	// test that the targets are assigned once the pool has a collector again
+	assert.NoError(t, lb.UpdatePools(map[string][]string{config.DefaultPool: {"col-1"}, "infra": {"col-3"}}))
+	lb.RefreshJobs()
+
+	// verify
+	assert.Len(t, assignments(lb, "node"), 4)
+	assert.Equal(t, 4, lb.CollectorMap["col-3"].NumTargs)
+}
+
+// Tests that a collector can't be drained when a pool of its targets has no other collector
+func TestDrainWithinPool(t *testing.T) {
+	// prepare
+	lb := initPools(t, map[string][]string{
+		config.DefaultPool: {"col-1", "col-2"},
+		"infra":            {"col-3"},
+	})
+
+	// test
+	_, err := lb.Drain("col-3")
+
+	// verify
+	assert.ErrorIs(t, err, loadbalancer.ErrNoSchedulableCollector)
+	assert.Equal(t, loadbalancer.StateActive, lb.CollectorMap["col-3"].State)
+	for target, col := range assignments(lb, "node") {
+		assert.Equal(t, "col-3", col, target)
+	}
+
+	// test that the collector is drained once the pool has another collector
+	assert.NoError(t, lb.UpdatePools(map[string][]string{config.DefaultPool: {"col-1", "col-2"}, "infra": {"col-3", "col-4"}}))
+	status, err := lb.Drain("col-3")
+
+	// verify
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status.NumTargets)
+	for target, col := range assignments(lb, "node") {
+		assert.Equal(t, "col-4", col, target)
+	}
+}
+
+func countOn(lb *loadbalancer.LoadBalancer, job, col string) int {
+	n := 0
+	for _, c := range assignments(lb, job) {
+		if c == col {
+			n++
+		}
+	}
+	return n
+}
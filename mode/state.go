@@ -0,0 +1,123 @@
+package mode
+
+import (
+	"sort"
+	"time"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DropReasonDuplicate is recorded for a target discovered again under the same job; the last one wins
+	DropReasonDuplicate = "duplicate"
+	// DropReasonRelabel is recorded for a target the relabel_configs of its job drop
+	DropReasonRelabel = lbdiscovery.DropReasonRelabel
+)
+
+// DroppedTarget is a discovered target that did not make it into the target set
+type DroppedTarget struct {
+	JobName string         `json:"job"`
+	Target  string         `json:"target"`
+	Labels  model.LabelSet `json:"labels"`
+	Reason  string         `json:"reason"`
+}
+
+// Refreshes holds when the load balancer inputs last changed
+type Refreshes struct {
+	TargetsUpdated    time.Time `json:"targets_updated"`
+	JobsRefreshed     time.Time `json:"jobs_refreshed"`
+	CollectorsUpdated time.Time `json:"collectors_updated"`
+	Synced            time.Time `json:"synced"`
+}
+
+// StateTarget is the debug form of a TargetItem
+type StateTarget struct {
+	JobName   string         `json:"job"`
+	Target    string         `json:"target"`
+	Labels    model.LabelSet `json:"labels"`
+	Collector string         `json:"collector"`
+}
+
+// StateCollector is the debug form of a Collector
+type StateCollector struct {
+	Name     string         `json:"name"`
+	NumTargs int            `json:"targets"`
+	State    CollectorState `json:"state"`
+	Pools    []string       `json:"pools"`
+	LastSeen time.Time      `json:"last_seen"`
+}
+
+// State is a copy of the internal maps of the load balancer, keyed as they are internally
+type State struct {
+	Generation    uint64                            `json:"generation"`
+	TargetSet     map[string]lbdiscovery.TargetData `json:"target_set"`
+	TargetMap     map[string]lbdiscovery.TargetData `json:"target_map"`
+	TargetItemMap map[string]StateTarget            `json:"target_item_map"`
+	CollectorMap  map[string]StateCollector         `json:"collector_map"`
+	Pins          []config.Pin                      `json:"pins"`
+	PinConflicts  []PinConflict                     `json:"pin_conflicts"`
+	Dropped       []DroppedTarget                   `json:"dropped"`
+	LastEventID   uint64                            `json:"last_event_id"`
+	Refreshes     Refreshes                         `json:"refreshes"`
+	SyncLeader    string                            `json:"sync_leader,omitempty"`
+}
+
+// State returns a snapshot of the internal state, safe to use once the lock is released
+func (lb *LoadBalancer) State() State {
+	lb.RLock()
+	defer lb.RUnlock()
+	state := State{
+		Generation:    lb.Cache.Generation,
+		TargetSet:     make(map[string]lbdiscovery.TargetData, len(lb.TargetSet)),
+		TargetMap:     make(map[string]lbdiscovery.TargetData, len(lb.TargetMap)),
+		TargetItemMap: make(map[string]StateTarget, len(lb.TargetItemMap)),
+		CollectorMap:  make(map[string]StateCollector, len(lb.CollectorMap)),
+		Pins:          append([]config.Pin{}, lb.Pins...),
+		PinConflicts:  append([]PinConflict{}, lb.PinConflicts...),
+		Dropped:       append([]DroppedTarget{}, lb.Dropped...),
+		LastEventID:   lb.Events.LastID(),
+		Refreshes:     lb.Refreshes,
+		SyncLeader:    lb.syncLeader,
+	}
+	for k, v := range lb.TargetSet {
+		state.TargetSet[k] = v
+	}
+	for k, v := range lb.TargetMap {
+		state.TargetMap[k] = v
+	}
+	for k, v := range lb.TargetItemMap {
+		state.TargetItemMap[k] = StateTarget{JobName: v.JobName, Target: v.TargetUrl, Labels: v.Label, Collector: v.CollectorPtr.Name}
+	}
+	for k, v := range lb.CollectorMap {
+		state.CollectorMap[k] = StateCollector{Name: v.Name, NumTargs: v.NumTargs, State: v.State, Pools: append([]string{}, v.Pools...), LastSeen: v.LastSeen()}
+	}
+	return state
+}
+
+// droppedTargets returns the targets of the list that discovery dropped and the ones that are shadowed by a later
+// target with the same key
+func droppedTargets(targetList []lbdiscovery.TargetData) []DroppedTarget {
+	last := make(map[string]int, len(targetList))
+	for i, t := range targetList {
+		if t.DropReason == "" {
+			last[t.JobName+t.Target] = i
+		}
+	}
+	dropped := []DroppedTarget{}
+	for i, t := range targetList {
+		if t.DropReason != "" {
+			dropped = append(dropped, DroppedTarget{JobName: t.JobName, Target: t.Target, Labels: t.Labels, Reason: t.DropReason})
+		} else if last[t.JobName+t.Target] != i {
+			dropped = append(dropped, DroppedTarget{JobName: t.JobName, Target: t.Target, Labels: t.Labels, Reason: DropReasonDuplicate})
+		}
+	}
+	sort.SliceStable(dropped, func(i, j int) bool {
+		if dropped[i].JobName != dropped[j].JobName {
+			return dropped[i].JobName < dropped[j].JobName
+		}
+		return dropped[i].Target < dropped[j].Target
+	})
+	return dropped
+}
@@ -0,0 +1,75 @@
+package mode_test
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that the state snapshot mirrors the internal maps and is not affected by later changes
+func TestState(t *testing.T) {
+	// prepare
+	lb := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+
+	// test
+	state := lb.State()
+	initLoadBalancerWith(lb, []string{"targ:1002"})
+
+	// verify
+	assert.Len(t, state.TargetSet, 2)
+	assert.Len(t, state.TargetMap, 2)
+	assert.Equal(t, loadbalancer.StateTarget{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}, Collector: "col-1"}, state.TargetItemMap["sample-nametarg:1000"])
+	assert.Equal(t, 1, state.CollectorMap["col-2"].NumTargs)
+	assert.Empty(t, state.Dropped)
+	assert.False(t, state.Refreshes.TargetsUpdated.IsZero())
+	assert.False(t, state.Refreshes.JobsRefreshed.IsZero())
+	assert.True(t, state.Refreshes.CollectorsUpdated.IsZero())
+}
+
+// Tests that a target discovered twice under the same job is reported as dropped once
+func TestDroppedDuplicateTargets(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1"})
+
+	// test
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{"source": "file"}},
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{"source": "static"}},
+		{JobName: "other-name", Target: "targ:1000", Labels: model.LabelSet{}},
+	})
+	lb.RefreshJobs()
+
+	// verify
+	state := lb.State()
+	assert.Equal(t, []loadbalancer.DroppedTarget{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{"source": "file"}, Reason: loadbalancer.DropReasonDuplicate},
+	}, state.Dropped)
+	assert.Equal(t, model.LabelValue("static"), state.TargetItemMap["sample-nametarg:1000"].Labels["source"])
+}
+
+// Tests that a target dropped by relabeling is reported with its reason and not allocated
+func TestDroppedRelabeledTargets(t *testing.T) {
+	// prepare
+	lb := loadbalancer.Init(log.NewNopLogger())
+	lb.InitializeCollectors([]string{"col-1"})
+
+	// test
+	lb.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:9999", Labels: model.LabelSet{}, DropReason: lbdiscovery.DropReasonRelabel},
+	})
+	lb.RefreshJobs()
+
+	// verify
+	state := lb.State()
+	assert.Equal(t, []loadbalancer.DroppedTarget{
+		{JobName: "sample-name", Target: "targ:9999", Labels: model.LabelSet{}, Reason: loadbalancer.DropReasonRelabel},
+	}, state.Dropped)
+	assert.Len(t, state.TargetItemMap, 1)
+	assert.Contains(t, state.TargetItemMap, "sample-nametarg:1000")
+}
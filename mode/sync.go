@@ -0,0 +1,216 @@
+package mode
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	// ErrSplitBrain represents two leaders publishing different assignments under the same generation.
+	ErrSplitBrain = errors.New("split brain: another leader published a different assignment under the same generation")
+	// ErrStaleGeneration represents a sync message older than the assignment already applied.
+	ErrStaleGeneration = errors.New("sync message is older than the applied assignment")
+	// ErrGenerationGap represents a delta that does not apply on top of the assignment already applied.
+	ErrGenerationGap = errors.New("sync delta does not follow the applied assignment")
+)
+
+// SyncAssignment is a target and the collector it is placed on, as replicated to followers
+type SyncAssignment struct {
+	JobName   string         `json:"job"`
+	Target    string         `json:"target"`
+	Labels    model.LabelSet `json:"labels"`
+	Collector string         `json:"collector"`
+}
+
+// SyncCollector is a collector as replicated to followers
+type SyncCollector struct {
+	Name  string         `json:"name"`
+	State CollectorState `json:"state"`
+	Pools []string       `json:"pools,omitempty"`
+}
+
+// SyncMessage carries the assignment of a leader to its followers
+// A snapshot holds every assignment; a delta holds those added, moved or relabelled and those removed since Base
+// Both carry every collector
+type SyncMessage struct {
+	Leader     string           `json:"leader"`
+	Generation uint64           `json:"generation"`
+	Base       uint64           `json:"base,omitempty"`
+	Snapshot   bool             `json:"snapshot"`
+	Collectors []SyncCollector  `json:"collectors"`
+	Assigned   []SyncAssignment `json:"assigned"`
+	Removed    []SyncAssignment `json:"removed,omitempty"`
+}
+
+// SyncSnapshot returns the whole assignment under the current generation, published by leader
+func (lb *LoadBalancer) SyncSnapshot(leader string) SyncMessage {
+	lb.RLock()
+	defer lb.RUnlock()
+	snapshot := SyncMessage{
+		Leader:     leader,
+		Generation: lb.Cache.Generation,
+		Snapshot:   true,
+		Collectors: make([]SyncCollector, 0, len(lb.CollectorMap)),
+		Assigned:   lb.syncAssignments(),
+	}
+	for _, name := range lb.collectorNames() {
+		snapshot.Collectors = append(snapshot.Collectors, SyncCollector{Name: name, State: lb.CollectorMap[name].State, Pools: lb.CollectorMap[name].Pools})
+	}
+	return snapshot
+}
+
+// NewSyncDelta compares two snapshots of the same leader and reports whether anything changed in between
+func NewSyncDelta(prev, next SyncMessage) (SyncMessage, bool) {
+	delta := SyncMessage{
+		Leader:     next.Leader,
+		Generation: next.Generation,
+		Base:       prev.Generation,
+		Collectors: next.Collectors,
+		Assigned:   []SyncAssignment{},
+	}
+	previous := make(map[string]SyncAssignment, len(prev.Assigned))
+	for _, a := range prev.Assigned {
+		previous[a.JobName+a.Target] = a
+	}
+	current := make(map[string]bool, len(next.Assigned))
+	for _, a := range next.Assigned {
+		current[a.JobName+a.Target] = true
+		if old, ok := previous[a.JobName+a.Target]; !ok || !sameAssignment(old, a) {
+			delta.Assigned = append(delta.Assigned, a)
+		}
+	}
+	for _, a := range prev.Assigned {
+		if !current[a.JobName+a.Target] {
+			delta.Removed = append(delta.Removed, a)
+		}
+	}
+	changed := len(delta.Assigned) > 0 || len(delta.Removed) > 0 || prev.Generation != next.Generation ||
+		!sameCollectors(prev.Collectors, next.Collectors)
+	return delta, changed
+}
+
+// ApplySync replaces or updates the assignment with the one of the leader and takes over its generation
+// A snapshot of another leader under the applied generation is a split brain unless it holds the same assignment,
+// which happens when a follower that replicated the previous leader takes over
+func (lb *LoadBalancer) ApplySync(m SyncMessage) error {
+	lb.Lock()
+	defer lb.Unlock()
+	if m.Generation == lb.Cache.Generation && m.Leader != lb.syncLeader {
+		if !m.Snapshot || !sameAssignments(lb.syncAssignments(), m.Assigned) {
+			return ErrSplitBrain
+		}
+	}
+	if m.Generation < lb.Cache.Generation {
+		return ErrStaleGeneration
+	}
+	if !m.Snapshot && (m.Leader != lb.syncLeader || m.Base != lb.Cache.Generation) {
+		return ErrGenerationGap
+	}
+
+	lb.applyCollectors(m.Collectors)
+	if m.Snapshot {
+		lb.TargetMap = make(map[string]lbdiscovery.TargetData, len(m.Assigned))
+		lb.TargetItemMap = make(map[string]*TargetItem, len(m.Assigned))
+	}
+	for _, a := range m.Removed {
+		delete(lb.TargetMap, a.JobName+a.Target)
+		delete(lb.TargetItemMap, a.JobName+a.Target)
+	}
+	for _, a := range m.Assigned {
+		col, ok := lb.CollectorMap[a.Collector]
+		if !ok {
+			col = &Collector{Name: a.Collector}
+			lb.CollectorMap[a.Collector] = col
+		}
+		lb.TargetMap[a.JobName+a.Target] = lbdiscovery.TargetData{JobName: a.JobName, Target: a.Target, Labels: a.Labels}
+		lb.TargetItemMap[a.JobName+a.Target] = &TargetItem{JobName: a.JobName, Link: LinkLabel{"/jobs/" + a.JobName + "/targets"}, TargetUrl: a.Target, Label: a.Labels, CollectorPtr: col}
+	}
+	for _, col := range lb.CollectorMap {
+		col.NumTargs = 0
+	}
+	for _, item := range lb.TargetItemMap {
+		item.CollectorPtr.NumTargs++
+	}
+
+	// updateIndex bumps the generation by one if anything moved, land on the one of the leader either way
+	lb.Cache.Generation = m.Generation - 1
+	lb.UpdateCache()
+	lb.Cache.Generation = m.Generation
+	lb.syncLeader = m.Leader
+	lb.Refreshes.Synced = time.Now()
+	return nil
+}
+
+// applyCollectors makes the collector map match the collectors of the leader, keeping the known ones in place
+func (lb *LoadBalancer) applyCollectors(collectors []SyncCollector) {
+	names := make(map[string]bool, len(collectors))
+	for _, c := range collectors {
+		names[c.Name] = true
+		col, ok := lb.CollectorMap[c.Name]
+		if !ok {
+			col = &Collector{Name: c.Name}
+			lb.CollectorMap[c.Name] = col
+		}
+		col.State = c.State
+		col.Pools = c.Pools
+	}
+	for name := range lb.CollectorMap {
+		if !names[name] {
+			delete(lb.CollectorMap, name)
+		}
+	}
+	if lb.NextCol.NextCollector != nil && !names[lb.NextCol.NextCollector.Name] {
+		lb.NextCol.NextCollector = nil
+	}
+}
+
+// syncAssignments returns every assignment ordered by job and target
+func (lb *LoadBalancer) syncAssignments() []SyncAssignment {
+	assignments := make([]SyncAssignment, 0, len(lb.TargetItemMap))
+	for _, item := range lb.TargetItemMap {
+		assignments = append(assignments, SyncAssignment{JobName: item.JobName, Target: item.TargetUrl, Labels: item.Label, Collector: item.CollectorPtr.Name})
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].JobName != assignments[j].JobName {
+			return assignments[i].JobName < assignments[j].JobName
+		}
+		return assignments[i].Target < assignments[j].Target
+	})
+	return assignments
+}
+
+func sameAssignment(a, b SyncAssignment) bool {
+	return a.JobName == b.JobName && a.Target == b.Target && a.Collector == b.Collector && a.Labels.Equal(b.Labels)
+}
+
+func sameAssignments(a, b []SyncAssignment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byKey := make(map[string]SyncAssignment, len(a))
+	for _, v := range a {
+		byKey[v.JobName+v.Target] = v
+	}
+	for _, v := range b {
+		if old, ok := byKey[v.JobName+v.Target]; !ok || !sameAssignment(old, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameCollectors(a, b []SyncCollector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].State != b[i].State || !samePools(a[i].Pools, b[i].Pools) {
+			return false
+		}
+	}
+	return true
+}
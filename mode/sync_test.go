@@ -0,0 +1,151 @@
+package mode_test
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests that a restarted follower catches up from a snapshot and then follows the deltas of the leader
+func TestSyncCatchUp(t *testing.T) {
+	// prepare
+	leader := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001", "targ:1002"})
+	follower := loadbalancer.Init(log.NewNopLogger())
+	snapshot := leader.SyncSnapshot("lb-0")
+
+	// test
+	assert.NoError(t, follower.ApplySync(snapshot))
+	_, err := leader.Drain("col-1")
+	assert.NoError(t, err)
+	delta, changed := loadbalancer.NewSyncDelta(snapshot, leader.SyncSnapshot("lb-0"))
+	assert.True(t, changed)
+	assert.NoError(t, follower.ApplySync(delta))
+	initLoadBalancerWith(leader, []string{"targ:1000", "targ:1003"})
+	next := leader.SyncSnapshot("lb-0")
+	delta, changed = loadbalancer.NewSyncDelta(snapshot, next)
+	assert.True(t, changed)
+	assert.ErrorIs(t, follower.ApplySync(delta), loadbalancer.ErrGenerationGap)
+	snapshot = next
+	assert.NoError(t, follower.ApplySync(snapshot))
+
+	// verify
+	expected, actual := leader.State(), follower.State()
+	assert.Equal(t, expected.Generation, actual.Generation)
+	assert.Equal(t, expected.TargetItemMap, actual.TargetItemMap)
+	assert.Equal(t, expected.TargetMap, actual.TargetMap)
+	assert.Equal(t, loadbalancer.StateDraining, actual.CollectorMap["col-1"].State)
+	assert.Equal(t, 0, actual.CollectorMap["col-1"].NumTargs)
+	assert.Equal(t, 2, actual.CollectorMap["col-2"].NumTargs)
+	assert.Equal(t, "lb-0", actual.SyncLeader)
+	assert.Equal(t, expected.CollectorMap["col-2"].NumTargs, actual.CollectorMap["col-2"].NumTargs)
+	targets, generation, err := follower.Assignments("col-2")
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Generation, generation)
+	assert.Len(t, targets, 2)
+}
+
+// Tests that a delta without changes is not reported
+func TestSyncDeltaUnchanged(t *testing.T) {
+	// prepare
+	leader := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000"})
+
+	// test
+	_, changed := loadbalancer.NewSyncDelta(leader.SyncSnapshot("lb-0"), leader.SyncSnapshot("lb-0"))
+
+	// verify
+	assert.False(t, changed)
+}
+
+// Tests that two nodes claiming the same generation are detected as a split brain unless they agree
+func TestSyncSplitBrain(t *testing.T) {
+	target := func(target, collector string) loadbalancer.SyncAssignment {
+		return loadbalancer.SyncAssignment{JobName: "sample-name", Target: target, Labels: model.LabelSet{}, Collector: collector}
+	}
+	collectors := []loadbalancer.SyncCollector{{Name: "col-1"}, {Name: "col-2"}}
+	applied := loadbalancer.SyncMessage{Leader: "lb-0", Generation: 5, Snapshot: true, Collectors: collectors,
+		Assigned: []loadbalancer.SyncAssignment{target("targ:1000", "col-1"), target("targ:1001", "col-2")}}
+	tests := []struct {
+		name     string
+		message  loadbalancer.SyncMessage
+		expected error
+	}{
+		{
+			name:     "same leader sends the snapshot again",
+			message:  applied,
+			expected: nil,
+		},
+		{
+			name: "other leader takes over with the same assignment",
+			message: loadbalancer.SyncMessage{Leader: "lb-1", Generation: 5, Snapshot: true, Collectors: collectors,
+				Assigned: []loadbalancer.SyncAssignment{target("targ:1001", "col-2"), target("targ:1000", "col-1")}},
+			expected: nil,
+		},
+		{
+			name: "other leader claims the generation with another assignment",
+			message: loadbalancer.SyncMessage{Leader: "lb-1", Generation: 5, Snapshot: true, Collectors: collectors,
+				Assigned: []loadbalancer.SyncAssignment{target("targ:1000", "col-2"), target("targ:1001", "col-2")}},
+			expected: loadbalancer.ErrSplitBrain,
+		},
+		{
+			name: "other leader claims the generation with a delta",
+			message: loadbalancer.SyncMessage{Leader: "lb-1", Generation: 5, Base: 4, Collectors: collectors,
+				Assigned: []loadbalancer.SyncAssignment{target("targ:1000", "col-2")}},
+			expected: loadbalancer.ErrSplitBrain,
+		},
+		{
+			name:     "other leader is behind",
+			message:  loadbalancer.SyncMessage{Leader: "lb-1", Generation: 4, Snapshot: true, Collectors: collectors},
+			expected: loadbalancer.ErrStaleGeneration,
+		},
+		{
+			name:     "other leader is ahead",
+			message:  loadbalancer.SyncMessage{Leader: "lb-1", Generation: 6, Snapshot: true, Collectors: collectors},
+			expected: nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// prepare
+			follower := loadbalancer.Init(log.NewNopLogger())
+			assert.NoError(t, follower.ApplySync(applied))
+
+			// test
+			err := follower.ApplySync(tc.message)
+
+			// verify
+			assert.ErrorIs(t, err, tc.expected)
+			if err != nil {
+				assert.Equal(t, "lb-0", follower.State().SyncLeader)
+				assert.Equal(t, uint64(5), follower.State().Generation)
+			}
+		})
+	}
+}
+
+// Tests that a follower promoted to leader keeps the replicated assignment and generation
+func TestSyncFailover(t *testing.T) {
+	// prepare
+	leader := initLoadBalancer([]string{"col-1", "col-2"}, []string{"targ:1000", "targ:1001"})
+	follower := loadbalancer.Init(log.NewNopLogger())
+	assert.NoError(t, follower.ApplySync(leader.SyncSnapshot("lb-0")))
+	generation := follower.State().Generation
+
+	// test
+	follower.UpdateTargetSet([]lbdiscovery.TargetData{
+		{JobName: "sample-name", Target: "targ:1000", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1001", Labels: model.LabelSet{}},
+		{JobName: "sample-name", Target: "targ:1002", Labels: model.LabelSet{}},
+	})
+	follower.RefreshJobs()
+
+	// verify
+	state := follower.State()
+	assert.Equal(t, generation+1, state.Generation)
+	assert.Equal(t, leader.State().TargetItemMap["sample-nametarg:1000"], state.TargetItemMap["sample-nametarg:1000"])
+	assert.Equal(t, leader.State().TargetItemMap["sample-nametarg:1001"], state.TargetItemMap["sample-nametarg:1001"])
+	assert.Len(t, state.TargetItemMap, 3)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/http-sd-loadbalancer/config"
+	"github.com/http-sd-loadbalancer/monitors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// monitorWatcher holds the scrape jobs of the ServiceMonitors and PodMonitors, nil without a monitors section
+	monitorWatcher *monitors.Watcher
+	// stopMonitors stops the watch of the previous configuration
+	stopMonitors context.CancelFunc
+)
+
+// watchMonitors watches the monitors selected by the configuration, replacing the watch of the previous one
+// onChange is called once the existing monitors are listed and whenever they change after that
+func watchMonitors(ctx context.Context, cfg config.Config, onChange func()) {
+	if stopMonitors != nil {
+		stopMonitors()
+	}
+	monitorWatcher, stopMonitors = nil, nil
+	if cfg.Monitors == nil {
+		return
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to set up the monitor watch", "err", err)
+		return
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to set up the monitor watch", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	monitorWatcher = monitors.NewWatcher(client, *cfg.Monitors, log.With(logger, "component", "monitors"))
+	stopMonitors = cancel
+	// listing blocks until the CRDs are served, the static jobs are allocated meanwhile
+	go func(w *monitors.Watcher) {
+		// Run only fails when the watch is replaced before the monitors were listed
+		if err := w.Run(ctx, onChange); err != nil {
+			level.Debug(logger).Log("msg", "monitor watch stopped", "err", err)
+			return
+		}
+		onChange()
+	}(monitorWatcher)
+}
+
+// withMonitorJobs returns the configuration with the scrape jobs of the monitors appended to the static ones
+func withMonitorJobs(cfg config.Config) config.Config {
+	if monitorWatcher == nil {
+		return cfg
+	}
+	cfg.Config.ScrapeConfigs = monitors.Merge(cfg.Config.ScrapeConfigs, monitorWatcher.ScrapeConfigs())
+	return cfg
+}
+
+// effectiveConfig is the loaded configuration including the scrape jobs of the monitors
+func effectiveConfig() config.Config {
+	return withMonitorJobs(lbConfig)
+}
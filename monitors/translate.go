@@ -0,0 +1,203 @@
+package monitors
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/http-sd-loadbalancer/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// monitorSpec holds the fields of the ServiceMonitor and PodMonitor specs that make up a scrape job
+type monitorSpec struct {
+	JobLabel            string               `json:"jobLabel,omitempty"`
+	Selector            metav1.LabelSelector `json:"selector"`
+	NamespaceSelector   namespaceSelector    `json:"namespaceSelector,omitempty"`
+	Endpoints           []endpoint           `json:"endpoints,omitempty"`
+	PodMetricsEndpoints []endpoint           `json:"podMetricsEndpoints,omitempty"`
+}
+
+type namespaceSelector struct {
+	Any        bool     `json:"any,omitempty"`
+	MatchNames []string `json:"matchNames,omitempty"`
+}
+
+type endpoint struct {
+	Port                 string              `json:"port,omitempty"`
+	TargetPort           *intstr.IntOrString `json:"targetPort,omitempty"`
+	Path                 string              `json:"path,omitempty"`
+	Scheme               string              `json:"scheme,omitempty"`
+	Params               map[string][]string `json:"params,omitempty"`
+	Interval             string              `json:"interval,omitempty"`
+	ScrapeTimeout        string              `json:"scrapeTimeout,omitempty"`
+	HonorLabels          bool                `json:"honorLabels,omitempty"`
+	RelabelConfigs       []relabelConfig     `json:"relabelings,omitempty"`
+	MetricRelabelConfigs []relabelConfig     `json:"metricRelabelings,omitempty"`
+}
+
+// relabelConfig reads the camel case relabelings of a monitor and writes them as Prometheus relabel_configs
+type relabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty" yaml:"source_labels,flow,omitempty"`
+	Separator    string   `json:"separator,omitempty" yaml:"separator,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty" yaml:"target_label,omitempty"`
+	Regex        string   `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty" yaml:"modulus,omitempty"`
+	Replacement  *string  `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+type scrapeConfig struct {
+	JobName              string               `yaml:"job_name"`
+	HonorLabels          bool                 `yaml:"honor_labels,omitempty"`
+	ScrapeInterval       string               `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout        string               `yaml:"scrape_timeout,omitempty"`
+	MetricsPath          string               `yaml:"metrics_path,omitempty"`
+	Scheme               string               `yaml:"scheme,omitempty"`
+	Params               map[string][]string  `yaml:"params,omitempty"`
+	KubernetesSDConfigs  []kubernetesSDConfig `yaml:"kubernetes_sd_configs"`
+	RelabelConfigs       []relabelConfig      `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []relabelConfig      `yaml:"metric_relabel_configs,omitempty"`
+}
+
+type kubernetesSDConfig struct {
+	Role       string      `yaml:"role"`
+	Namespaces *namespaces `yaml:"namespaces,omitempty"`
+	Selectors  []selector  `yaml:"selectors,omitempty"`
+}
+
+type namespaces struct {
+	Names []string `yaml:"names"`
+}
+
+type selector struct {
+	Role  string `yaml:"role"`
+	Label string `yaml:"label,omitempty"`
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ServiceMonitorJobs translates every endpoint of a ServiceMonitor into a scrape job discovering the endpoints
+// of the selected services
+func ServiceMonitorJobs(obj *unstructured.Unstructured) ([]*promconfig.ScrapeConfig, error) {
+	return translate(obj, "serviceMonitor", "endpoints", "service")
+}
+
+// PodMonitorJobs translates every endpoint of a PodMonitor into a scrape job discovering the selected pods
+func PodMonitorJobs(obj *unstructured.Unstructured) ([]*promconfig.ScrapeConfig, error) {
+	return translate(obj, "podMonitor", "pod", "pod")
+}
+
+func translate(obj *unstructured.Unstructured, kind, role, selectorRole string) ([]*promconfig.ScrapeConfig, error) {
+	spec := monitorSpec{}
+	if raw, ok := obj.Object["spec"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+			return nil, fmt.Errorf("%w: %s/%s: %s", ErrInvalidMonitor, obj.GetNamespace(), obj.GetName(), err)
+		}
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(&spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s: %s", ErrInvalidMonitor, obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	sd := kubernetesSDConfig{Role: role}
+	switch {
+	case spec.NamespaceSelector.Any:
+	case len(spec.NamespaceSelector.MatchNames) > 0:
+		sd.Namespaces = &namespaces{Names: spec.NamespaceSelector.MatchNames}
+	default:
+		sd.Namespaces = &namespaces{Names: []string{obj.GetNamespace()}}
+	}
+	// selecting at discovery keeps the targets of other services and pods out of the allocation
+	if !labelSelector.Empty() {
+		sd.Selectors = []selector{{Role: selectorRole, Label: labelSelector.String()}}
+	}
+
+	endpoints := spec.Endpoints
+	if kind == "podMonitor" {
+		endpoints = spec.PodMetricsEndpoints
+	}
+	jobs := make([]*promconfig.ScrapeConfig, 0, len(endpoints))
+	for i, ep := range endpoints {
+		sc := scrapeConfig{
+			JobName:              jobName(kind, obj.GetNamespace(), obj.GetName(), i),
+			HonorLabels:          ep.HonorLabels,
+			ScrapeInterval:       ep.Interval,
+			ScrapeTimeout:        ep.ScrapeTimeout,
+			MetricsPath:          ep.Path,
+			Scheme:               ep.Scheme,
+			Params:               ep.Params,
+			KubernetesSDConfigs:  []kubernetesSDConfig{sd},
+			RelabelConfigs:       append(targetRelabelConfigs(kind, obj, spec.JobLabel, ep), ep.RelabelConfigs...),
+			MetricRelabelConfigs: ep.MetricRelabelConfigs,
+		}
+		// decoding the YAML of the job validates it as a job of the configuration file would be
+		out, err := yaml.Marshal(sc)
+		if err != nil {
+			return nil, err
+		}
+		job := &promconfig.ScrapeConfig{}
+		if err := yaml.UnmarshalStrict(out, job); err != nil {
+			return nil, fmt.Errorf("%w: %s/%s: %s", ErrInvalidMonitor, obj.GetNamespace(), obj.GetName(), err)
+		}
+		if err := config.ApplyDefaults(job); err != nil {
+			return nil, fmt.Errorf("%w: %s/%s: %s", ErrInvalidMonitor, obj.GetNamespace(), obj.GetName(), err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// targetRelabelConfigs keeps the targets of the endpoint port and sets the labels prometheus-operator sets
+// jobName is the name of the job of an endpoint of a monitor, e.g. serviceMonitor_shop_checkout_0
+// It must not contain slashes since it is a path segment of the API, and Kubernetes names have no underscores
+func jobName(kind, namespace, name string, endpoint int) string {
+	return fmt.Sprintf("%s_%s_%s_%d", kind, namespace, name, endpoint)
+}
+
+func targetRelabelConfigs(kind string, obj *unstructured.Unstructured, jobLabel string, ep endpoint) []relabelConfig {
+	var rcs []relabelConfig
+	portName := "__meta_kubernetes_endpoint_port_name"
+	if kind == "podMonitor" {
+		portName = "__meta_kubernetes_pod_container_port_name"
+	}
+	switch {
+	case ep.Port != "":
+		rcs = append(rcs, relabelConfig{Action: "keep", SourceLabels: []string{portName}, Regex: ep.Port})
+	case ep.TargetPort != nil && ep.TargetPort.Type == intstr.String:
+		rcs = append(rcs, relabelConfig{Action: "keep", SourceLabels: []string{"__meta_kubernetes_pod_container_port_name"}, Regex: ep.TargetPort.StrVal})
+	case ep.TargetPort != nil:
+		rcs = append(rcs, relabelConfig{Action: "keep", SourceLabels: []string{"__meta_kubernetes_pod_container_port_number"}, Regex: ep.TargetPort.String()})
+	}
+
+	rcs = append(rcs,
+		relabelConfig{SourceLabels: []string{"__meta_kubernetes_namespace"}, TargetLabel: "namespace"},
+		relabelConfig{SourceLabels: []string{"__meta_kubernetes_pod_name"}, TargetLabel: "pod"},
+		relabelConfig{SourceLabels: []string{"__meta_kubernetes_pod_container_name"}, TargetLabel: "container"},
+	)
+	if kind == "podMonitor" {
+		job := obj.GetNamespace() + "/" + obj.GetName()
+		rcs = append(rcs, relabelConfig{TargetLabel: "job", Replacement: &job})
+	} else {
+		rcs = append(rcs,
+			relabelConfig{SourceLabels: []string{"__meta_kubernetes_service_name"}, TargetLabel: "service"},
+			relabelConfig{SourceLabels: []string{"__meta_kubernetes_service_name"}, TargetLabel: "job"},
+		)
+	}
+	if jobLabel != "" {
+		source := "__meta_kubernetes_service_label_" + invalidLabelChars.ReplaceAllString(jobLabel, "_")
+		if kind == "podMonitor" {
+			source = "__meta_kubernetes_pod_label_" + invalidLabelChars.ReplaceAllString(jobLabel, "_")
+		}
+		rcs = append(rcs, relabelConfig{SourceLabels: []string{source}, TargetLabel: "job", Regex: "(.+)"})
+	}
+	if ep.Port != "" {
+		port := ep.Port
+		rcs = append(rcs, relabelConfig{TargetLabel: "endpoint", Replacement: &port})
+	}
+	return rcs
+}
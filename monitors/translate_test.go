@@ -0,0 +1,295 @@
+package monitors
+
+import (
+	"testing"
+
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func serviceMonitor(namespace, name string, labels map[string]interface{}, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name, "labels": labels},
+		"spec":       spec,
+	}}
+}
+
+func podMonitor(namespace, name string, labels map[string]interface{}, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "PodMonitor",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name, "labels": labels},
+		"spec":       spec,
+	}}
+}
+
+func TestServiceMonitorJobs(t *testing.T) {
+	// prepare
+	sm := serviceMonitor("shop", "checkout", nil, map[string]interface{}{
+		"jobLabel":          "app.kubernetes.io/name",
+		"selector":          map[string]interface{}{"matchLabels": map[string]interface{}{"app": "checkout"}},
+		"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"shop", "shop-canary"}},
+		"endpoints": []interface{}{
+			map[string]interface{}{
+				"port":     "metrics",
+				"interval": "30s",
+				"path":     "/internal/metrics",
+				"relabelings": []interface{}{
+					map[string]interface{}{"sourceLabels": []interface{}{"__meta_kubernetes_pod_node_name"}, "targetLabel": "node", "replacement": "$1"},
+				},
+				"metricRelabelings": []interface{}{
+					map[string]interface{}{"sourceLabels": []interface{}{"__name__"}, "regex": "go_.*", "action": "drop"},
+				},
+			},
+			map[string]interface{}{"targetPort": int64(8081), "honorLabels": true},
+		},
+	})
+	expected := `- job_name: serviceMonitor_shop_checkout_0
+  honor_timestamps: true
+  scrape_interval: 30s
+  scrape_timeout: 10s
+  metrics_path: /internal/metrics
+  scheme: http
+  follow_redirects: true
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_endpoint_port_name]
+    separator: ;
+    regex: metrics
+    replacement: $1
+    action: keep
+  - source_labels: [__meta_kubernetes_namespace]
+    separator: ;
+    regex: (.*)
+    target_label: namespace
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_name]
+    separator: ;
+    regex: (.*)
+    target_label: pod
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_container_name]
+    separator: ;
+    regex: (.*)
+    target_label: container
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_name]
+    separator: ;
+    regex: (.*)
+    target_label: service
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_name]
+    separator: ;
+    regex: (.*)
+    target_label: job
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_label_app_kubernetes_io_name]
+    separator: ;
+    regex: (.+)
+    target_label: job
+    replacement: $1
+    action: replace
+  - separator: ;
+    regex: (.*)
+    target_label: endpoint
+    replacement: metrics
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_node_name]
+    separator: ;
+    regex: (.*)
+    target_label: node
+    replacement: $1
+    action: replace
+  metric_relabel_configs:
+  - source_labels: [__name__]
+    separator: ;
+    regex: go_.*
+    replacement: $1
+    action: drop
+  kubernetes_sd_configs:
+  - role: endpoints
+    kubeconfig_file: ""
+    follow_redirects: true
+    namespaces:
+      names:
+      - shop
+      - shop-canary
+    selectors:
+    - role: service
+      label: app=checkout
+- job_name: serviceMonitor_shop_checkout_1
+  honor_labels: true
+  honor_timestamps: true
+  scrape_interval: 1m
+  scrape_timeout: 10s
+  metrics_path: /metrics
+  scheme: http
+  follow_redirects: true
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_pod_container_port_number]
+    separator: ;
+    regex: "8081"
+    replacement: $1
+    action: keep
+  - source_labels: [__meta_kubernetes_namespace]
+    separator: ;
+    regex: (.*)
+    target_label: namespace
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_name]
+    separator: ;
+    regex: (.*)
+    target_label: pod
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_container_name]
+    separator: ;
+    regex: (.*)
+    target_label: container
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_name]
+    separator: ;
+    regex: (.*)
+    target_label: service
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_name]
+    separator: ;
+    regex: (.*)
+    target_label: job
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_service_label_app_kubernetes_io_name]
+    separator: ;
+    regex: (.+)
+    target_label: job
+    replacement: $1
+    action: replace
+  kubernetes_sd_configs:
+  - role: endpoints
+    kubeconfig_file: ""
+    follow_redirects: true
+    namespaces:
+      names:
+      - shop
+      - shop-canary
+    selectors:
+    - role: service
+      label: app=checkout
+`
+
+	// test
+	jobs, err := ServiceMonitorJobs(sm)
+
+	// verify
+	assert.NoError(t, err)
+	out, err := yaml.Marshal(jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+}
+
+func TestPodMonitorJobs(t *testing.T) {
+	// prepare
+	pm := podMonitor("shop", "worker", nil, map[string]interface{}{
+		"selector":            map[string]interface{}{"matchExpressions": []interface{}{map[string]interface{}{"key": "tier", "operator": "In", "values": []interface{}{"worker"}}}},
+		"namespaceSelector":   map[string]interface{}{"any": true},
+		"podMetricsEndpoints": []interface{}{map[string]interface{}{"port": "http"}},
+	})
+	expected := `- job_name: podMonitor_shop_worker_0
+  honor_timestamps: true
+  scrape_interval: 1m
+  scrape_timeout: 10s
+  metrics_path: /metrics
+  scheme: http
+  follow_redirects: true
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_pod_container_port_name]
+    separator: ;
+    regex: http
+    replacement: $1
+    action: keep
+  - source_labels: [__meta_kubernetes_namespace]
+    separator: ;
+    regex: (.*)
+    target_label: namespace
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_name]
+    separator: ;
+    regex: (.*)
+    target_label: pod
+    replacement: $1
+    action: replace
+  - source_labels: [__meta_kubernetes_pod_container_name]
+    separator: ;
+    regex: (.*)
+    target_label: container
+    replacement: $1
+    action: replace
+  - separator: ;
+    regex: (.*)
+    target_label: job
+    replacement: shop/worker
+    action: replace
+  - separator: ;
+    regex: (.*)
+    target_label: endpoint
+    replacement: http
+    action: replace
+  kubernetes_sd_configs:
+  - role: pod
+    kubeconfig_file: ""
+    follow_redirects: true
+    selectors:
+    - role: pod
+      label: tier in (worker)
+`
+
+	// test
+	jobs, err := PodMonitorJobs(pm)
+
+	// verify
+	assert.NoError(t, err)
+	out, err := yaml.Marshal(jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+}
+
+func TestInvalidMonitor(t *testing.T) {
+	// prepare
+	sm := serviceMonitor("shop", "checkout", nil, map[string]interface{}{
+		"selector": map[string]interface{}{"matchExpressions": []interface{}{map[string]interface{}{"key": "tier", "operator": "Sometimes"}}},
+	})
+
+	// test
+	_, err := ServiceMonitorJobs(sm)
+
+	// verify
+	assert.ErrorIs(t, err, ErrInvalidMonitor)
+}
+
+func TestMerge(t *testing.T) {
+	// prepare
+	static := []*promconfig.ScrapeConfig{{JobName: "node"}, {JobName: "serviceMonitor_shop_checkout_0", MetricsPath: "/static"}}
+	generated := []*promconfig.ScrapeConfig{{JobName: "serviceMonitor_shop_checkout_0"}, {JobName: "podMonitor_shop_worker_0"}}
+
+	// test
+	merged := Merge(static, generated)
+
+	// verify
+	assert.Equal(t, []*promconfig.ScrapeConfig{
+		{JobName: "node"},
+		{JobName: "serviceMonitor_shop_checkout_0", MetricsPath: "/static"},
+		{JobName: "podMonitor_shop_worker_0"},
+	}, merged)
+}
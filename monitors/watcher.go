@@ -0,0 +1,182 @@
+// Package monitors turns prometheus-operator ServiceMonitor and PodMonitor objects into scrape jobs
+// Each endpoint of a monitor becomes a job with Kubernetes SD and the relabelling prometheus-operator would generate
+package monitors
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/http-sd-loadbalancer/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	// ErrInvalidMonitor represents a monitor whose spec can't be translated into scrape jobs.
+	ErrInvalidMonitor = errors.New("invalid monitor")
+	// ErrNotSynced represents a watch stopped before the monitors were listed.
+	ErrNotSynced = errors.New("monitors were not listed before the watch stopped")
+)
+
+var (
+	// ServiceMonitors is the resource of prometheus-operator ServiceMonitor objects
+	ServiceMonitors = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"}
+	// PodMonitors is the resource of prometheus-operator PodMonitor objects
+	PodMonitors = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "podmonitors"}
+)
+
+// resyncPeriod is how often the informers replay every monitor
+const resyncPeriod = 10 * time.Minute
+
+// Watcher keeps the scrape jobs of the monitors selected by the configuration
+type Watcher struct {
+	client dynamic.Interface
+	cfg    config.MonitorsConfig
+	logger log.Logger
+
+	mu       sync.RWMutex
+	jobs     map[string][]*promconfig.ScrapeConfig
+	onChange func()
+}
+
+func NewWatcher(client dynamic.Interface, cfg config.MonitorsConfig, logger log.Logger) *Watcher {
+	return &Watcher{client: client, cfg: cfg, logger: logger, jobs: make(map[string][]*promconfig.ScrapeConfig)}
+}
+
+// Run watches the monitors until ctx is done and returns once the existing ones are translated
+// onChange is called whenever the scrape jobs change after that
+func (w *Watcher) Run(ctx context.Context, onChange func()) error {
+	namespaces := w.cfg.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	watches := []struct {
+		resource  schema.GroupVersionResource
+		selector  labels.Selector
+		translate func(*unstructured.Unstructured) ([]*promconfig.ScrapeConfig, error)
+	}{
+		{ServiceMonitors, labels.SelectorFromSet(w.cfg.ServiceMonitorSelector), ServiceMonitorJobs},
+		{PodMonitors, labels.SelectorFromSet(w.cfg.PodMonitorSelector), PodMonitorJobs},
+	}
+
+	var synced []cache.InformerSynced
+	for _, namespace := range namespaces {
+		for _, watch := range watches {
+			watch := watch
+			informer := dynamicinformer.NewFilteredDynamicInformer(w.client, watch.resource, namespace, resyncPeriod, cache.Indexers{},
+				func(opts *metav1.ListOptions) { opts.LabelSelector = watch.selector.String() }).Informer()
+			informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) { w.update(watch.resource, obj, watch.selector, watch.translate) },
+				UpdateFunc: func(_, obj interface{}) {
+					w.update(watch.resource, obj, watch.selector, watch.translate)
+				},
+				DeleteFunc: func(obj interface{}) { w.delete(watch.resource, obj) },
+			})
+			go informer.Run(ctx.Done())
+			synced = append(synced, informer.HasSynced)
+		}
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return ErrNotSynced
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onChange = onChange
+	level.Info(w.logger).Log("msg", "monitors listed", "monitors", len(w.jobs))
+	return nil
+}
+
+// ScrapeConfigs returns the scrape jobs of every monitor ordered by job name
+func (w *Watcher) ScrapeConfigs() []*promconfig.ScrapeConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	scrapeConfigs := []*promconfig.ScrapeConfig{}
+	for _, jobs := range w.jobs {
+		scrapeConfigs = append(scrapeConfigs, jobs...)
+	}
+	sort.Slice(scrapeConfigs, func(i, j int) bool {
+		return scrapeConfigs[i].JobName < scrapeConfigs[j].JobName
+	})
+	return scrapeConfigs
+}
+
+// Merge appends generated scrape jobs to the static ones; a static job wins over a generated job of the same name
+func Merge(static, generated []*promconfig.ScrapeConfig) []*promconfig.ScrapeConfig {
+	names := make(map[string]bool, len(static))
+	merged := make([]*promconfig.ScrapeConfig, 0, len(static)+len(generated))
+	for _, sc := range static {
+		names[sc.JobName] = true
+		merged = append(merged, sc)
+	}
+	for _, sc := range generated {
+		if !names[sc.JobName] {
+			merged = append(merged, sc)
+		}
+	}
+	return merged
+}
+
+func (w *Watcher) update(resource schema.GroupVersionResource, obj interface{}, selector labels.Selector, translate func(*unstructured.Unstructured) ([]*promconfig.ScrapeConfig, error)) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	// watch events are not filtered by every API server implementation
+	if !selector.Matches(labels.Set(u.GetLabels())) {
+		w.delete(resource, obj)
+		return
+	}
+	jobs, err := translate(u)
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "failed to translate monitor", "resource", resource.Resource, "namespace", u.GetNamespace(), "name", u.GetName(), "err", err)
+	}
+	w.set(resource.Resource+"/"+u.GetNamespace()+"/"+u.GetName(), jobs)
+}
+
+func (w *Watcher) delete(resource schema.GroupVersionResource, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	w.set(resource.Resource+"/"+u.GetNamespace()+"/"+u.GetName(), nil)
+}
+
+// set replaces the jobs of a monitor and reports the change
+func (w *Watcher) set(key string, jobs []*promconfig.ScrapeConfig) {
+	if len(jobs) == 0 {
+		jobs = nil
+	}
+	w.mu.Lock()
+	if reflect.DeepEqual(w.jobs[key], jobs) {
+		w.mu.Unlock()
+		return
+	}
+	if jobs == nil {
+		delete(w.jobs, key)
+	} else {
+		w.jobs[key] = jobs
+	}
+	onChange := w.onChange
+	w.mu.Unlock()
+
+	level.Debug(w.logger).Log("msg", "monitor changed", "monitor", key, "jobs", len(jobs))
+	if onChange != nil {
+		onChange()
+	}
+}
@@ -0,0 +1,99 @@
+package monitors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/http-sd-loadbalancer/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func newFakeClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+	return fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ServiceMonitors: "ServiceMonitorList",
+		PodMonitors:     "PodMonitorList",
+	}, objects...)
+}
+
+func jobNames(scrapeConfigs []*promconfig.ScrapeConfig) []string {
+	names := []string{}
+	for _, sc := range scrapeConfigs {
+		names = append(names, sc.JobName)
+	}
+	return names
+}
+
+func TestWatcher(t *testing.T) {
+	// prepare
+	endpoints := map[string]interface{}{"endpoints": []interface{}{map[string]interface{}{"port": "metrics"}}}
+	podEndpoints := map[string]interface{}{"podMetricsEndpoints": []interface{}{map[string]interface{}{"port": "metrics"}}}
+	team := map[string]interface{}{"team": "shop"}
+	client := newFakeClient(
+		serviceMonitor("shop", "checkout", team, endpoints),
+		serviceMonitor("shop", "unlabelled", nil, endpoints),
+		serviceMonitor("billing", "invoices", team, endpoints),
+		podMonitor("shop", "worker", nil, podEndpoints),
+	)
+	watcher := NewWatcher(client, config.MonitorsConfig{
+		Namespaces:             []string{"shop"},
+		ServiceMonitorSelector: map[string]string{"team": "shop"},
+	}, log.NewNopLogger())
+	changed := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waitForChange := func() {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change reported")
+		}
+	}
+
+	t.Run("should translate the selected monitors", func(t *testing.T) {
+		err := watcher.Run(ctx, func() { changed <- struct{}{} })
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"podMonitor_shop_worker_0", "serviceMonitor_shop_checkout_0"}, jobNames(watcher.ScrapeConfigs()))
+		assert.Empty(t, changed)
+	})
+
+	t.Run("should add a created monitor", func(t *testing.T) {
+		_, err := client.Resource(ServiceMonitors).Namespace("shop").Create(ctx, serviceMonitor("shop", "cart", team, endpoints), metav1.CreateOptions{})
+		assert.NoError(t, err)
+		waitForChange()
+
+		assert.Equal(t, []string{"podMonitor_shop_worker_0", "serviceMonitor_shop_cart_0", "serviceMonitor_shop_checkout_0"}, jobNames(watcher.ScrapeConfigs()))
+	})
+
+	t.Run("should drop a monitor that no longer matches the selector", func(t *testing.T) {
+		_, err := client.Resource(ServiceMonitors).Namespace("shop").Update(ctx, serviceMonitor("shop", "cart", nil, endpoints), metav1.UpdateOptions{})
+		assert.NoError(t, err)
+		waitForChange()
+
+		assert.Equal(t, []string{"podMonitor_shop_worker_0", "serviceMonitor_shop_checkout_0"}, jobNames(watcher.ScrapeConfigs()))
+	})
+
+	t.Run("should remove a deleted monitor", func(t *testing.T) {
+		err := client.Resource(PodMonitors).Namespace("shop").Delete(ctx, "worker", metav1.DeleteOptions{})
+		assert.NoError(t, err)
+		waitForChange()
+
+		assert.Equal(t, []string{"serviceMonitor_shop_checkout_0"}, jobNames(watcher.ScrapeConfigs()))
+	})
+
+	t.Run("should ignore monitors of other namespaces", func(t *testing.T) {
+		_, err := client.Resource(ServiceMonitors).Namespace("billing").Create(ctx, serviceMonitor("billing", "payments", team, endpoints), metav1.CreateOptions{})
+		assert.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, changed)
+		assert.Equal(t, []string{"serviceMonitor_shop_checkout_0"}, jobNames(watcher.ScrapeConfigs()))
+	})
+}
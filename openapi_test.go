@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/http-sd-loadbalancer/client"
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	// prepare
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(openAPISpec, &spec))
+
+	// test
+	var routes []string
+	err := router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes = append(routes, strings.ToLower(method)+" "+template)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// verify
+	var documented []string
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	assert.ElementsMatch(t, routes, documented)
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	// test
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// verify
+	var spec map[string]interface{}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&spec))
+	assert.Equal(t, "3.0.3", spec["openapi"])
+}
+
+func TestClient(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("should list jobs", func(t *testing.T) {
+		jobs, err := c.Jobs(ctx)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]loadbalancer.LinkLabel{"job-a": {Link: "/jobs/job-a/targets"}}, jobs)
+	})
+
+	t.Run("should get the targets of a job by collector", func(t *testing.T) {
+		targets, err := c.JobTargets(ctx, "job-a")
+
+		assert.NoError(t, err)
+		assert.Len(t, targets, 2)
+		assert.Equal(t, "/jobs/job-a/targets?collector_id=collector-1", targets["collector-1"].Link)
+	})
+
+	t.Run("should get the targets of a collector", func(t *testing.T) {
+		tgs, index, err := c.Targets(ctx, "job-a", "collector-1")
+
+		assert.NoError(t, err)
+		assert.Len(t, tgs, 1)
+		assert.Equal(t, []string{"targ:1000"}, tgs[0].Targets)
+		assert.Equal(t, lb.Cache.Index("job-a", "collector-1"), index)
+	})
+
+	t.Run("should get every target of a collector", func(t *testing.T) {
+		tgs, _, err := c.CollectorTargets(ctx, "collector-2")
+
+		assert.NoError(t, err)
+		assert.Len(t, tgs, 1)
+		assert.Equal(t, model.LabelValue("job-a"), tgs[0].Labels[loadbalancer.JobLabel])
+	})
+
+	t.Run("should return once the index moves", func(t *testing.T) {
+		_, index, err := c.Targets(ctx, "job-a", "collector-1")
+		assert.NoError(t, err)
+
+		_, latest, err := c.WaitTargets(ctx, "job-a", "collector-1", index, 50*time.Millisecond)
+
+		assert.NoError(t, err)
+		assert.Equal(t, index, latest)
+	})
+
+	t.Run("should manage collectors", func(t *testing.T) {
+		status, err := c.Cordon(ctx, "collector-1")
+		assert.NoError(t, err)
+		assert.Equal(t, loadbalancer.StateCordoned, status.State)
+
+		status, err = c.Uncordon(ctx, "collector-1")
+		assert.NoError(t, err)
+		assert.Equal(t, loadbalancer.StateActive, status.State)
+
+		collectors, err := c.Collectors(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, collectors, 2)
+
+		_, err = c.Collector(ctx, "collector-3")
+		assert.ErrorIs(t, err, client.ErrNotFound)
+	})
+
+	t.Run("should manage pins", func(t *testing.T) {
+		status, err := c.AddPin(ctx, config.Pin{Target: "job-a/targ:1000", Collector: "collector-2"})
+		assert.NoError(t, err)
+		assert.Len(t, status.Pins, 1)
+
+		status, err = c.RemovePin(ctx, "job-a/targ:1000")
+		assert.NoError(t, err)
+		assert.Empty(t, status.Pins)
+
+		_, err = c.RemovePin(ctx, "job-a/targ:1000")
+		assert.ErrorIs(t, err, client.ErrNotFound)
+	})
+
+	t.Run("should stream events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			lb.UpdateTargetSet([]lbdiscovery.TargetData{
+				{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+				{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+				{JobName: "job-a", Target: "targ:1002", Labels: model.LabelSet{}},
+			})
+			lb.RefreshJobs()
+		}()
+		var received []loadbalancer.Event
+		err := c.Events(ctx, client.EventFilter{Job: "job-a"}, func(e loadbalancer.Event) error {
+			received = append(received, e)
+			cancel()
+			return nil
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotEmpty(t, received)
+		assert.Equal(t, loadbalancer.EventTargetAdded, received[0].Type)
+		assert.Equal(t, "targ:1002", received[0].Target)
+	})
+}
+
+func TestClientAuthentication(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	authenticator = auth.StaticTokens{"col-1-token": "collector-1"}
+	defer func() { authenticator = nil }()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	anonymous, err := client.New(srv.URL)
+	assert.NoError(t, err)
+	collector, err := client.New(srv.URL, client.WithBearerToken("col-1-token"))
+	assert.NoError(t, err)
+
+	// test
+	_, _, anonymousErr := anonymous.Targets(context.Background(), "job-a", "collector-1")
+	_, _, ownErr := collector.Targets(context.Background(), "job-a", "collector-1")
+	_, _, otherErr := collector.Targets(context.Background(), "job-a", "collector-2")
+
+	// verify
+	assert.ErrorIs(t, anonymousErr, client.ErrUnauthorized)
+	assert.NoError(t, ownErr)
+	assert.ErrorIs(t, otherErr, client.ErrForbidden)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/config"
+	"github.com/http-sd-loadbalancer/otelconfig"
+)
+
+// otelConfigHandler renders the OpenTelemetry Collector configuration of a collector
+// Without a base URL in the configuration, the http_sd_configs point at the address the request came in on
+func otelConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	status, err := lb.CollectorStatus(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	opts := otelconfig.Options{Collector: name, Pools: status.Pools}
+	if lbConfig.OTelCollector == nil || lbConfig.OTelCollector.BaseURL == "" {
+		opts.BaseURL = requestBaseURL(r)
+	}
+	out, err := otelconfig.Render(effectiveConfig(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
+
+// requestBaseURL is the scheme and host a request was sent to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// runOTelConfig implements the otel-config subcommand: it renders the OpenTelemetry Collector configuration
+// of a collector from a load balancer configuration file
+func runOTelConfig(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("otel-config", flag.ContinueOnError)
+	configFile := fs.String("config.file", configDir+"/loadbalancer.yaml", "Load balancer configuration file.")
+	fragments := fs.String("config.fragments", "", "Glob of files whose scrape_configs are merged into the configuration.")
+	collector := fs.String("collector", "", "Name of the collector to render the configuration of.")
+	pools := fs.String("pools", config.DefaultPool, "Comma separated pools of the collector, only the jobs bound to them are rendered.")
+	url := fs.String("url", "", "Address the collector reaches the load balancer at. Overrides otel_collector.base_url.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	files := []string{*configFile}
+	if *fragments != "" {
+		files = append(files, *fragments)
+	}
+	cfg, err := config.Load(files...)
+	if err != nil {
+		return err
+	}
+	rendered, err := otelconfig.Render(cfg, otelconfig.Options{Collector: *collector, Pools: strings.Split(*pools, ","), BaseURL: *url})
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(rendered)
+	return err
+}
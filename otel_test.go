@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/http-sd-loadbalancer/config"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTelConfigEndpoint(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	lbConfig = config.Config{Config: config.ScrapeConfig{ScrapeConfigs: []*promconfig.ScrapeConfig{{JobName: "job-a"}}}}
+	defer func() { lbConfig = config.Config{} }()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	t.Run("should point at the load balancer", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/api/v1/collectors/collector-2/otel-config")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/yaml", resp.Header.Get("Content-Type"))
+		assert.Contains(t, string(body), "- url: "+srv.URL+"/jobs/job-a/targets?collector_id=collector-2\n")
+	})
+
+	t.Run("should use the configured base URL", func(t *testing.T) {
+		lbConfig.OTelCollector = &config.OTelCollectorConfig{BaseURL: "http://loadbalancer:3030"}
+		defer func() { lbConfig.OTelCollector = nil }()
+		resp, err := http.Get(srv.URL + "/api/v1/collectors/collector-2/otel-config")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		assert.Contains(t, string(body), "- url: http://loadbalancer:3030/jobs/job-a/targets?collector_id=collector-2\n")
+	})
+
+	t.Run("should fail for an unknown collector", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/api/v1/collectors/collector-3/otel-config")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestOTelConfigCommand(t *testing.T) {
+	t.Run("should render every job of the configuration file", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runOTelConfig([]string{"-config.file", "conf/loadbalancer.yaml", "-collector", "collector-1", "-url", "http://loadbalancer:3030"}, &out)
+
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(out.String(), "receivers:\n  prometheus:\n    config:\n      scrape_configs:\n      - job_name: prometheus\n"))
+		assert.Contains(t, out.String(), "- url: http://loadbalancer:3030/jobs/service-x/targets?collector_id=collector-1\n")
+		assert.NotContains(t, out.String(), "static_configs")
+	})
+
+	t.Run("should fail without a collector", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runOTelConfig([]string{"-config.file", "conf/loadbalancer.yaml", "-url", "http://loadbalancer:3030"}, &out)
+
+		assert.Error(t, err)
+		assert.Empty(t, out.String())
+	})
+}
@@ -0,0 +1,224 @@
+// Package otelconfig renders the OpenTelemetry Collector configuration of a collector
+// Every scrape job of the load balancer is turned into a prometheus receiver job that discovers
+// the collector's share of targets through http_sd_configs
+// Jobs with inline credentials are not rendered, collectors read credentials from *_file references instead
+package otelconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/http-sd-loadbalancer/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrNoCollector represents a render without a collector name.
+	ErrNoCollector = errors.New("collector name is required")
+	// ErrNoBaseURL represents a render without the address collectors reach the load balancer at.
+	ErrNoBaseURL = errors.New("base URL of the load balancer is required")
+	// ErrInvalidExporters represents an exporters template that does not render to a YAML map.
+	ErrInvalidExporters = errors.New("invalid exporters template")
+	// ErrInlineSecret represents a scrape job with inline credentials, which would render as <secret>.
+	ErrInlineSecret = errors.New("inline credentials can't be rendered, use their *_file form")
+)
+
+// DefaultExporters is the exporters template used when the configuration has none
+const DefaultExporters = `logging: {}`
+
+// Options select the collector to render for and where it reaches the load balancer
+type Options struct {
+	Collector string
+	// Pools are the pools of the collector, only the jobs bound to them are rendered; defaults to the default pool
+	Pools []string
+	// BaseURL takes precedence over the base URL of the configuration
+	BaseURL string
+}
+
+// exportersData is what the exporters template is executed with
+type exportersData struct {
+	Collector string
+	Jobs      []string
+}
+
+// Render returns the receivers, exporters and service sections of the OpenTelemetry Collector configuration
+// of a collector, as YAML
+func Render(cfg config.Config, opts Options) ([]byte, error) {
+	if opts.Collector == "" {
+		return nil, ErrNoCollector
+	}
+	otel := config.OTelCollectorConfig{}
+	if cfg.OTelCollector != nil {
+		otel = *cfg.OTelCollector
+	}
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = otel.BaseURL
+	}
+	if baseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	pools := map[string]bool{}
+	for _, pool := range opts.Pools {
+		pools[pool] = true
+	}
+	if len(pools) == 0 {
+		pools[config.DefaultPool] = true
+	}
+
+	var jobs []string
+	scrapeConfigs := []yaml.MapSlice{}
+	for _, sc := range cfg.Config.ScrapeConfigs {
+		// the targets of the jobs of other pools are never assigned to the collector
+		if !pools[config.JobPool(cfg.Pools, sc.JobName)] {
+			continue
+		}
+		if key := inlineSecret(sc); key != "" {
+			return nil, fmt.Errorf("%w: %s of job %q", ErrInlineSecret, key, sc.JobName)
+		}
+		job, err := scrapeConfig(sc, httpSDConfig(baseURL, sc.JobName, opts.Collector, otel.BearerTokenFile))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, sc.JobName)
+		scrapeConfigs = append(scrapeConfigs, job)
+	}
+
+	exporters, err := renderExporters(otel.Exporters, exportersData{Collector: opts.Collector, Jobs: jobs})
+	if err != nil {
+		return nil, err
+	}
+	exporterNames := make([]string, 0, len(exporters))
+	for _, item := range exporters {
+		exporterNames = append(exporterNames, fmt.Sprint(item.Key))
+	}
+
+	return yaml.Marshal(yaml.MapSlice{
+		{Key: "receivers", Value: yaml.MapSlice{
+			{Key: "prometheus", Value: yaml.MapSlice{
+				{Key: "config", Value: yaml.MapSlice{
+					{Key: "scrape_configs", Value: scrapeConfigs},
+				}},
+			}},
+		}},
+		{Key: "exporters", Value: exporters},
+		{Key: "service", Value: yaml.MapSlice{
+			{Key: "pipelines", Value: yaml.MapSlice{
+				{Key: "metrics", Value: yaml.MapSlice{
+					{Key: "receivers", Value: []string{"prometheus"}},
+					{Key: "exporters", Value: exporterNames},
+				}},
+			}},
+		}},
+	})
+}
+
+// scrapeConfig replaces the service discovery of a scrape job with sd and keeps the rest, job_name first
+// and the other keys in name order
+func scrapeConfig(job *promconfig.ScrapeConfig, sd yaml.MapSlice) (yaml.MapSlice, error) {
+	out, err := yaml.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	sc := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &sc); err != nil {
+		return nil, err
+	}
+	keys := []string{"http_sd_configs"}
+	for k := range sc {
+		if k == "job_name" || k == "static_configs" || strings.HasSuffix(k, "_sd_configs") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rendered := yaml.MapSlice{{Key: "job_name", Value: escape(job.JobName)}}
+	for _, k := range keys {
+		if k == "http_sd_configs" {
+			rendered = append(rendered, yaml.MapItem{Key: k, Value: []yaml.MapSlice{sd}})
+			continue
+		}
+		rendered = append(rendered, yaml.MapItem{Key: k, Value: escape(sc[k])})
+	}
+	return rendered, nil
+}
+
+// inlineSecret returns the key of a credential the job carries inline, or an empty string without one
+func inlineSecret(job *promconfig.ScrapeConfig) string {
+	c := job.HTTPClientConfig
+	switch {
+	case c.BasicAuth != nil && c.BasicAuth.Password != "":
+		return "basic_auth.password"
+	case c.Authorization != nil && c.Authorization.Credentials != "":
+		return "authorization.credentials"
+	case c.BearerToken != "":
+		return "bearer_token"
+	case c.OAuth2 != nil && c.OAuth2.ClientSecret != "":
+		return "oauth2.client_secret"
+	}
+	return ""
+}
+
+// httpSDConfig points the job at the targets of the collector
+func httpSDConfig(baseURL, jobName, collector, bearerTokenFile string) yaml.MapSlice {
+	sd := yaml.MapSlice{
+		{Key: "url", Value: baseURL + "/jobs/" + url.PathEscape(jobName) + "/targets?collector_id=" + url.QueryEscape(collector)},
+	}
+	if bearerTokenFile != "" {
+		sd = append(sd, yaml.MapItem{Key: "authorization", Value: yaml.MapSlice{{Key: "credentials_file", Value: bearerTokenFile}}})
+	}
+	return sd
+}
+
+// escape doubles every $ of the string values, as the collector expands environment variables in its configuration
+// and relabel replacements like $1 must reach the receiver as they are
+func escape(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return strings.ReplaceAll(v, "$", "$$")
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = escape(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(v))
+		for k, item := range v {
+			out[k] = escape(item)
+		}
+		return out
+	}
+	return v
+}
+
+// renderExporters executes the exporters template, keeping the order of the exporters it defines
+func renderExporters(text string, data exportersData) (yaml.MapSlice, error) {
+	if text == "" {
+		text = DefaultExporters
+	}
+	tmpl, err := template.New("exporters").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExporters, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExporters, err)
+	}
+	var exporters yaml.MapSlice
+	if err := yaml.Unmarshal(buf.Bytes(), &exporters); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExporters, err)
+	}
+	if len(exporters) == 0 {
+		return nil, fmt.Errorf("%w: no exporter defined", ErrInvalidExporters)
+	}
+	return exporters, nil
+}
@@ -0,0 +1,205 @@
+package otelconfig
+
+import (
+	"testing"
+
+	"github.com/http-sd-loadbalancer/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func testConfig(t testing.TB) config.Config {
+	t.Helper()
+	cfg := config.Config{}
+	err := yaml.UnmarshalStrict([]byte(`
+config:
+  scrape_configs:
+  - job_name: node
+    scrape_interval: 30s
+    static_configs:
+    - targets: [node:9100]
+    file_sd_configs:
+    - files: [nodes.yaml]
+    relabel_configs:
+    - source_labels: [__address__]
+      regex: (.*):9100
+      replacement: $1
+      target_label: host
+  - job_name: kube state
+    kubernetes_sd_configs:
+    - role: pod
+    basic_auth:
+      username: prometheus
+      password_file: /etc/secrets/password
+`), &cfg)
+	assert.NoError(t, err)
+	return cfg
+}
+
+func TestRender(t *testing.T) {
+	// prepare
+	cfg := testConfig(t)
+	cfg.OTelCollector = &config.OTelCollectorConfig{
+		BaseURL:         "http://loadbalancer:3030/",
+		BearerTokenFile: "/var/run/secrets/token",
+		Exporters: `prometheusremotewrite:
+  endpoint: http://prometheus:9090/api/v1/write
+  external_labels:
+    collector: {{ .Collector }}
+otlp:
+  endpoint: ${OTLP_ENDPOINT}
+`,
+	}
+	expected := `receivers:
+  prometheus:
+    config:
+      scrape_configs:
+      - job_name: node
+        follow_redirects: true
+        honor_timestamps: true
+        http_sd_configs:
+        - url: http://loadbalancer:3030/jobs/node/targets?collector_id=collector-1
+          authorization:
+            credentials_file: /var/run/secrets/token
+        metrics_path: /metrics
+        relabel_configs:
+        - action: replace
+          regex: (.*):9100
+          replacement: $$1
+          separator: ;
+          source_labels:
+          - __address__
+          target_label: host
+        scheme: http
+        scrape_interval: 30s
+      - job_name: kube state
+        basic_auth:
+          password_file: /etc/secrets/password
+          username: prometheus
+        follow_redirects: true
+        honor_timestamps: true
+        http_sd_configs:
+        - url: http://loadbalancer:3030/jobs/kube%20state/targets?collector_id=collector-1
+          authorization:
+            credentials_file: /var/run/secrets/token
+        metrics_path: /metrics
+        scheme: http
+exporters:
+  prometheusremotewrite:
+    endpoint: http://prometheus:9090/api/v1/write
+    external_labels:
+      collector: collector-1
+  otlp:
+    endpoint: ${OTLP_ENDPOINT}
+service:
+  pipelines:
+    metrics:
+      receivers:
+      - prometheus
+      exporters:
+      - prometheusremotewrite
+      - otlp
+`
+
+	// test
+	out, err := Render(cfg, Options{Collector: "collector-1"})
+
+	// verify
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+}
+
+func TestRenderDefaults(t *testing.T) {
+	// test
+	out, err := Render(testConfig(t), Options{Collector: "collector 2", BaseURL: "https://lb.example"})
+
+	// verify
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "- url: https://lb.example/jobs/node/targets?collector_id=collector+2\n")
+	assert.NotContains(t, string(out), "authorization")
+	assert.Contains(t, string(out), "exporters:\n  logging: {}\n")
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		exporters string
+		opts      Options
+		expected  error
+	}{
+		{"no collector", "", Options{BaseURL: "http://lb"}, ErrNoCollector},
+		{"no base URL", "", Options{Collector: "collector-1"}, ErrNoBaseURL},
+		{"template error", "{{ .Unknown }}", Options{Collector: "collector-1", BaseURL: "http://lb"}, ErrInvalidExporters},
+		{"not a map", "- logging", Options{Collector: "collector-1", BaseURL: "http://lb"}, ErrInvalidExporters},
+		{"empty", "# nothing", Options{Collector: "collector-1", BaseURL: "http://lb"}, ErrInvalidExporters},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// prepare
+			cfg := testConfig(t)
+			cfg.OTelCollector = &config.OTelCollectorConfig{Exporters: tc.exporters}
+
+			// test
+			_, err := Render(cfg, tc.opts)
+
+			// verify
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
+
+// Tests that jobs with inline credentials are not rendered, as the credentials would render as <secret>
+func TestRenderInlineSecrets(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string
+		key  string
+	}{
+		{"basic auth", "basic_auth: {username: prometheus, password: hunter2}", "basic_auth.password"},
+		{"authorization", "authorization: {credentials: hunter2}", "authorization.credentials"},
+		{"bearer token", "bearer_token: hunter2", "authorization.credentials"},
+		{"oauth2", "oauth2: {client_id: prometheus, client_secret: hunter2, token_url: http://auth/token}", "oauth2.client_secret"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// prepare
+			cfg := config.Config{}
+			assert.NoError(t, yaml.UnmarshalStrict([]byte(`
+config:
+  scrape_configs:
+  - job_name: node
+    static_configs:
+    - targets: [node:9100]
+    `+tc.auth+`
+`), &cfg))
+
+			// test
+			_, err := Render(cfg, Options{Collector: "collector-1", BaseURL: "http://lb"})
+
+			// verify
+			assert.ErrorIs(t, err, ErrInlineSecret)
+			assert.Contains(t, err.Error(), tc.key)
+		})
+	}
+}
+
+// Tests that only the jobs of the pools of the collector are rendered
+func TestRenderPools(t *testing.T) {
+	// prepare
+	cfg := testConfig(t)
+	cfg.Pools = []config.CollectorPool{{Name: "kube", Jobs: []string{"kube *"}}}
+	opts := Options{Collector: "collector-1", BaseURL: "http://lb"}
+
+	// test
+	defaultOut, defaultErr := Render(cfg, opts)
+	opts.Pools = []string{"kube"}
+	kubeOut, kubeErr := Render(cfg, opts)
+
+	// verify
+	assert.NoError(t, defaultErr)
+	assert.Contains(t, string(defaultOut), "- job_name: node\n")
+	assert.NotContains(t, string(defaultOut), "kube state")
+	assert.NoError(t, kubeErr)
+	assert.Contains(t, string(kubeOut), "- job_name: kube state\n")
+	assert.NotContains(t, string(kubeOut), "job_name: node")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var (
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "loadbalancer_http_requests_rejected_total",
+		Help: "Number of HTTP requests rejected with 429, by the limit that was hit.",
+	}, []string{"reason"})
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "loadbalancer_http_requests_in_flight",
+		Help: "Number of HTTP requests currently counted against the in-flight limit.",
+	})
+)
+
+// limiterIdleTimeout is how long the limiter of a client is kept after its last request
+const limiterIdleTimeout = 10 * time.Minute
+
+// clientLimiters hands out a token bucket per client; limit <= 0 disables rate limiting
+type clientLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	limiters  map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiters(limit float64, burst int) *clientLimiters {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(limit)))
+	}
+	return &clientLimiters{limit: rate.Limit(limit), burst: burst, limiters: make(map[string]*clientLimiter), lastSweep: time.Now()}
+}
+
+// reserve takes a token for the client and returns how long it has to wait if none is left
+func (c *clientLimiters) reserve(client string, now time.Time) time.Duration {
+	_, delay := c.take(client, now)
+	return delay
+}
+
+// take takes a token for the client like reserve, refund gives it back if the request is not to be counted
+func (c *clientLimiters) take(client string, now time.Time) (refund func(), delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > limiterIdleTimeout {
+		for k, v := range c.limiters {
+			if now.Sub(v.lastSeen) > limiterIdleTimeout {
+				delete(c.limiters, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	l, ok := c.limiters[client]
+	if !ok {
+		l = &clientLimiter{limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.limiters[client] = l
+	}
+	l.lastSeen = now
+	r := l.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return func() {}, delay
+	}
+	return func() { r.CancelAt(now) }, 0
+}
+
+// longLived reports whether the request is an event stream or a sync stream, which hold the connection open
+// on purpose and are not counted against the in-flight limit
+func longLived(r *http.Request) bool {
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ := route.GetPathTemplate()
+		return template == "/events" || template == "/internal/sync"
+	}
+	return false
+}
+
+// inFlightSlot is the slot of a request counted against the in-flight limit
+type inFlightSlot struct {
+	slots chan struct{}
+	held  bool
+}
+
+func (s *inFlightSlot) release() {
+	if s.held {
+		<-s.slots
+		inFlightRequests.Dec()
+		s.held = false
+	}
+}
+
+// acquire waits for a free slot, unless the request goes away first
+func (s *inFlightSlot) acquire(r *http.Request) {
+	select {
+	case s.slots <- struct{}{}:
+		inFlightRequests.Inc()
+		s.held = true
+	case <-r.Context().Done():
+	}
+}
+
+type inFlightSlotKey struct{}
+
+// parkInFlight stops counting a blocking query against the in-flight limit while it waits for a change
+// The returned function counts it again once it wakes up
+func parkInFlight(r *http.Request) func() {
+	slot, ok := r.Context().Value(inFlightSlotKey{}).(*inFlightSlot)
+	if !ok || !slot.held {
+		return func() {}
+	}
+	slot.release()
+	return func() { slot.acquire(r) }
+}
+
+// inFlightMiddleware rejects requests once maxInFlight requests are being served; maxInFlight <= 0 disables it
+// Blocking queries are only counted while they are not waiting for a change, see parkInFlight
+func inFlightMiddleware(maxInFlight int) mux.MiddlewareFunc {
+	slots := make(chan struct{}, int(math.Max(0, float64(maxInFlight))))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxInFlight <= 0 || longLived(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+				inFlightRequests.Inc()
+				slot := &inFlightSlot{slots: slots, held: true}
+				defer slot.release()
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), inFlightSlotKey{}, slot)))
+			default:
+				tooManyRequests(w, "in_flight", time.Second)
+			}
+		})
+	}
+}
+
+// rateLimitMiddleware limits each client, identified by its authenticated name or else its address
+func rateLimitMiddleware(limiters *clientLimiters) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limiters.limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if delay := limiters.reserve(clientKey(r), time.Now()); delay > 0 {
+				tooManyRequests(w, "rate_limit", delay)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientKey(r *http.Request) string {
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		return identity.Name
+	}
+	return clientAddress(r)
+}
+
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, reason string, retryAfter time.Duration) {
+	rejectedRequests.WithLabelValues(reason).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
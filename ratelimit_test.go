@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/auth"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	authenticator = auth.StaticTokens{"col-1-token": "collector-1", "col-2-token": "collector-2"}
+	*rateLimit, *rateLimitBurst = 0.1, 2
+	defer func() {
+		authenticator = nil
+		*rateLimit, *rateLimitBurst = 0, 0
+	}()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	rejected := testutil.ToFloat64(rejectedRequests.WithLabelValues("rate_limit"))
+
+	get := func(token string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+"/jobs/job-a/targets", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// test
+	first, second, third := get("col-1-token"), get("col-1-token"), get("col-1-token")
+	other := get("col-2-token")
+
+	// verify
+	assert.Equal(t, http.StatusOK, first.StatusCode)
+	assert.Equal(t, http.StatusOK, second.StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, third.StatusCode)
+	assert.Equal(t, "10", third.Header.Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, other.StatusCode)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(rejectedRequests.WithLabelValues("rate_limit")))
+}
+
+// Tests that requests failing authentication are limited per address before their credentials are checked
+func TestAuthRateLimit(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	authenticator = auth.StaticTokens{"col-1-token": "collector-1", "col-2-token": "collector-2"}
+	*rateLimit, *rateLimitBurst = 0.1, 2
+	defer func() {
+		authenticator = nil
+		*rateLimit, *rateLimitBurst = 0, 0
+	}()
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	rejected := testutil.ToFloat64(rejectedRequests.WithLabelValues("auth_rate_limit"))
+
+	get := func(token string) int {
+		req, _ := http.NewRequest("GET", srv.URL+"/jobs/job-a/targets", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test
+	authenticated := []int{get("col-1-token"), get("col-2-token"), get("col-1-token")}
+	failed := []int{get("wrong"), get("wrong"), get("wrong")}
+
+	// verify
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, authenticated)
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, failed)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(rejectedRequests.WithLabelValues("auth_rate_limit")))
+}
+
+func TestClientLimitersSweepIdleClients(t *testing.T) {
+	// prepare
+	limiters := newClientLimiters(1, 1)
+	now := time.Now()
+	limiters.reserve("collector-1", now)
+
+	// test
+	limiters.reserve("collector-2", now.Add(limiterIdleTimeout+time.Minute))
+
+	// verify
+	assert.NotContains(t, limiters.limiters, "collector-1")
+	assert.Contains(t, limiters.limiters, "collector-2")
+}
+
+// Tests that blocking queries are not counted against the in-flight limit while they wait for a change
+func TestInFlightBlockingQuery(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		waitForIndex(r, func() uint64 { return 1 })
+	})
+	router.Use(inFlightMiddleware(1))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	blocked := make(chan int)
+	go func() {
+		resp, err := http.Get(srv.URL + "/jobs?index=1&wait=500ms")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		blocked <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// test
+	resp, err := http.Get(srv.URL + "/jobs")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// verify
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, <-blocked)
+	assert.Equal(t, float64(0), testutil.ToFloat64(inFlightRequests))
+}
+
+func TestInFlightLimit(t *testing.T) {
+	// prepare
+	release := make(chan struct{})
+	started := make(chan struct{})
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/internal/sync", func(w http.ResponseWriter, r *http.Request) {})
+	router.Use(inFlightMiddleware(1))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(srv.URL + "/jobs")
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}()
+	<-started
+
+	t.Run("should reject once the limit is reached", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/jobs")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	})
+
+	t.Run("should not count event streams", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/events")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should not count sync streams", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/internal/sync")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should count queries that don't block", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/jobs?index=0")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+	close(release)
+	<-done
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/http-sd-loadbalancer/auth"
+	"github.com/http-sd-loadbalancer/election"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	roleStandalone = "standalone"
+	roleLeader     = "leader"
+	roleFollower   = "follower"
+)
+
+var (
+	errUnknownElectionBackend = errors.New("election backend must be one of lease or file")
+	errInvalidLeaderCA        = errors.New("couldn't parse any certificate from the leader CA file")
+)
+
+// replica tracks the role of this instance; without leader election it is standalone and acts as the leader
+var replica = &replicaState{}
+
+// localRoutes are always served by the replica itself instead of being proxied to the leader
+var localRoutes = map[string]bool{
+	"/readyz":       true,
+	"/metrics":      true,
+	"/debug/state":  true,
+	"/openapi.json": true,
+}
+
+type replicaState struct {
+	mu       sync.RWMutex
+	elected  bool
+	identity string
+	leading  bool
+	leader   string
+	proxy    *httputil.ReverseProxy
+	stopSync context.CancelFunc
+}
+
+// elect marks the replica as taking part in leader election under identity, as a follower until elected
+func (s *replicaState) elect(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elected = true
+	s.identity = identity
+}
+
+// isLeader reports whether this replica allocates targets
+func (s *replicaState) isLeader() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.elected || s.leading
+}
+
+func (s *replicaState) role() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case !s.elected:
+		return roleStandalone, ""
+	case s.leading:
+		return roleLeader, s.identity
+	default:
+		return roleFollower, s.leader
+	}
+}
+
+// leaderProxy returns a proxy to the current leader, or nil when it is unknown
+func (s *replicaState) leaderProxy() *httputil.ReverseProxy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.proxy
+}
+
+func (s *replicaState) setLeader(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = identity
+	s.proxy = nil
+	if identity == s.identity {
+		return
+	}
+	target, err := url.Parse(identity)
+	if err != nil || target.Host == "" {
+		level.Warn(logger).Log("msg", "leader identity is not a URL, reads can't be proxied to it", "leader", identity)
+		return
+	}
+	transport, err := leaderTransport()
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to set up the connection to the leader", "leader", identity, "err", err)
+		return
+	}
+	s.proxy = httputil.NewSingleHostReverseProxy(target)
+	s.proxy.Transport = transport
+	// Event streams and blocking queries are relayed as they come
+	s.proxy.FlushInterval = -1
+	director := s.proxy.Director
+	s.proxy.Director = func(r *http.Request) {
+		director(r)
+		// the follower authorized the caller already, it vouches for it with the sync token since the
+		// leader can't verify the credentials of the caller, e.g. a client certificate presented to the follower
+		if _, ok := auth.FromContext(r.Context()); ok {
+			r.Header.Del("Authorization")
+			if token := syncToken(); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+	}
+}
+
+// leaderTransport connects followers to the leader: its certificate is verified against election.tls-ca-file,
+// or the system roots, and the certificate of web.tls-cert-file is presented to leaders that ask for one
+func leaderTransport() (*http.Transport, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *electionTLSCAFile != "" {
+		pem, err := ioutil.ReadFile(*electionTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errInvalidLeaderCA
+		}
+	}
+	if tlsCerts != nil {
+		cfg.GetClientCertificate = tlsCerts.clientCertificate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return transport, nil
+}
+
+// syncTokens holds the token of election.sync-token-file, nil without one
+var syncTokens *tokenFile
+
+// syncToken returns the token of election.sync-token-file, or an empty string without one
+func syncToken() string {
+	if syncTokens == nil {
+		return ""
+	}
+	syncTokens.mu.RLock()
+	defer syncTokens.mu.RUnlock()
+	return syncTokens.token
+}
+
+// tokenFile serves the token currently on disk
+// It is reloaded by the config watcher whenever a file in its directory changes, like tlsReloader
+type tokenFile struct {
+	path string
+
+	mu    sync.RWMutex
+	token string
+}
+
+func newTokenFile(path string) (*tokenFile, error) {
+	t := &tokenFile{path: path}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload reads the file again; on error the previous token stays in use
+func (t *tokenFile) reload() error {
+	token, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = strings.TrimSpace(string(token))
+	return nil
+}
+
+// watches reports whether a change to path may affect the token, matching its directory like tlsReloader.watches
+func (t *tokenFile) watches(path string) bool {
+	return filepath.Clean(filepath.Dir(path)) == filepath.Clean(filepath.Dir(t.path))
+}
+
+// follow replicates the assignment of the leader, replacing the replication of a previous leader
+func (s *replicaState) follow(ctx context.Context, leader string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopSync != nil {
+		s.stopSync()
+		s.stopSync = nil
+	}
+	if leader == s.identity || s.proxy == nil {
+		return
+	}
+	ctx, s.stopSync = context.WithCancel(ctx)
+	go replicate(ctx, leader)
+}
+
+func (s *replicaState) setLeading(leading bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leading = leading
+}
+
+// newElector builds the elector of the election.* flags, or returns nil when leader election is disabled
+func newElector() (election.Elector, error) {
+	identity := *electionIdentity
+	switch *electionBackend {
+	case "":
+		return nil, nil
+	case "file":
+		return election.FileLock{Path: *electionLockFile, Identity: identity}, nil
+	case "lease":
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, err
+		}
+		return election.Lease{Client: client, Namespace: *electionNamespace, Name: *electionLeaseName, Identity: identity}, nil
+	}
+	return nil, errUnknownElectionBackend
+}
+
+// runElection campaigns for the leadership; the leader allocates targets, followers replicate its assignment
+// and proxy reads to it
+func runElection(ctx context.Context, elector election.Elector) {
+	err := elector.Run(ctx, election.Callbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			replica.setLeading(true)
+			level.Info(logger).Log("msg", "started leading", "identity", *electionIdentity)
+			if lb != nil {
+				lb.RefreshJobs()
+			}
+		},
+		OnStoppedLeading: func() {
+			replica.setLeading(false)
+			level.Info(logger).Log("msg", "stopped leading", "identity", *electionIdentity)
+		},
+		OnNewLeader: func(identity string) {
+			replica.setLeader(identity)
+			replica.follow(ctx, identity)
+			level.Info(logger).Log("msg", "new leader elected", "leader", identity)
+		},
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "leader election failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// followerMiddleware proxies requests of a follower to the leader, which holds the current assignment
+// The follower authenticates and authorizes the requests itself before they are proxied
+func followerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if replica.isLeader() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, _ := route.GetPathTemplate(); localRoutes[template] {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		proxy := replica.leaderProxy()
+		if proxy == nil {
+			http.Error(w, "no leader elected", http.StatusServiceUnavailable)
+			return
+		}
+		if authenticator != nil {
+			var ok bool
+			if r, ok = authenticate(w, r); !ok {
+				return
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+type readiness struct {
+	Role   string `json:"role"`
+	Leader string `json:"leader,omitempty"`
+}
+
+// readyzHandler reports the role of the replica; a follower is only ready once it knows the leader
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	role, leader := replica.role()
+	w.Header().Set("Content-Type", "application/json")
+	if role == roleFollower && replica.leaderProxy() == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness{Role: role, Leader: leader})
+}
+
+// defaultIdentity is the URL of this replica made of its hostname and the port it listens on
+func defaultIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	_, port, err := net.SplitHostPort(*listenAddress)
+	if err != nil {
+		port = "3030"
+	}
+	scheme := "http"
+	if tlsCerts != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(hostname, port)
+}
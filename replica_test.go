@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/http-sd-loadbalancer/auth"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// initTestFollower turns the replica into a follower of leader, or of no one when leader is empty
+func initTestFollower(t *testing.T, leader string) {
+	t.Helper()
+	replica = &replicaState{elected: true, identity: "http://follower:3030"}
+	if leader != "" {
+		replica.setLeader(leader)
+	}
+	t.Cleanup(func() { replica = &replicaState{} })
+}
+
+func TestFollowerProxiesToLeader(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t)
+	var proxied string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.RequestURI()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jobs":[{"name":"job-a","targets_url":"/api/v1/jobs/job-a/targets"}]}`))
+	}))
+	defer leader.Close()
+	initTestFollower(t, leader.URL)
+	follower := httptest.NewServer(router())
+	defer follower.Close()
+
+	// test
+	resp, err := http.Get(follower.URL + "/api/v1/jobs?index=1")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	// verify
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/api/v1/jobs?index=1", proxied)
+	assert.JSONEq(t, `{"jobs":[{"name":"job-a","targets_url":"/api/v1/jobs/job-a/targets"}]}`, string(body))
+}
+
+// Tests that a follower authorizes the caller and vouches for it to the leader with the sync token
+func TestFollowerVouchesForCaller(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t)
+	var proxied, authorization string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied, authorization = r.URL.RequestURI(), r.Header.Get("Authorization")
+	}))
+	defer leader.Close()
+	tokenFile := filepath.Join(t.TempDir(), "sync-token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("sync-token\n"), 0600))
+	tokens, err := newTokenFile(tokenFile)
+	assert.NoError(t, err)
+	syncTokens = tokens
+	defer func() { syncTokens = nil }()
+	authenticator = auth.StaticTokens{"col-1-token": "collector-1"}
+	defer func() { authenticator = nil }()
+	initTestFollower(t, leader.URL)
+	follower := httptest.NewServer(router())
+	defer follower.Close()
+	get := func(path string) int {
+		req, _ := http.NewRequest("GET", follower.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer col-1-token")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test
+	status := get("/jobs/job-a/targets")
+
+	// verify
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "/jobs/job-a/targets?collector_id=collector-1", proxied)
+	assert.Equal(t, "Bearer sync-token", authorization)
+
+	// test that the follower rejects what the caller may not read
+	proxied = ""
+	status = get("/jobs/job-a/targets?collector_id=collector-2")
+
+	// verify
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Empty(t, proxied)
+}
+
+// Tests that a follower trusts the CA of the leader and presents its certificate to it
+func TestFollowerConnectsToLeaderOverTLS(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := issueCert(t, "internal-ca", nil, true)
+	ca.write(t, caFile, "")
+	leaderCert, leaderKey := filepath.Join(dir, "leader.crt"), filepath.Join(dir, "leader.key")
+	issueCert(t, "leader", ca, false).write(t, leaderCert, leaderKey)
+	followerCert, followerKey := filepath.Join(dir, "follower.crt"), filepath.Join(dir, "follower.key")
+	issueCert(t, "follower", ca, false).write(t, followerCert, followerKey)
+
+	initTestLoadBalancer(t)
+	var caller string
+	leaderTLS, err := newTLSReloader(leaderCert, leaderKey, caFile, true)
+	assert.NoError(t, err)
+	leader := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller = clientCertIdentity(r)
+	}))
+	leader.TLS = leaderTLS.config()
+	leader.StartTLS()
+	defer leader.Close()
+
+	defer func(previous *tlsReloader, file string) { tlsCerts, *electionTLSCAFile = previous, file }(tlsCerts, *electionTLSCAFile)
+	tlsCerts, err = newTLSReloader(followerCert, followerKey, "", false)
+	assert.NoError(t, err)
+	*electionTLSCAFile = caFile
+	initTestFollower(t, leader.URL)
+	follower := httptest.NewServer(router())
+	defer follower.Close()
+
+	// test
+	resp, err := http.Get(follower.URL + "/jobs")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// verify
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "follower", caller)
+}
+
+func TestFollowerWithoutLeader(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	initTestFollower(t, "")
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+
+	for path, expected := range map[string]int{
+		"/jobs":         http.StatusServiceUnavailable,
+		"/api/v1/jobs":  http.StatusServiceUnavailable,
+		"/readyz":       http.StatusServiceUnavailable,
+		"/metrics":      http.StatusOK,
+		"/debug/state":  http.StatusOK,
+		"/openapi.json": http.StatusOK,
+	} {
+		// test
+		resp, err := http.Get(srv.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		// verify
+		assert.Equal(t, expected, resp.StatusCode, path)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name     string
+		replica  *replicaState
+		leader   string
+		status   int
+		expected readiness
+	}{
+		{
+			name:     "standalone",
+			replica:  &replicaState{},
+			status:   http.StatusOK,
+			expected: readiness{Role: roleStandalone},
+		},
+		{
+			name:     "leader",
+			replica:  &replicaState{elected: true, identity: "http://lb-0:3030", leading: true},
+			leader:   "http://lb-0:3030",
+			status:   http.StatusOK,
+			expected: readiness{Role: roleLeader, Leader: "http://lb-0:3030"},
+		},
+		{
+			name:     "follower",
+			replica:  &replicaState{elected: true, identity: "http://lb-1:3030"},
+			leader:   "http://lb-0:3030",
+			status:   http.StatusOK,
+			expected: readiness{Role: roleFollower, Leader: "http://lb-0:3030"},
+		},
+		{
+			name:     "follower without leader",
+			replica:  &replicaState{elected: true, identity: "http://lb-1:3030"},
+			status:   http.StatusServiceUnavailable,
+			expected: readiness{Role: roleFollower},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// prepare
+			replica = tc.replica
+			defer func() { replica = &replicaState{} }()
+			if tc.leader != "" {
+				replica.setLeader(tc.leader)
+			}
+			rec := httptest.NewRecorder()
+
+			// test
+			readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			// verify
+			var actual readiness
+			assert.Equal(t, tc.status, rec.Code)
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&actual))
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestSyncTokenReload(t *testing.T) {
+	// prepare
+	tokenFile := filepath.Join(t.TempDir(), "sync-token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("sync-token\n"), 0600))
+	tokens, err := newTokenFile(tokenFile)
+	assert.NoError(t, err)
+	syncTokens = tokens
+	defer func() { syncTokens = nil }()
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("rotated-token\n"), 0600))
+
+	// test
+	before := syncToken()
+	assert.True(t, tokens.watches(filepath.Join(filepath.Dir(tokenFile), "..data")))
+	assert.NoError(t, tokens.reload())
+
+	// verify
+	assert.Equal(t, "sync-token", before)
+	assert.Equal(t, "rotated-token", syncToken())
+
+	// test that a failed reload keeps the token
+	assert.NoError(t, os.Remove(tokenFile))
+	assert.Error(t, tokens.reload())
+
+	// verify
+	assert.Equal(t, "rotated-token", syncToken())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/http-sd-loadbalancer/client"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// syncRetryInterval is how long a follower waits before reconnecting to the leader
+const syncRetryInterval = 5 * time.Second
+
+var syncErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "loadbalancer_sync_errors_total",
+	Help: "Failures of a follower to replicate the assignment of the leader, by reason.",
+}, []string{"reason"})
+
+// syncHandler streams the assignment to followers as Server-Sent Events: a snapshot first, then a delta
+// whenever the assignment changes; the id of every event is its generation
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	changed := lb.Changed()
+	current := lb.SyncSnapshot(*electionIdentity)
+	writeSync(w, current)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+			flusher.Flush()
+			continue
+		case <-r.Context().Done():
+			return
+		}
+		changed = lb.Changed()
+		next := lb.SyncSnapshot(*electionIdentity)
+		delta, ok := loadbalancer.NewSyncDelta(current, next)
+		current = next
+		if !ok {
+			continue
+		}
+		writeSync(w, delta)
+		flusher.Flush()
+	}
+}
+
+func writeSync(w http.ResponseWriter, m loadbalancer.SyncMessage) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	event := "delta"
+	if m.Snapshot {
+		event = "snapshot"
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.Generation, event, data)
+}
+
+// replicate applies the assignment streamed by the leader, reconnecting until ctx is done
+// Every connection starts from a snapshot, so a follower that missed deltas catches up on reconnect
+func replicate(ctx context.Context, leader string) {
+	for {
+		c, err := syncClient(leader)
+		if err == nil {
+			err = c.Sync(ctx, lb.ApplySync)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		syncErrors.WithLabelValues(syncErrorReason(err)).Inc()
+		level.Error(logger).Log("msg", "failed to replicate the assignment of the leader", "leader", leader, "err", err)
+		select {
+		case <-time.After(syncRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncClient connects to the leader, with the token of election.sync-token-file when the API is authenticated
+func syncClient(leader string) (*client.Client, error) {
+	transport, err := leaderTransport()
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.Option{client.WithHTTPClient(&http.Client{Transport: transport})}
+	if token := syncToken(); token != "" {
+		opts = append(opts, client.WithBearerToken(token))
+	}
+	return client.New(leader, opts...)
+}
+
+func syncErrorReason(err error) string {
+	switch {
+	case errors.Is(err, loadbalancer.ErrSplitBrain):
+		return "split_brain"
+	case errors.Is(err, loadbalancer.ErrStaleGeneration):
+		return "stale_generation"
+	case errors.Is(err, loadbalancer.ErrGenerationGap):
+		return "generation_gap"
+	}
+	return "stream"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/http-sd-loadbalancer/client"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	loadbalancer "github.com/http-sd-loadbalancer/mode"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncStream(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	assert.NoError(t, err)
+	follower := loadbalancer.Init(log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages := make(chan loadbalancer.SyncMessage)
+	go c.Sync(ctx, func(m loadbalancer.SyncMessage) error {
+		err := follower.ApplySync(m)
+		messages <- m
+		return err
+	})
+	receive := func() loadbalancer.SyncMessage {
+		select {
+		case m := <-messages:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("no sync message received")
+		}
+		return loadbalancer.SyncMessage{}
+	}
+
+	// test
+	snapshot := receive()
+	_, err = lb.Drain("collector-1")
+	assert.NoError(t, err)
+	delta := receive()
+
+	// verify
+	assert.True(t, snapshot.Snapshot)
+	assert.Len(t, snapshot.Assigned, 2)
+	assert.False(t, delta.Snapshot)
+	assert.Equal(t, snapshot.Generation, delta.Base)
+	assert.Equal(t, []loadbalancer.SyncAssignment{{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}, Collector: "collector-2"}}, delta.Assigned)
+	assert.Equal(t, lb.State().TargetItemMap, follower.State().TargetItemMap)
+	assert.Equal(t, lb.State().Generation, follower.State().Generation)
+}
+
+// Tests that shutting down the leader ends the sync streams of its followers
+func TestSyncStreamShutdown(t *testing.T) {
+	// prepare
+	initTestLoadBalancer(t, lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}})
+	srv := httptest.NewUnstartedServer(router())
+	s := newServer("", srv.Config.Handler)
+	srv.Config = s
+	srv.Start()
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	assert.NoError(t, err)
+	received := make(chan struct{}, 1)
+	ended := make(chan error, 1)
+	go func() {
+		ended <- c.Sync(context.Background(), func(m loadbalancer.SyncMessage) error {
+			received <- struct{}{}
+			return nil
+		})
+	}()
+	<-received
+
+	// test
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = s.Shutdown(ctx)
+
+	// verify
+	assert.NoError(t, err)
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync stream not ended")
+	}
+}
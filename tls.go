@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/http-sd-loadbalancer/auth"
+)
+
+var (
+	errInvalidClientCA = errors.New("couldn't parse any certificate from the client CA file")
+	// errClientCARequired is returned when client certificates are required but there is no CA to verify them
+	errClientCARequired = errors.New("web.tls-require-client-cert needs web.tls-client-ca-file")
+)
+
+// tlsReloader serves the certificate and client CA bundle currently on disk
+// It is reloaded by the config watcher whenever a file in their directories changes
+type tlsReloader struct {
+	certFile, keyFile, clientCAFile string
+	requireClientCert               bool
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newTLSReloader(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tlsReloader, error) {
+	if requireClientCert && clientCAFile == "" {
+		return nil, errClientCARequired
+	}
+	t := &tlsReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, requireClientCert: requireClientCert}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload reads the files again; on error the previous certificate and CA bundle stay in use
+func (t *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if t.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errInvalidClientCA
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cert = &cert
+	t.clientCAs = clientCAs
+	return nil
+}
+
+// dirs returns the directories to watch for changes
+func (t *tlsReloader) dirs() []string {
+	dirs := []string{filepath.Dir(t.certFile), filepath.Dir(t.keyFile)}
+	if t.clientCAFile != "" {
+		dirs = append(dirs, filepath.Dir(t.clientCAFile))
+	}
+	return dirs
+}
+
+// watches reports whether a change to path may affect the certificate or the CA bundle
+// Whole directories are matched since mounted secrets are swapped through symlinks
+func (t *tlsReloader) watches(path string) bool {
+	for _, dir := range t.dirs() {
+		if filepath.Clean(filepath.Dir(path)) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// config returns a TLS configuration resolving the current certificate and CA bundle on every handshake
+func (t *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*t.cert}}
+			if t.clientCAs != nil {
+				cfg.ClientCAs = t.clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if t.requireClientCert {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientCertificate presents the current certificate to servers that ask for a client certificate of its CA
+func (t *tlsReloader) clientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if info.SupportsCertificate(t.cert) != nil {
+		return &tls.Certificate{}, nil
+	}
+	return t.cert, nil
+}
+
+// clientCertIdentity returns the common name of a verified client certificate, which identifies the collector
+func clientCertIdentity(r *http.Request) string {
+	identity, _ := auth.ClientCertificate{}.Authenticate(r)
+	return identity.Name
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/http-sd-loadbalancer/auth"
+	lbdiscovery "github.com/http-sd-loadbalancer/discovery"
+	"github.com/http-sd-loadbalancer/grpcapi"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert creates a certificate for cn signed by parent, or self-signed when parent is nil
+func issueCert(t testing.TB, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t testing.TB, certFile, keyFile string) {
+	t.Helper()
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestRequireClientCertWithoutCA(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	issueCert(t, "loadbalancer", nil, false).write(t, certFile, keyFile)
+
+	// test
+	_, err := newTLSReloader(certFile, keyFile, "", true)
+
+	// verify
+	assert.ErrorIs(t, err, errClientCARequired)
+}
+
+func TestMutualTLS(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca := issueCert(t, "test-ca", nil, true)
+	ca.write(t, caFile, "")
+	issueCert(t, "loadbalancer", ca, false).write(t, certFile, keyFile)
+
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	reloader, err := newTLSReloader(certFile, keyFile, caFile, true)
+	assert.NoError(t, err)
+	srv := httptest.NewUnstartedServer(router())
+	srv.TLS = reloader.config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientFor := func(cert *testCert) *http.Client {
+		cfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+
+	t.Run("should identify the collector from its certificate", func(t *testing.T) {
+		resp, err := clientFor(issueCert(t, "collector-2", ca, false)).Get(srv.URL + "/jobs/job-a/targets")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var tgs []lbdiscovery.TargetGroup
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&tgs))
+		assert.Equal(t, []string{"targ:1001"}, tgs[0].Targets)
+	})
+
+	t.Run("should reject clients without a certificate", func(t *testing.T) {
+		_, err := clientFor(nil).Get(srv.URL + "/jobs")
+		assert.Error(t, err)
+	})
+
+	t.Run("should reject certificates from another CA", func(t *testing.T) {
+		other := issueCert(t, "other-ca", nil, true)
+		_, err := clientFor(issueCert(t, "collector-1", other, false)).Get(srv.URL + "/jobs")
+		assert.Error(t, err)
+	})
+
+	t.Run("should serve the new certificate after a reload", func(t *testing.T) {
+		rotated := issueCert(t, "loadbalancer-rotated", ca, false)
+		rotated.write(t, certFile, keyFile)
+		assert.True(t, reloader.watches(certFile))
+		assert.NoError(t, reloader.reload())
+
+		client := clientFor(issueCert(t, "collector-1", ca, false))
+		resp, err := client.Get(srv.URL + "/jobs")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "loadbalancer-rotated", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+}
+
+// Tests that the gRPC API is served over TLS and identifies collectors by their certificate
+func TestGRPCMutualTLS(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca := issueCert(t, "test-ca", nil, true)
+	ca.write(t, caFile, "")
+	issueCert(t, "loadbalancer", ca, false).write(t, certFile, keyFile)
+
+	initTestLoadBalancer(t,
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1000", Labels: model.LabelSet{}},
+		lbdiscovery.TargetData{JobName: "job-a", Target: "targ:1001", Labels: model.LabelSet{}},
+	)
+	reloader, err := newTLSReloader(certFile, keyFile, caFile, true)
+	assert.NoError(t, err)
+	defer func(previous *tlsReloader) { tlsCerts = previous }(tlsCerts)
+	tlsCerts = reloader
+	authenticator = auth.ClientCertificate{}
+	defer func() { authenticator = nil }()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := newGRPCServer()
+	go s.Serve(listener)
+	defer s.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientFor := func(cert *testCert) grpcapi.LoadBalancerClient {
+		cfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+		}
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+		assert.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return grpcapi.NewLoadBalancerClient(conn)
+	}
+
+	t.Run("should identify the collector from its certificate", func(t *testing.T) {
+		resp, err := clientFor(issueCert(t, "collector-2", ca, false)).GetTargets(context.Background(), &grpcapi.GetTargetsRequest{Job: "job-a"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.TargetGroups, 1)
+		assert.Equal(t, []string{"targ:1001"}, resp.TargetGroups[0].Targets)
+	})
+
+	t.Run("should reject clients without a certificate", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := clientFor(nil).ListJobs(ctx, &grpcapi.ListJobsRequest{})
+		assert.Error(t, err)
+	})
+}